@@ -2,32 +2,50 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"sort"
 	"strconv"
-	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/mailer"
+	"github.com/thegouge/go-chirpy/internal/moderation"
+	"github.com/thegouge/go-chirpy/internal/polka"
+	"github.com/thegouge/go-chirpy/internal/webhooks"
 )
 
 type apiConfig struct {
-	fileserverHits int
+	fileserverHits atomic.Int64
+	metrics        *serverMetrics
+	metricsToken   string
 	db             *database.DB
 	secret         string
-	polkaKey       string
+	polka          polka.Verifier
+	mailer         mailer.Mailer
+	publicURL      string
+	webhooks       *webhooks.Dispatcher
+	moderation     moderation.Pipeline
+
+	requireVerifiedEmail bool
+	loginThrottle        *loginThrottle
+	rateLimitEnabled     bool
 }
 
 func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, Request *http.Request) {
 	w.Header().Add("Content-Type", "text/html")
 	w.WriteHeader(200)
 
-	w.Write([]byte(fmt.Sprintf("<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p></body></html>", cfg.fileserverHits)))
+	w.Write([]byte(fmt.Sprintf("<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p></body></html>", cfg.fileserverHits.Load())))
 }
 
 func (cfg *apiConfig) resetHandler(w http.ResponseWriter, Request *http.Request) {
-	cfg.fileserverHits = 0
+	cfg.fileserverHits.Store(0)
 
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(200)
@@ -37,7 +55,7 @@ func (cfg *apiConfig) resetHandler(w http.ResponseWriter, Request *http.Request)
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits++
+		cfg.fileserverHits.Add(1)
 
 		next.ServeHTTP(w, r)
 	})
@@ -45,59 +63,232 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 
 func (cfg *apiConfig) chirpValidationHandler(w http.ResponseWriter, r *http.Request) {
 	type validationParams struct {
-		Body string `json:"body"`
+		Body    string `json:"body"`
+		ReplyTo int    `json:"reply_to"`
 	}
 	type validResponse struct {
 		Id   int    `json:"id"`
 		Body string `json:"body"`
 	}
 
-	auth := r.Header.Get("Authorization")
-
-	if auth == "" {
-		respondWithError(w, 401, "You need to be logged in to chirp!")
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_CHIRPS_WRITE)
+	if !ok {
+		return
 	}
 
-	bearerlessToken := strings.Split(auth, " ")[1]
+	id := caller.UserId
 
 	decoder := json.NewDecoder(r.Body)
 	params := validationParams{}
 
 	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error decoding Chirp: %v", err))
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	author, exists, err := cfg.db.GetUserById(id)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
 		return
 	}
 
-	if len(params.Body) > 140 {
-		respondWithError(w, 400, "Chirp is too long")
+	if !exists {
+		respondWithError(w, r, 401, "Something went wrong authenticating user")
 		return
 	}
 
-	id, err := cfg.db.VerifyAccessToken(bearerlessToken, cfg.secret)
+	if respondIfRestricted(w, r, author.CheckRestricted()) {
+		return
+	}
 
-	if id == -1 || err != nil {
-		respondWithError(w, 401, "Something went wrong authenticating user")
+	authorPerks := perksFor(author)
+
+	if utf8.RuneCountInString(params.Body) > authorPerks.MaxChirpLength {
+		respondWithFieldErrors(w, r, chirpTooLong(authorPerks))
+		return
+	}
+
+	if cfg.requireVerifiedEmail && !author.EmailVerified {
+		respondWithCode(w, r, 403, "email_not_verified", "You need to verify your email before you can chirp")
+		return
 	}
 
-	createdChirp, err := cfg.db.CreateChirp(cleanString(params.Body), id)
+	recentChirps, earliest, err := cfg.db.CountChirpsSince(id, time.Now().Add(-time.Hour))
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error saving Chirp to database: %v", err))
+		respondWithErrorFrom(w, r, err)
 		return
 	}
 
-	respBody := createdChirp
+	if recentChirps >= authorPerks.ChirpsPerHour {
+		retryAfter := int(math.Ceil(time.Until(earliest.Add(time.Hour)).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+		respondWithCode(w, r, 429, "chirp_quota_exceeded", fmt.Sprintf("You can only chirp %d times an hour", authorPerks.ChirpsPerHour))
+		return
+	}
+
+	moderated, ok := cfg.moderateChirp(w, r, params.Body)
+	if !ok {
+		return
+	}
+
+	if params.ReplyTo != 0 {
+		parent, exists, err := cfg.db.GetChirp(params.ReplyTo)
+		if err != nil {
+			respondWithErrorFrom(w, r, err)
+			return
+		}
+
+		visibility, err := cfg.chirpVisibilityFor(&caller)
+		if err != nil {
+			respondWithErrorFrom(w, r, err)
+			return
+		}
+
+		if !exists || !visibility.canSee(parent) {
+			respondWithError(w, r, 404, fmt.Sprintf("Unable to find chirp with ID: %d", params.ReplyTo))
+			return
+		}
+	}
+
+	createdChirp, err := cfg.db.CreateChirp(moderated.Body, id, params.ReplyTo, moderated.Reasons(moderation.ActionFlag))
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithCode(w, r, 403, "blocked", "You can't reply to someone who has blocked you")
+		return
+	}
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	cfg.publishChirpCreated(r.Context(), createdChirp)
+	cfg.reportFlaggedChirp(r.Context(), createdChirp)
+
+	respBody := moderatedChirp{Chirp: createdChirp, Moderation: moderated.Findings}
 
 	respondWithJson(w, 201, respBody)
 }
 
+func (cfg *apiConfig) editChirp(w http.ResponseWriter, r *http.Request) {
+	type editParams struct {
+		Body string `json:"body"`
+	}
+
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_CHIRPS_WRITE)
+	if !ok {
+		return
+	}
+
+	param := chi.URLParam(r, "chirpId")
+	chirpID, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := editParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	chirp, exists, err := cfg.db.GetChirp(chirpID)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if !exists {
+		respondWithError(w, r, 404, fmt.Sprintf("Unable to find chirp with ID: %s", param))
+		return
+	}
+
+	if chirp.AuthorId != caller.UserId {
+		respondWithError(w, r, 403, "You are not authorized to edit that chirp")
+		return
+	}
+
+	author, _, err := cfg.db.GetUserById(caller.UserId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	authorPerks := perksFor(author)
+
+	if authorPerks.EditWindow == 0 {
+		respondWithCode(w, r, 403, "perk_required", "Editing chirps is a Chirpy Red perk")
+		return
+	}
+
+	if time.Since(chirp.CreatedAt) > authorPerks.EditWindow {
+		respondWithCode(w, r, 403, "edit_window_closed", fmt.Sprintf("Chirps can only be edited for %v after posting", authorPerks.EditWindow))
+		return
+	}
+
+	if utf8.RuneCountInString(params.Body) > authorPerks.MaxChirpLength {
+		respondWithFieldErrors(w, r, chirpTooLong(authorPerks))
+		return
+	}
+
+	moderated, ok := cfg.moderateChirp(w, r, params.Body)
+	if !ok {
+		return
+	}
+
+	editedChirp, err := cfg.db.EditChirp(caller.UserId, chirpID, moderated.Body, moderated.Reasons(moderation.ActionFlag))
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	cfg.reportFlaggedChirp(r.Context(), editedChirp)
+
+	respondWithJson(w, 200, moderatedChirp{Chirp: editedChirp, Moderation: moderated.Findings})
+}
+
 func (cfg *apiConfig) getAllChirps(w http.ResponseWriter, r *http.Request) {
-	chirps, err := cfg.db.GetChirps()
+	viewer, ok := cfg.optionalScope(w, r, database.SCOPE_CHIRPS_READ)
+	if !ok {
+		return
+	}
+
+	allChirps, err := cfg.db.GetChirps()
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error reading the database: %v", err))
+		respondWithErrorFrom(w, r, err)
 		return
 	}
 
+	visibility, err := cfg.chirpVisibilityFor(viewer)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	// muted authors are left out of the feed, but can still be looked up directly
+	authorId := 0
+	if stringAuthor := r.URL.Query().Get("author_id"); stringAuthor != "" {
+		authorId, err = strconv.Atoi(stringAuthor)
+		if err != nil {
+			respondWithCode(w, r, 400, "invalid_parameter", "invalid author id")
+			return
+		}
+	}
+
+	chirps := make([]database.Chirp, 0, len(allChirps))
+	for _, chirp := range allChirps {
+		if authorId != 0 && chirp.AuthorId != authorId {
+			continue
+		}
+
+		if (authorId != 0 && visibility.canSee(chirp)) || (authorId == 0 && visibility.inFeed(chirp)) {
+			chirps = append(chirps, chirp)
+		}
+	}
+
 	sortDir := r.URL.Query().Get("sort")
 
 	if sortDir == "desc" {
@@ -110,49 +301,42 @@ func (cfg *apiConfig) getAllChirps(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	stringAuthor := r.URL.Query().Get("author_id")
-
-	if stringAuthor != "" {
-		authorId, err := strconv.Atoi(r.URL.Query().Get("author_id"))
-
-		if err != nil {
-			respondWithJson(w, 400, "invalid author id")
-		}
-
-		filtered := make([]database.Chirp, 0)
-		for _, val := range chirps {
-			if val.AuthorId == authorId {
-				filtered = append(filtered, val)
-			}
-		}
-		chirps = filtered
-	}
-
 	respondWithJson(w, 200, chirps)
 }
 
 func (cfg *apiConfig) getChirpByID(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := cfg.optionalScope(w, r, database.SCOPE_CHIRPS_READ)
+	if !ok {
+		return
+	}
+
 	param := chi.URLParam(r, "chirpId")
 	chirpID, err := strconv.Atoi(param)
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("Error parsing parameter: %v", err))
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
 		return
 	}
 
 	allChirps, err := cfg.db.GetChirps()
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error reading the database: %v", err))
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	visibility, err := cfg.chirpVisibilityFor(viewer)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
 		return
 	}
 
 	for _, chirp := range allChirps {
-		if chirp.Id == chirpID {
+		if chirp.Id == chirpID && visibility.canSee(chirp) {
 			respondWithJson(w, 200, chirp)
 			return
 		}
 	}
 
-	respondWithError(w, 404, fmt.Sprintf("Unable to find chirp with ID: %s", param))
+	respondWithError(w, r, 404, fmt.Sprintf("Unable to find chirp with ID: %s", param))
 }
 
 type fullUser struct {
@@ -167,38 +351,88 @@ func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 
 	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error decoding User Object: %v", err))
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	fieldErrors := []fieldError{}
+	if !validEmail(params.Email) {
+		fieldErrors = append(fieldErrors, invalidEmail)
+	}
+	if passwordErr, ok := passwordProblem(params.Password); !ok {
+		fieldErrors = append(fieldErrors, passwordErr)
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, r, fieldErrors...)
 		return
 	}
 
 	_, exists, err := cfg.db.GetUserByEmail(params.Email)
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error Checking if User Exists: %v", err))
+		respondWithErrorFrom(w, r, err)
 		return
 	}
 
 	if exists {
-		respondWithError(w, 400, "A user already exists with that Email")
+		respondWithErrorFrom(w, r, database.ErrEmailTaken)
 		return
 	}
 
 	createdUser, err := cfg.db.CreateUser(params.Email, params.Password)
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error saving User to database: %v", err))
+		respondWithErrorFrom(w, r, err)
 		return
 	}
 
+	err = cfg.sendVerificationEmail(createdUser.Id)
+	if err != nil {
+		loggerFrom(r.Context()).Error("Error sending verification email", "user_id", createdUser.Id, "error", err)
+	}
+
 	respBody := createdUser
 
 	respondWithJson(w, 201, respBody)
 }
 
+type profileResponse struct {
+	Id          int    `json:"id"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Badge       string `json:"badge,omitempty"`
+}
+
+func (cfg *apiConfig) getUserProfile(w http.ResponseWriter, r *http.Request) {
+	param := chi.URLParam(r, "userId")
+	userId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	user, exists, err := cfg.db.GetUserById(userId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if !exists {
+		respondWithError(w, r, 404, fmt.Sprintf("Unable to find user with ID: %s", param))
+		return
+	}
+
+	respondWithJson(w, 200, profileResponse{
+		Id:          user.Id,
+		IsChirpyRed: user.IsChirpyRed(),
+		Badge:       perksFor(user).Badge,
+	})
+}
+
 type UserWithToken struct {
 	Email        string `json:"email"`
 	Id           int    `json:"id"`
 	Token        string `json:"token"`
 	RefreshToken string `json:"refresh_token"`
 	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	Role         string `json:"role"`
 }
 
 func (cfg *apiConfig) logInUser(w http.ResponseWriter, r *http.Request) {
@@ -207,77 +441,124 @@ func (cfg *apiConfig) logInUser(w http.ResponseWriter, r *http.Request) {
 
 	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error decoding User Object: %v", err))
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	ip := clientIP(r)
+
+	err = cfg.loginThrottle.check(ip)
+	if respondIfLocked(w, r, err) {
+		return
+	}
+
+	requestedLifetime := time.Duration(params.ExpiresInSeconds) * time.Second
+
+	response, authUser, err := cfg.db.AuthenticateUser(params.Email, params.Password, cfg.secret, requestedLifetime, ip)
+	if respondIfLocked(w, r, err) {
+		return
+	}
+
+	if respondIfRestricted(w, r, err) {
 		return
 	}
 
-	response, authUser, err := cfg.db.AuthenticateUser(params.Email, params.Password, cfg.secret)
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("Error authenticating user: %v\n", err))
+		respondWithErrorFrom(w, r, err)
 		return
 	}
 
 	if !response {
-		respondWithError(w, 401, "Invalid request")
+		cfg.loginThrottle.recordFailure(ip)
+		respondWithCode(w, r, 401, "invalid_credentials", "Invalid request")
 		return
 	}
 
+	if authUser.MFARequired {
+		respondWithJson(w, 200, mfaChallengeResponse{
+			MFARequired: true,
+			MFAToken:    authUser.MFAToken,
+		})
+		return
+	}
+
+	cfg.loginThrottle.recordSuccess(ip)
+
 	respBody := UserWithToken{
 		Email:        params.Email,
 		Id:           authUser.Id,
 		Token:        authUser.Token,
 		RefreshToken: authUser.RefreshToken,
 		IsChirpyRed:  authUser.IsChirpyRed,
+		Role:         authUser.Role,
 	}
 
 	respondWithJson(w, 200, respBody)
 }
 
 type editedUserResponse struct {
-	Email string `json:"email"`
-	Id    int    `json:"id"`
+	Email         string `json:"email"`
+	Id            int    `json:"id"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get("Authorization")
-
-	if auth == "" {
-		respondWithError(w, 401, "You need to be logged in to edit a user!")
-	}
-
-	bearerlessToken := strings.Split(auth, " ")[1]
-
-	authorized, err := cfg.db.VerifyAccessToken(bearerlessToken, cfg.secret)
-
-	if err != nil {
-		respondWithError(w, 401, "Invalid request")
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_PROFILE_WRITE)
+	if !ok {
 		return
 	}
 
+	authorized := caller.UserId
+
 	if authorized != -1 {
 		decoder := json.NewDecoder(r.Body)
 		params := database.EditingUser{}
 		err := decoder.Decode(&params)
 
 		if err != nil {
-			respondWithError(w, 500, "something went wrong decoding the edit")
+			respondWithInvalidJSON(w, r, err)
 			return
 		}
 
-		editedUser, err := cfg.db.EditUser(authorized, params)
+		// whoever controls the email can reset the password, so both are kept away from API keys and apps
+		if (params.Password != "" || params.Email != "") && !caller.firstParty() {
+			respondWithError(w, r, 403, "You need to be logged in with a password to change your email or password")
+			return
+		}
 
+		fieldErrors := []fieldError{}
+		if params.Email != "" && !validEmail(params.Email) {
+			fieldErrors = append(fieldErrors, invalidEmail)
+		}
+		if passwordErr, ok := passwordProblem(params.Password); params.Password != "" && !ok {
+			fieldErrors = append(fieldErrors, passwordErr)
+		}
+		if len(fieldErrors) > 0 {
+			respondWithFieldErrors(w, r, fieldErrors...)
+			return
+		}
+
+		editedUser, emailChanged, err := cfg.db.EditUser(authorized, params)
 		if err != nil {
-			respondWithError(w, 500, "Something went wrong editing the user")
+			respondWithErrorFrom(w, r, err)
 			return
 		}
 
+		if emailChanged {
+			err = cfg.sendVerificationEmail(authorized)
+			if err != nil {
+				loggerFrom(r.Context()).Error("Error sending verification email", "user_id", authorized, "error", err)
+			}
+		}
+
 		respondWithJson(w, 200, editedUserResponse{
-			Email: editedUser.Email,
-			Id:    authorized,
+			Email:         editedUser.Email,
+			Id:            authorized,
+			EmailVerified: editedUser.EmailVerified,
 		})
 
 	} else {
-		respondWithError(w, 401, "invalid access token")
+		respondWithError(w, r, 401, "invalid access token")
 	}
 
 }
@@ -287,13 +568,16 @@ type tokenResponse struct {
 }
 
 func (cfg *apiConfig) refreshUserToken(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get("Authorization")
-	bearerlessToken := strings.Split(auth, " ")[1]
+	bearerlessToken, err := bearerToken(r)
+	if err != nil {
+		respondWithError(w, r, 401, "Refresh Token invalid")
+		return
+	}
 
 	newAccessToken, err := cfg.db.VerifyRefreshToken(bearerlessToken, cfg.secret)
 
 	if err != nil {
-		respondWithError(w, 401, "Refresh Token invalid")
+		respondWithError(w, r, 401, "Refresh Token invalid")
 		return
 	}
 
@@ -306,86 +590,51 @@ func (cfg *apiConfig) refreshUserToken(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) revokeUserToken(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get("Authorization")
-	bearerlessToken := strings.Split(auth, " ")[1]
+	bearerlessToken, err := bearerToken(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to send the token to revoke")
+		return
+	}
 
-	err := cfg.db.RevokeRefreshToken(bearerlessToken)
+	err = cfg.db.RevokeToken(bearerlessToken)
 	if err != nil {
-		respondWithError(w, 500, "Something has gone wrong revoking token")
+		respondWithErrorFrom(w, r, err)
 	} else {
 		respondWithJson(w, 200, nil)
 	}
 }
 
 func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get("Authorization")
-
-	if auth == "" {
-		respondWithError(w, 401, "You need to be logged in to delete a chirp!")
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_CHIRPS_WRITE)
+	if !ok {
 		return
 	}
 
-	bearerlessToken := strings.Split(auth, " ")[1]
 	param := chi.URLParam(r, "chirpId")
 	chirpID, err := strconv.Atoi(param)
 
 	if err != nil {
-		respondWithError(w, 400, "You need to put in a chirp id!")
-		return
-	}
-
-	err = cfg.db.DeleteChirp(bearerlessToken, cfg.secret, chirpID)
-
-	if err != nil {
-		respondWithError(w, 403, "You are not authorized to delete that chirp")
-		return
-	}
-
-	respondWithJson(w, 200, nil)
-}
-
-type polkaEvent struct {
-	Event string `json:"event"`
-	Data  struct {
-		UserId int `json:"user_id"`
-	} `json:"data"`
-}
-
-func (cfg *apiConfig) handlePayment(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get("Authorization")
-
-	if auth == "" {
-		respondWithError(w, 401, "You need to have proper authorization to pay for chirpy red")
+		respondWithCode(w, r, 400, "invalid_parameter", "You need to put in a chirp id!")
 		return
 	}
 
-	rawToken := strings.Split(auth, " ")[1]
-
-	if rawToken != cfg.polkaKey {
-		respondWithError(w, 401, "Invalid Polka Token")
+	err = cfg.db.DeleteChirp(caller.UserId, chirpID)
+	if errors.Is(err, database.ErrForbidden) {
+		respondWithError(w, r, 403, "You are not authorized to delete that chirp")
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := polkaEvent{}
-
-	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, 400, "Something went wrong parsing request body")
-		return
-	}
-
-	if params.Event != "user.upgraded" {
-		respondWithJson(w, 200, nil)
+		respondWithErrorFrom(w, r, err)
 		return
 	}
 
-	err = cfg.db.UpgradeUser(params.Data.UserId)
-
-	if err != nil {
-		respondWithError(w, 404, "could not find user to upgrade")
-		return
-	}
+	cfg.publishEvent(r.Context(), database.EVENT_CHIRP_DELETED, chirpDeletedEvent{Id: chirpID, AuthorId: caller.UserId}, caller.UserId)
 
 	respondWithJson(w, 200, nil)
 }
+
+type chirpDeletedEvent struct {
+	Id       int `json:"id"`
+	AuthorId int `json:"author_id"`
+}
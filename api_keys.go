@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// apiKeyResponse is an API key without its hash
+type apiKeyResponse struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	// Key is only ever filled in when the key is first created
+	Key string `json:"key,omitempty"`
+}
+
+func toAPIKeyResponse(key database.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		Id:         key.Id,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+func (cfg *apiConfig) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to manage API keys")
+		return
+	}
+
+	keys, err := cfg.db.GetAPIKeys(userId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	response := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, toAPIKeyResponse(key))
+	}
+
+	respondWithJson(w, 200, response)
+}
+
+type createAPIKeyParams struct {
+	Name             string   `json:"name"`
+	Scopes           []string `json:"scopes"`
+	ExpiresInSeconds int      `json:"expires_in_seconds"`
+}
+
+func (cfg *apiConfig) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to manage API keys")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := createAPIKeyParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	fieldErrors := []fieldError{}
+	if params.Name == "" {
+		fieldErrors = append(fieldErrors, requiredField("name", "API keys need a name"))
+	}
+
+	if len(params.Scopes) == 0 {
+		fieldErrors = append(fieldErrors, requiredField("scopes", fmt.Sprintf("API keys need at least one scope out of %v", database.AllScopes)))
+	}
+
+	for _, scope := range params.Scopes {
+		if !database.ValidScope(scope) {
+			fieldErrors = append(fieldErrors, unknownValue("scopes", "scope", scope, database.AllScopes))
+		}
+	}
+
+	if params.ExpiresInSeconds < 0 {
+		fieldErrors = append(fieldErrors, fieldError{Field: "expires_in_seconds", Code: "out_of_range", Message: "expires_in_seconds can't be negative"})
+	}
+
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, r, fieldErrors...)
+		return
+	}
+
+	var expiresAt *time.Time
+	if params.ExpiresInSeconds > 0 {
+		expiry := time.Now().UTC().Add(time.Duration(params.ExpiresInSeconds) * time.Second)
+		expiresAt = &expiry
+	}
+
+	plaintext, key, err := cfg.db.CreateAPIKey(userId, params.Name, params.Scopes, expiresAt)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	response := toAPIKeyResponse(key)
+	response.Key = plaintext
+
+	respondWithJson(w, 201, response)
+}
+
+func (cfg *apiConfig) deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to manage API keys")
+		return
+	}
+
+	param := chi.URLParam(r, "keyId")
+	keyId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	err = cfg.db.DeleteAPIKey(userId, keyId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+const (
+	authViaBearer = "bearer"
+	authViaAPIKey = "apikey"
+)
+
+// principal is whoever a request has been authenticated as
+type principal struct {
+	UserId int
+	// Scopes limits what the credential can do, nil means it can do anything the user can
+	Scopes []string
+	Via    string
+	// ClientId is the third-party app acting for the user, if any
+	ClientId string
+	// Role is only set when the user logged in themselves
+	Role string
+}
+
+func (p principal) can(scope string) bool {
+	return p.Scopes == nil || database.HasScope(p.Scopes, scope)
+}
+
+// firstParty is true when the user logged in themselves, rather than using an API key or a third-party app
+func (p principal) firstParty() bool {
+	return p.Via == authViaBearer && p.ClientId == ""
+}
+
+var errNoCredentials = errors.New("Missing Authorization header")
+
+// authorizationHeader splits an "Authorization: <scheme> <credentials>" header
+func authorizationHeader(r *http.Request) (string, string, error) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return "", "", errNoCredentials
+	}
+
+	scheme, credentials, found := strings.Cut(auth, " ")
+	credentials = strings.TrimSpace(credentials)
+	if !found || credentials == "" {
+		return "", "", errors.New("Malformed Authorization header")
+	}
+
+	return scheme, credentials, nil
+}
+
+// bearerToken pulls the token out of an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, error) {
+	scheme, token, err := authorizationHeader(r)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", errors.New("Expected a Bearer token")
+	}
+
+	return token, nil
+}
+
+// authenticatedUserId verifies the access token on the request and returns the user it belongs to
+// Only a Bearer token from the user logging in themselves is accepted,
+// so API keys and third-party apps can't be used to manage credentials
+func (cfg *apiConfig) authenticatedUserId(r *http.Request) (int, error) {
+	caller, err := cfg.authenticate(r)
+	if err != nil {
+		return -1, err
+	}
+
+	if caller.Via != authViaBearer {
+		return -1, errors.New("Expected a Bearer token")
+	}
+
+	if caller.ClientId != "" {
+		return -1, errors.New("Third-party tokens can't be used here")
+	}
+
+	return caller.UserId, nil
+}
+
+type authenticationContextKey struct{}
+
+// authentication is how checking a request's credentials went
+type authentication struct {
+	caller principal
+	err    error
+}
+
+// middlewareAuthenticate checks the request's credentials once, up front, so rate limiting,
+// requireRole and handlers all share the result rather than each verifying them again
+func (cfg *apiConfig) middlewareAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, err := cfg.verifyCredentials(r)
+		ctx := context.WithValue(r.Context(), authenticationContextKey{}, authentication{caller: caller, err: err})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate returns who middlewareAuthenticate found the request was from,
+// only checking the credentials itself on routes mounted without it
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	if result, ok := r.Context().Value(authenticationContextKey{}).(authentication); ok {
+		return result.caller, result.err
+	}
+
+	return cfg.verifyCredentials(r)
+}
+
+// verifyCredentials accepts either a Bearer access token or an "ApiKey" personal API key
+func (cfg *apiConfig) verifyCredentials(r *http.Request) (principal, error) {
+	scheme, credentials, err := authorizationHeader(r)
+	if err != nil {
+		return principal{}, err
+	}
+
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		userId, grant, err := cfg.db.VerifyAccessGrant(credentials, cfg.secret)
+		if err != nil {
+			return principal{}, err
+		}
+
+		noteUser(r.Context(), userId)
+
+		return principal{UserId: userId, Scopes: grant.Scopes, Via: authViaBearer, ClientId: grant.ClientId, Role: grant.Role}, nil
+	case "apikey":
+		key, err := cfg.db.VerifyAPIKey(credentials)
+		if err != nil {
+			return principal{}, err
+		}
+
+		scopes := key.Scopes
+		if scopes == nil {
+			scopes = []string{}
+		}
+
+		noteUser(r.Context(), key.UserId)
+
+		return principal{UserId: key.UserId, Scopes: scopes, Via: authViaAPIKey}, nil
+	default:
+		return principal{}, errors.New("Unsupported Authorization scheme")
+	}
+}
+
+// requireScope authenticates the request and checks it's allowed to use scope,
+// responding with an error and returning false if not
+func (cfg *apiConfig) requireScope(w http.ResponseWriter, r *http.Request, scope string) (principal, bool) {
+	caller, err := cfg.authenticate(r)
+	if respondIfRestricted(w, r, err) {
+		return principal{}, false
+	}
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to do that")
+		return principal{}, false
+	}
+
+	if !caller.can(scope) {
+		respondWithCode(w, r, 403, "insufficient_scope", "Your credentials don't have the "+scope+" scope")
+		return principal{}, false
+	}
+
+	return caller, true
+}
+
+// optionalScope is like requireScope for endpoints that also work anonymously:
+// no credentials is fine, but credentials that are there have to be valid
+func (cfg *apiConfig) optionalScope(w http.ResponseWriter, r *http.Request, scope string) (*principal, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return nil, true
+	}
+
+	caller, ok := cfg.requireScope(w, r, scope)
+	if !ok {
+		return nil, false
+	}
+
+	return &caller, true
+}
+
+type principalContextKey struct{}
+
+// hasRole reports whether the caller logged in themselves with one of the given roles
+func (p principal) hasRole(roles ...string) bool {
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireRole only lets through requests from users with one of the given roles,
+// making the caller available to handlers through principalFrom
+func (cfg *apiConfig) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, err := cfg.authenticate(r)
+			if respondIfRestricted(w, r, err) {
+				return
+			}
+			if err != nil {
+				respondWithError(w, r, 401, "You need to be logged in to do that")
+				return
+			}
+
+			if !caller.hasRole(roles...) {
+				respondWithError(w, r, 403, "You don't have permission to do that")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), principalContextKey{}, caller)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// principalFrom returns the caller stored by requireRole
+func principalFrom(ctx context.Context) (principal, bool) {
+	caller, ok := ctx.Value(principalContextKey{}).(principal)
+
+	return caller, ok
+}
+
+// requirePrincipal is principalFrom for handlers that act on the caller's authority, so they
+// refuse the request rather than acting for nobody if they're ever mounted without requireRole
+func requirePrincipal(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	caller, ok := principalFrom(r.Context())
+	if !ok {
+		respondWithError(w, r, 401, "You need to be logged in to do that")
+	}
+
+	return caller, ok
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// userRelationHandler handles block, unblock, mute and unmute, which all take the other user from the URL
+func (cfg *apiConfig) userRelationHandler(change func(db *database.DB, userId int, otherId int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := cfg.requireScope(w, r, database.SCOPE_PROFILE_WRITE)
+		if !ok {
+			return
+		}
+
+		param := chi.URLParam(r, "userId")
+		otherId, err := strconv.Atoi(param)
+		if err != nil {
+			respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+			return
+		}
+
+		err = change(cfg.db, caller.UserId, otherId)
+		if err != nil {
+			respondWithErrorFrom(w, r, err)
+			return
+		}
+
+		respondWithJson(w, 200, nil)
+	}
+}
+
+func (cfg *apiConfig) getBlocks(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_PROFILE_WRITE)
+	if !ok {
+		return
+	}
+
+	blocks, err := cfg.db.GetBlocks(caller.UserId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, blocks)
+}
+
+func (cfg *apiConfig) getMutes(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_PROFILE_WRITE)
+	if !ok {
+		return
+	}
+
+	mutes, err := cfg.db.GetMutes(caller.UserId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, mutes)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// relate calls one of the per-user endpoints, like POST /api/users/{userId}/block
+func relate(t *testing.T, token string, handler http.HandlerFunc, method string, otherId int) *httptest.ResponseRecorder {
+	t.Helper()
+
+	id := strconv.Itoa(otherId)
+	r := newRequest(t, method, "/api/users/"+id, token, nil)
+
+	return serve(handler, withURLParams(r, map[string]string{"userId": id}))
+}
+
+func replyTo(t *testing.T, cfg *apiConfig, token string, parentId int, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := newRequest(t, http.MethodPost, "/api/chirps", token, map[string]interface{}{"body": body, "reply_to": parentId})
+	return serve(cfg.chirpValidationHandler, r)
+}
+
+func timeline(t *testing.T, cfg *apiConfig, token string) []database.Chirp {
+	t.Helper()
+
+	w := serve(cfg.getTimeline, newRequest(t, http.MethodGet, "/api/timeline", token, nil))
+	return decodeBody[[]database.Chirp](t, w, 200)
+}
+
+func chirpIds(chirps []database.Chirp) []int {
+	ids := []int{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.Id)
+	}
+
+	return ids
+}
+
+func TestBlockingHidesChirpsAndStopsReplies(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	blocker := signUp(t, cfg, "blocker@example.com")
+	blockerToken := logIn(t, cfg, blocker.Email)
+	blocked := signUp(t, cfg, "blocked@example.com")
+	blockedToken := logIn(t, cfg, blocked.Email)
+
+	block := cfg.userRelationHandler((*database.DB).BlockUser)
+	unblock := cfg.userRelationHandler((*database.DB).UnblockUser)
+
+	chirp := decodeBody[database.Chirp](t, postChirp(t, cfg, blockerToken, "hello"), 201)
+
+	if w := relate(t, "", block, http.MethodPost, blocked.Id); w.Code != 401 {
+		t.Errorf("blocking while logged out = %d, want 401", w.Code)
+	}
+	if w := relate(t, blockerToken, block, http.MethodPost, blocker.Id); w.Code != 400 {
+		t.Errorf("blocking yourself = %d, want 400", w.Code)
+	}
+	if w := relate(t, blockerToken, block, http.MethodPost, blocked.Id); w.Code != 200 {
+		t.Fatalf("blocking = %d, want 200", w.Code)
+	}
+
+	if w := getChirp(t, cfg, blockedToken, chirp.Id); w.Code != 404 {
+		t.Errorf("the blocked user reading the blocker's chirp = %d, want 404", w.Code)
+	}
+	if chirps := listChirps(t, cfg, blockedToken, ""); len(chirps) != 0 {
+		t.Errorf("chirps listed for the blocked user = %v, want none", chirpIds(chirps))
+	}
+	if chirps := listChirps(t, cfg, blockedToken, "?author_id="+strconv.Itoa(blocker.Id)); len(chirps) != 0 {
+		t.Errorf("the blocker's chirps looked up by the blocked user = %v, want none", chirpIds(chirps))
+	}
+	if w := replyTo(t, cfg, blockedToken, chirp.Id, "let me in"); w.Code != 404 {
+		t.Errorf("the blocked user replying = %d, want 404", w.Code)
+	}
+	if w := getChirp(t, cfg, "", chirp.Id); w.Code != 200 {
+		t.Errorf("reading the blocker's chirp anonymously = %d, want 200", w.Code)
+	}
+
+	r := newRequest(t, http.MethodGet, "/api/blocks", blockerToken, nil)
+	blocks := decodeBody[[]database.Block](t, serve(cfg.getBlocks, r), 200)
+	if len(blocks) != 1 || blocks[0].BlockedId != blocked.Id {
+		t.Errorf("blocks = %+v, want the blocked user", blocks)
+	}
+
+	if w := relate(t, blockerToken, unblock, http.MethodDelete, blocked.Id); w.Code != 200 {
+		t.Fatalf("unblocking = %d, want 200", w.Code)
+	}
+	if w := replyTo(t, cfg, blockedToken, chirp.Id, "thanks"); w.Code != 201 {
+		t.Errorf("replying once unblocked = %d, want 201", w.Code)
+	}
+}
+
+func TestBlockingRemovesFollows(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	blocker := signUp(t, cfg, "blocker@example.com")
+	blockerToken := logIn(t, cfg, blocker.Email)
+	blocked := signUp(t, cfg, "blocked@example.com")
+	blockedToken := logIn(t, cfg, blocked.Email)
+
+	if w := relate(t, blockedToken, cfg.followUser, http.MethodPost, blocker.Id); w.Code >= 300 {
+		t.Fatalf("following = %d", w.Code)
+	}
+	decodeBody[database.Chirp](t, postChirp(t, cfg, blockerToken, "for my followers"), 201)
+	if chirps := timeline(t, cfg, blockedToken); len(chirps) != 1 {
+		t.Fatalf("timeline before the block = %v, want the followed chirp", chirpIds(chirps))
+	}
+
+	if w := relate(t, blockerToken, cfg.userRelationHandler((*database.DB).BlockUser), http.MethodPost, blocked.Id); w.Code != 200 {
+		t.Fatalf("blocking = %d, want 200", w.Code)
+	}
+
+	if chirps := timeline(t, cfg, blockedToken); len(chirps) != 0 {
+		t.Errorf("timeline after the block = %v, want nothing", chirpIds(chirps))
+	}
+	if w := relate(t, blockedToken, cfg.followUser, http.MethodPost, blocker.Id); w.Code != 403 {
+		t.Errorf("following someone who blocked you = %d, want 403", w.Code)
+	}
+}
+
+func TestMutingFiltersFeedsOnly(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	muter := signUp(t, cfg, "muter@example.com")
+	muterToken := logIn(t, cfg, muter.Email)
+	muted := signUp(t, cfg, "muted@example.com")
+	mutedToken := logIn(t, cfg, muted.Email)
+
+	mine := decodeBody[database.Chirp](t, postChirp(t, cfg, muterToken, "mine"), 201)
+	theirs := decodeBody[database.Chirp](t, postChirp(t, cfg, mutedToken, "theirs"), 201)
+	if w := relate(t, muterToken, cfg.followUser, http.MethodPost, muted.Id); w.Code >= 300 {
+		t.Fatalf("following = %d", w.Code)
+	}
+
+	mute := cfg.userRelationHandler((*database.DB).MuteUser)
+	if w := relate(t, muterToken, mute, http.MethodPost, muted.Id); w.Code != 200 {
+		t.Fatalf("muting = %d, want 200", w.Code)
+	}
+
+	if ids := chirpIds(listChirps(t, cfg, muterToken, "")); len(ids) != 1 || ids[0] != mine.Id {
+		t.Errorf("chirps listed for the muter = %v, want just their own", ids)
+	}
+	if ids := chirpIds(timeline(t, cfg, muterToken)); len(ids) != 1 || ids[0] != mine.Id {
+		t.Errorf("timeline for the muter = %v, want just their own", ids)
+	}
+
+	// muted chirps can still be looked up directly, and the muted user doesn't notice anything
+	if ids := chirpIds(listChirps(t, cfg, muterToken, "?author_id="+strconv.Itoa(muted.Id))); len(ids) != 1 || ids[0] != theirs.Id {
+		t.Errorf("muted author's chirps by author_id = %v, want %d", ids, theirs.Id)
+	}
+	if w := getChirp(t, cfg, muterToken, theirs.Id); w.Code != 200 {
+		t.Errorf("reading a muted chirp directly = %d, want 200", w.Code)
+	}
+	if ids := chirpIds(listChirps(t, cfg, mutedToken, "")); len(ids) != 2 {
+		t.Errorf("chirps listed for the muted user = %v, want both", ids)
+	}
+
+	r := newRequest(t, http.MethodGet, "/api/mutes", muterToken, nil)
+	mutes := decodeBody[[]database.Mute](t, serve(cfg.getMutes, r), 200)
+	if len(mutes) != 1 || mutes[0].MutedId != muted.Id {
+		t.Errorf("mutes = %+v, want the muted user", mutes)
+	}
+
+	if w := relate(t, muterToken, cfg.userRelationHandler((*database.DB).UnmuteUser), http.MethodDelete, muted.Id); w.Code != 200 {
+		t.Fatalf("unmuting = %d, want 200", w.Code)
+	}
+	if ids := chirpIds(timeline(t, cfg, muterToken)); len(ids) != 2 || ids[0] != theirs.Id {
+		t.Errorf("timeline once unmuted = %v, want both, newest first", ids)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/thegouge/go-chirpy/internal/config"
+)
+
+// configCommand is the config subcommand. `config print` shows the settings the server
+// would run with, secrets redacted, and fails if they wouldn't pass validation
+func configCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: go-chirpy [flags] config print")
+	}
+
+	err := cfg.Print(os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	return nil
+}
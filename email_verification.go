@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/mailer"
+)
+
+// validEmail only accepts a bare address like "someone@example.com"
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return false
+	}
+
+	return address.Name == "" && address.Address == email
+}
+
+// sendVerificationEmail mails the user a link that verifies their current email
+func (cfg *apiConfig) sendVerificationEmail(userId int) error {
+	token, user, err := cfg.db.CreateEmailVerification(userId)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/verify-email?token=%s", cfg.publicURL, url.QueryEscape(token))
+
+	return cfg.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email",
+		Body: fmt.Sprintf("Follow this link to verify your email for Chirpy:\n\n%s\n\nIt expires in %v.",
+			link, database.EMAIL_VERIFICATION_LIFETIME),
+	})
+}
+
+func (cfg *apiConfig) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithFieldErrors(w, r, requiredField("token", "A verification token is required"))
+		return
+	}
+
+	user, err := cfg.db.VerifyEmail(token)
+	if errors.Is(err, database.ErrInvalid) || errors.Is(err, database.ErrNotFound) {
+		respondWithCode(w, r, 400, "invalid_token", "Invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, database.User{
+		Id:            user.Id,
+		Email:         user.Email,
+		IsChirpyRed:   user.IsChirpyRed(),
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+func (cfg *apiConfig) resendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to verify your email")
+		return
+	}
+
+	err = cfg.sendVerificationEmail(userId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 202, nil)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+var verificationLink = regexp.MustCompile(`/api/verify-email\?token=(\S+)`)
+
+// verificationToken pulls the token out of the last verification email sent to the address
+func verificationToken(t *testing.T, outbox *testMailer, to string) string {
+	t.Helper()
+
+	match := verificationLink.FindStringSubmatch(outbox.last(t, to).Body)
+	if match == nil {
+		t.Fatalf("no verification link in the email to %s", to)
+	}
+
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func verifyWith(t *testing.T, cfg *apiConfig, token string) int {
+	r := newRequest(t, http.MethodGet, "/api/verify-email?token="+url.QueryEscape(token), "", nil)
+	return serve(cfg.verifyEmail, r).Code
+}
+
+func TestSignupSendsVerificationEmail(t *testing.T) {
+	cfg, outbox := newTestAPI(t)
+
+	w := serve(cfg.createUser, newRequest(t, http.MethodPost, "/api/users", "", fullUser{Email: "new@example.com", Password: "password"}))
+	created := decodeBody[database.User](t, w, 201)
+	if created.EmailVerified {
+		t.Error("a new user starts out verified")
+	}
+
+	token := verificationToken(t, outbox, "new@example.com")
+
+	w = serve(cfg.verifyEmail, newRequest(t, http.MethodGet, "/api/verify-email?token="+url.QueryEscape(token), "", nil))
+	verified := decodeBody[database.User](t, w, 200)
+	if !verified.EmailVerified || verified.Id != created.Id {
+		t.Errorf("verify-email = %+v, want user %d verified", verified, created.Id)
+	}
+
+	if code := verifyWith(t, cfg, token); code != 400 {
+		t.Errorf("reusing a verification link = %d, want 400", code)
+	}
+}
+
+func TestSignupRejectsInvalidEmail(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+
+	for _, email := range []string{"", "not-an-email", "Someone <someone@example.com>"} {
+		w := serve(cfg.createUser, newRequest(t, http.MethodPost, "/api/users", "", fullUser{Email: email, Password: "password"}))
+		if w.Code != 400 {
+			t.Errorf("signing up with %q = %d, want 400", email, w.Code)
+		}
+	}
+}
+
+func TestChangingEmailNeedsVerifyingAgain(t *testing.T) {
+	cfg, outbox := newTestAPI(t)
+	user := signUp(t, cfg, "old@example.com")
+	token := logIn(t, cfg, user.Email)
+
+	err := cfg.sendVerificationEmail(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldLink := verificationToken(t, outbox, "old@example.com")
+
+	w := serve(cfg.updateUser, newRequest(t, http.MethodPut, "/api/users", token, database.EditingUser{Email: "new@example.com"}))
+	edited := decodeBody[editedUserResponse](t, w, 200)
+	if edited.EmailVerified {
+		t.Error("a changed email is still verified")
+	}
+
+	if code := verifyWith(t, cfg, oldLink); code != 400 {
+		t.Errorf("a link sent to the old email = %d, want 400", code)
+	}
+	if code := verifyWith(t, cfg, verificationToken(t, outbox, "new@example.com")); code != 200 {
+		t.Errorf("a link sent to the new email = %d, want 200", code)
+	}
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+	cfg, outbox := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+
+	w := serve(cfg.resendVerificationEmail, newRequest(t, http.MethodPost, "/api/verify-email/resend", "", nil))
+	if w.Code != 401 {
+		t.Errorf("resending while logged out = %d, want 401", w.Code)
+	}
+
+	token := logIn(t, cfg, user.Email)
+	w = serve(cfg.resendVerificationEmail, newRequest(t, http.MethodPost, "/api/verify-email/resend", token, nil))
+	if w.Code != 202 {
+		t.Fatalf("resending = %d, want 202", w.Code)
+	}
+
+	if code := verifyWith(t, cfg, verificationToken(t, outbox, user.Email)); code != 200 {
+		t.Fatalf("verifying = %d, want 200", code)
+	}
+
+	w = serve(cfg.resendVerificationEmail, newRequest(t, http.MethodPost, "/api/verify-email/resend", token, nil))
+	if w.Code != 409 {
+		t.Errorf("resending once verified = %d, want 409", w.Code)
+	}
+}
+
+func TestRequireVerifiedEmailToChirp(t *testing.T) {
+	cfg, outbox := newTestAPI(t)
+	cfg.requireVerifiedEmail = true
+	user := signUp(t, cfg, "user@example.com")
+	token := logIn(t, cfg, user.Email)
+
+	chirp := map[string]string{"body": "hello"}
+
+	w := serve(cfg.chirpValidationHandler, newRequest(t, http.MethodPost, "/api/chirps", token, chirp))
+	if w.Code != 403 {
+		t.Errorf("chirping unverified = %d, want 403", w.Code)
+	}
+
+	err := cfg.sendVerificationEmail(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := verifyWith(t, cfg, verificationToken(t, outbox, user.Email)); code != 200 {
+		t.Fatalf("verifying = %d, want 200", code)
+	}
+
+	w = serve(cfg.chirpValidationHandler, newRequest(t, http.MethodPost, "/api/chirps", token, chirp))
+	if w.Code != 201 {
+		t.Errorf("chirping verified = %d, want 201: %s", w.Code, w.Body.String())
+	}
+}
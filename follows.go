@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+type followEvent struct {
+	FollowerId int `json:"follower_id"`
+	FolloweeId int `json:"followee_id"`
+}
+
+func (cfg *apiConfig) followUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_PROFILE_WRITE)
+	if !ok {
+		return
+	}
+
+	param := chi.URLParam(r, "userId")
+	followeeId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	followed, err := cfg.db.FollowUser(caller.UserId, followeeId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if followed && !cfg.shadowBanned(r.Context(), caller.UserId) {
+		cfg.publishEvent(r.Context(), database.EVENT_USER_FOLLOWED, followEvent{FollowerId: caller.UserId, FolloweeId: followeeId}, followeeId)
+	}
+
+	respondWithJson(w, 200, nil)
+}
+
+func (cfg *apiConfig) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_PROFILE_WRITE)
+	if !ok {
+		return
+	}
+
+	param := chi.URLParam(r, "userId")
+	followeeId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	err = cfg.db.UnfollowUser(caller.UserId, followeeId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
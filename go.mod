@@ -9,3 +9,5 @@ require golang.org/x/crypto v0.15.0
 require github.com/joho/godotenv v1.5.1
 
 require github.com/golang-jwt/jwt/v5 v5.2.0
+
+require golang.org/x/text v0.14.0
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/mailer"
+	"github.com/thegouge/go-chirpy/internal/webhooks"
+)
+
+const testSecret = "test-secret"
+
+// testMailer keeps every message it's asked to send, or fails with err if it's set
+type testMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+	err  error
+}
+
+func (m *testMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// last returns the most recent message sent to the address
+func (m *testMailer) last(t *testing.T, to string) mailer.Message {
+	t.Helper()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			return m.sent[i]
+		}
+	}
+
+	t.Fatalf("no email sent to %s", to)
+	return mailer.Message{}
+}
+
+// newTestAPI returns an apiConfig backed by a database in a temporary directory
+func newTestAPI(t *testing.T) (*apiConfig, *testMailer) {
+	t.Helper()
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := &testMailer{}
+
+	return &apiConfig{
+		db:        db,
+		secret:    testSecret,
+		mailer:    outbox,
+		publicURL: "http://chirpy.test",
+		webhooks:  webhooks.NewDispatcher(db),
+
+		loginThrottle: newLoginThrottle(),
+	}, outbox
+}
+
+// newRequest builds a request with body encoded as JSON, and the bearer token if there is one
+func newRequest(t *testing.T, method, target, token string, body interface{}) *http.Request {
+	t.Helper()
+
+	var reader *bytes.Reader
+	if body == nil {
+		reader = bytes.NewReader(nil)
+	} else {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(dat)
+	}
+
+	r := httptest.NewRequest(method, target, reader)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return r
+}
+
+// withURLParams sets chi URL parameters the way the router would
+func withURLParams(r *http.Request, params map[string]string) *http.Request {
+	routeCtx := chi.NewRouteContext()
+	for key, value := range params {
+		routeCtx.URLParams.Add(key, value)
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeCtx))
+}
+
+// serve runs the handler and returns what it wrote
+func serve(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// decodeBody unmarshals a JSON response, failing the test if it isn't the expected status
+func decodeBody[T any](t *testing.T, w *httptest.ResponseRecorder, status int) T {
+	t.Helper()
+
+	var body T
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+
+	err := json.Unmarshal(w.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+
+	return body
+}
+
+// signUp creates a user with the password "password" through the database
+func signUp(t *testing.T, cfg *apiConfig, email string) database.User {
+	t.Helper()
+
+	user, err := cfg.db.CreateUser(email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// logIn returns an access token for a user created by signUp
+func logIn(t *testing.T, cfg *apiConfig, email string) string {
+	t.Helper()
+
+	ok, authUser, err := cfg.db.AuthenticateUser(email, "password", cfg.secret, 0, "192.0.2.1")
+	if err != nil || !ok {
+		t.Fatalf("logging in %s: %v, %v", email, ok, err)
+	}
+
+	return authUser.Token
+}
+
+// signUpWithRole creates a user with the given role and returns them with an access token
+func signUpWithRole(t *testing.T, cfg *apiConfig, email string, role string) (database.User, string) {
+	t.Helper()
+
+	user := signUp(t, cfg, email)
+
+	_, err := cfg.db.SetUserRole(user.Id, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, logIn(t, cfg, email)
+}
+
+func signUpAdmin(t *testing.T, cfg *apiConfig, email string) (database.User, string) {
+	t.Helper()
+
+	return signUpWithRole(t, cfg, email, database.ROLE_ADMIN)
+}
+
+// serveWithRole runs the handler behind requireRole, the way the role restricted routes are mounted
+func serveWithRole(cfg *apiConfig, handler http.HandlerFunc, r *http.Request, roles ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	cfg.requireRole(roles...)(handler).ServeHTTP(w, r)
+	return w
+}
+
+// postChirp posts a chirp as whoever the token belongs to
+func postChirp(t *testing.T, cfg *apiConfig, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := newRequest(t, http.MethodPost, "/api/chirps", token, map[string]string{"body": body})
+	return serve(cfg.chirpValidationHandler, r)
+}
@@ -0,0 +1,104 @@
+// Package certs serves a TLS certificate from files on disk, picking up a new
+// certificate when the files change so it can be renewed without a restart
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is how often the files are checked for changes
+const DefaultInterval = 30 * time.Second
+
+// Reloader holds the current certificate for tls.Config.GetCertificate
+type Reloader struct {
+	CertFile string
+	KeyFile  string
+	Interval time.Duration
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// NewReloader loads the certificate straight away, so a bad pair is caught at startup
+func NewReloader(certFile string, keyFile string) (*Reloader, error) {
+	reloader := &Reloader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Interval: DefaultInterval,
+	}
+
+	_, err := reloader.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// GetCertificate hands out the current certificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// Reload loads the certificate again if either file has changed since it was last loaded,
+// reporting whether it did. A pair that doesn't load leaves the current certificate in place.
+func (r *Reloader) Reload() (bool, error) {
+	modTimes := [2]time.Time{}
+	for i, path := range []string{r.CertFile, r.KeyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("reading TLS certificate: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	return true, nil
+}
+
+// Run checks for a new certificate every Interval until ctx is done
+func (r *Reloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := r.Reload()
+		if err != nil {
+			slog.Error("Error reloading TLS certificate, keeping the current one", "error", err)
+		} else if reloaded {
+			slog.Info("Reloaded TLS certificate", "cert_file", r.CertFile)
+		}
+	}
+}
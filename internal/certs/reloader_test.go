@@ -0,0 +1,173 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// keyPair is a self-signed certificate and its key, PEM encoded
+type keyPair struct {
+	der     []byte
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newKeyPair(t *testing.T, name string) keyPair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keyPair{
+		der:     der,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}),
+	}
+}
+
+// write saves the pair, marking the files as modified at modTime so a rewrite
+// is noticed however coarse the filesystem's timestamps are
+func write(t *testing.T, dir string, certPEM []byte, keyPEM []byte, modTime time.Time) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	for path, contents := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		err := os.WriteFile(path, contents, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+func serving(t *testing.T, r *Reloader) []byte {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert.Certificate[0]
+}
+
+func TestReloadPicksUpNewPair(t *testing.T) {
+	dir := t.TempDir()
+	first := newKeyPair(t, "first.example.com")
+	certFile, keyFile := write(t, dir, first.certPEM, first.keyPEM, time.Now().Add(-time.Minute))
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serving(t, r), first.der) {
+		t.Fatal("not serving the first certificate")
+	}
+
+	reloaded, err := r.Reload()
+	if err != nil || reloaded {
+		t.Errorf("Reload() of unchanged files = %v, %v, want false, nil", reloaded, err)
+	}
+
+	second := newKeyPair(t, "second.example.com")
+	write(t, dir, second.certPEM, second.keyPEM, time.Now())
+
+	reloaded, err = r.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("Reload() of a new pair = %v, %v, want true, nil", reloaded, err)
+	}
+	if !bytes.Equal(serving(t, r), second.der) {
+		t.Error("still serving the first certificate after reloading")
+	}
+}
+
+func TestReloadKeepsCurrentOnBrokenPair(t *testing.T) {
+	first := newKeyPair(t, "first.example.com")
+	second := newKeyPair(t, "second.example.com")
+
+	tests := []struct {
+		name    string
+		certPEM []byte
+		keyPEM  []byte
+	}{
+		{"mismatched key", second.certPEM, first.keyPEM},
+		{"garbage certificate", []byte("not a certificate"), first.keyPEM},
+		{"empty key", first.certPEM, []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			certFile, keyFile := write(t, dir, first.certPEM, first.keyPEM, time.Now().Add(-time.Minute))
+
+			r, err := NewReloader(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			write(t, dir, tt.certPEM, tt.keyPEM, time.Now())
+
+			reloaded, err := r.Reload()
+			if err == nil || reloaded {
+				t.Errorf("Reload() = %v, %v, want false and an error", reloaded, err)
+			}
+			if !bytes.Equal(serving(t, r), first.der) {
+				t.Error("stopped serving the working certificate")
+			}
+		})
+	}
+}
+
+func TestNewReloaderRejectsBrokenPair(t *testing.T) {
+	first := newKeyPair(t, "first.example.com")
+	second := newKeyPair(t, "second.example.com")
+	certFile, keyFile := write(t, t.TempDir(), first.certPEM, second.keyPEM, time.Now())
+
+	_, err := NewReloader(certFile, keyFile)
+	if err == nil {
+		t.Error("NewReloader() with a mismatched key succeeded, want an error")
+	}
+
+	_, err = NewReloader(filepath.Join(t.TempDir(), "missing.pem"), keyFile)
+	if err == nil {
+		t.Error("NewReloader() with a missing certificate succeeded, want an error")
+	}
+}
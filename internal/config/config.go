@@ -0,0 +1,366 @@
+// Package config loads the server's settings
+//
+// Every setting can come from a JSON config file, an environment variable or a command line
+// flag, and later sources win: defaults < file < environment < flags. A field tagged
+// `config:"jwt_secret"` is read from the "jwt_secret" key in the file, the JWT_SECRET
+// environment variable and the --jwt-secret flag. Fields also tagged `flagonly:"true"`
+// are only read from the flag.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFile is read if it exists and no other file is given with --config or CHIRPY_CONFIG
+const DefaultFile = "chirpy.json"
+
+type Config struct {
+	Host         string `config:"host" usage:"Address to listen on"`
+	Port         int    `config:"port" usage:"Port to listen on"`
+	DatabasePath string `config:"database_path" usage:"Path to the JSON database file"`
+	PublicURL    string `config:"public_url" usage:"URL the server is reachable at, used in emailed links (default http://<host>:<port>)"`
+	OutboxDir    string `config:"outbox_dir" usage:"Directory outgoing emails are written to"`
+	Debug        bool   `config:"debug" usage:"Log at debug level"`
+
+	ResetDatabase bool `config:"reset_database" flagonly:"true" usage:"Delete the database before starting the server (only as a flag)"`
+
+	TLSCertFile           string        `config:"tls_cert_file" usage:"Certificate file to serve HTTPS with, reloaded when it changes"`
+	TLSKeyFile            string        `config:"tls_key_file" usage:"Private key file for tls_cert_file"`
+	HTTP2                 bool          `config:"http2" usage:"Serve HTTP/2 to clients that support it over HTTPS"`
+	RedirectPort          int           `config:"redirect_port" usage:"Port to redirect plain HTTP requests to HTTPS from (0 is off)"`
+	HSTSMaxAge            time.Duration `config:"hsts_max_age" usage:"How long browsers should only use HTTPS for, sent over HTTPS (0 is off)"`
+	HSTSIncludeSubdomains bool          `config:"hsts_include_subdomains" usage:"Apply HSTS to subdomains too"`
+
+	JWTSecret            string        `config:"jwt_secret" secret:"true" usage:"Secret tokens are signed with (required)"`
+	JWTAudience          string        `config:"jwt_audience" usage:"Audience tokens are issued for"`
+	AccessTokenLifetime  time.Duration `config:"access_token_lifetime" usage:"How long access tokens last"`
+	RefreshTokenLifetime time.Duration `config:"refresh_token_lifetime" usage:"How long refresh tokens last"`
+	RequireVerifiedEmail bool          `config:"require_verified_email" usage:"Only let users with a verified email chirp"`
+
+	PolkaKey         string `config:"polka_key" secret:"true" usage:"Key Polka signs webhooks with"`
+	PolkaKeyPrevious string `config:"polka_key_previous" secret:"true" usage:"Previous Polka key, accepted while rotating keys"`
+	MetricsToken     string `config:"metrics_token" secret:"true" usage:"Bearer token scrapers can read metrics with"`
+
+	ModerationWordList         string   `config:"moderation_wordlist" usage:"File of words chirp moderation catches"`
+	ModerationPatterns         string   `config:"moderation_patterns" usage:"File of regular expressions chirp moderation catches"`
+	ModerationLinks            string   `config:"moderation_links" usage:"What to do with links in chirps: mask, flag or reject"`
+	ModerationAllowedDomains   []string `config:"moderation_allowed_domains" usage:"Comma separated domains links are always allowed to"`
+	ModerationMaxRepeatedChars int      `config:"moderation_max_repeated_chars" usage:"How many times a character can repeat in a row"`
+
+	RateLimit      bool     `config:"rate_limit" usage:"Limit how often each user or IP can make requests"`
+	TrustedProxies []string `config:"trusted_proxies" usage:"Comma separated proxy addresses or CIDR ranges whose X-Forwarded-For is believed"`
+
+	CORSAllowedOrigins   []string      `config:"cors_allowed_origins" usage:"Comma separated origins web pages can call the API from, like https://*.example.com, or * for any"`
+	CORSAllowedHeaders   []string      `config:"cors_allowed_headers" usage:"Comma separated request headers pages on other origins can send"`
+	CORSExposedHeaders   []string      `config:"cors_exposed_headers" usage:"Comma separated response headers pages on other origins can read"`
+	CORSAllowCredentials bool          `config:"cors_allow_credentials" usage:"Let pages on other origins send cookies and HTTP auth"`
+	CORSMaxAge           time.Duration `config:"cors_max_age" usage:"How long browsers can cache a preflight answer"`
+
+	ShutdownTimeout time.Duration `config:"shutdown_timeout" usage:"How long requests get to finish when shutting down"`
+}
+
+func Default() Config {
+	return Config{
+		Host:                       "localhost",
+		Port:                       8000,
+		DatabasePath:               "database.json",
+		OutboxDir:                  "outbox",
+		HTTP2:                      true,
+		HSTSMaxAge:                 365 * 24 * time.Hour,
+		AccessTokenLifetime:        time.Hour,
+		RefreshTokenLifetime:       1440 * time.Hour,
+		JWTAudience:                "chirpy",
+		ModerationWordList:         "moderation/words.txt",
+		ModerationPatterns:         "moderation/patterns.txt",
+		ModerationLinks:            "flag",
+		ModerationAllowedDomains:   []string{},
+		ModerationMaxRepeatedChars: 10,
+		RateLimit:                  true,
+		TrustedProxies:             []string{},
+		CORSAllowedOrigins:         []string{"*"},
+		CORSAllowedHeaders:         []string{"Authorization", "Content-Type", "X-Request-ID"},
+		CORSExposedHeaders:         []string{"X-Request-ID", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
+		CORSMaxAge:                 10 * time.Minute,
+		ShutdownTimeout:            15 * time.Second,
+	}
+}
+
+// Addr is the address the server listens on
+func (c Config) Addr() string {
+	return c.Host + ":" + strconv.Itoa(c.Port)
+}
+
+// RedirectAddr is the address plain HTTP is redirected to HTTPS from
+func (c Config) RedirectAddr() string {
+	return c.Host + ":" + strconv.Itoa(c.RedirectPort)
+}
+
+// TLS reports whether the server speaks HTTPS
+func (c Config) TLS() bool {
+	return c.TLSCertFile != ""
+}
+
+// Load builds the config from the file, environment and flags in args,
+// returning whatever arguments are left after the flags (like a subcommand)
+func Load(programName string, args []string, getenv func(string) string) (Config, []string, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet(programName, flag.ContinueOnError)
+	configFile := flags.String("config", "", "JSON config file to read (default "+DefaultFile+" if it exists, or $CHIRPY_CONFIG)")
+
+	fromFlags := map[string]string{}
+	for _, field := range fields(&cfg) {
+		name := field.name
+		if field.value.Kind() == reflect.Bool {
+			flags.BoolFunc(field.flagName(), field.usage, func(raw string) error {
+				fromFlags[name] = raw
+				return nil
+			})
+			continue
+		}
+
+		flags.Func(field.flagName(), field.usage, func(raw string) error {
+			fromFlags[name] = raw
+			return nil
+		})
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path = getenv("CHIRPY_CONFIG")
+	}
+
+	fromFile, err := readFile(path)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	for _, field := range fields(&cfg) {
+		sources := []struct {
+			name  string
+			value string
+			set   bool
+		}{
+			{"config file", fromFile[field.name], hasKey(fromFile, field.name)},
+			{field.envName(), getenv(field.envName()), getenv(field.envName()) != ""},
+			{"--" + field.flagName(), fromFlags[field.name], hasKey(fromFlags, field.name)},
+		}
+
+		if field.flagOnly {
+			sources = sources[2:]
+		}
+
+		for _, source := range sources {
+			if !source.set {
+				continue
+			}
+
+			err = field.set(source.value)
+			if err != nil {
+				return Config{}, nil, fmt.Errorf("invalid %s from %s: %w", field.name, source.name, err)
+			}
+		}
+	}
+
+	if cfg.PublicURL == "" && cfg.TLS() {
+		cfg.PublicURL = "https://" + cfg.Addr()
+	} else if cfg.PublicURL == "" {
+		cfg.PublicURL = "http://" + cfg.Addr()
+	}
+	cfg.PublicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+
+	return cfg, flags.Args(), nil
+}
+
+// readFile reads the settings from a JSON config file as strings, so they're parsed the same
+// way as environment variables. A missing default file is fine, a missing named one isn't.
+func readFile(path string) (map[string]string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultFile
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	values := map[string]json.RawMessage{}
+	err = json.Unmarshal(raw, &values)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	known := map[string]field{}
+	for _, field := range fields(&Config{}) {
+		known[field.name] = field
+	}
+
+	settings := map[string]string{}
+	for key, value := range values {
+		field, ok := known[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %q in config file %s", key, path)
+		}
+		if field.flagOnly {
+			return nil, fmt.Errorf("%s in config file %s can only be set with --%s", key, path, field.flagName())
+		}
+
+		settings[key], err = rawToString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in config file %s: %w", key, path, err)
+		}
+	}
+
+	return settings, nil
+}
+
+// rawToString turns a JSON string, number, bool or list of strings into the text an environment variable would hold
+func rawToString(value json.RawMessage) (string, error) {
+	var text string
+	if json.Unmarshal(value, &text) == nil {
+		return text, nil
+	}
+
+	var list []string
+	if json.Unmarshal(value, &list) == nil {
+		return strings.Join(list, ","), nil
+	}
+
+	var scalar any
+	err := json.Unmarshal(value, &scalar)
+	if err != nil {
+		return "", err
+	}
+
+	switch scalar.(type) {
+	case float64, bool:
+		return string(value), nil
+	default:
+		return "", errors.New("expected a string, number, bool or list of strings")
+	}
+}
+
+func hasKey(values map[string]string, key string) bool {
+	_, ok := values[key]
+	return ok
+}
+
+// Validate checks the settings make sense together, returning every problem at once
+func (c Config) Validate() error {
+	problems := []error{}
+
+	if c.JWTSecret == "" {
+		problems = append(problems, errors.New("jwt_secret is required (set JWT_SECRET)"))
+	}
+	if c.Host == "" {
+		problems = append(problems, errors.New("host can't be empty"))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, fmt.Errorf("port has to be between 1 and 65535, not %d", c.Port))
+	}
+	if c.DatabasePath == "" {
+		problems = append(problems, errors.New("database_path can't be empty"))
+	}
+	if parsed, err := url.Parse(c.PublicURL); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		problems = append(problems, fmt.Errorf("public_url has to be an absolute URL, not %q", c.PublicURL))
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, errors.New("tls_cert_file and tls_key_file have to be set together"))
+	}
+	if c.RedirectPort != 0 && !c.TLS() {
+		problems = append(problems, errors.New("redirect_port needs tls_cert_file and tls_key_file"))
+	}
+	if c.RedirectPort < 0 || c.RedirectPort > 65535 || (c.RedirectPort != 0 && c.RedirectPort == c.Port) {
+		problems = append(problems, fmt.Errorf("redirect_port has to be 0 or a port other than %d, not %d", c.Port, c.RedirectPort))
+	}
+	if c.HSTSMaxAge < 0 {
+		problems = append(problems, errors.New("hsts_max_age can't be negative"))
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		_, prefixErr := netip.ParsePrefix(proxy)
+		_, addrErr := netip.ParseAddr(proxy)
+		if prefixErr != nil && addrErr != nil {
+			problems = append(problems, fmt.Errorf("trusted_proxies has to hold addresses or CIDR ranges, not %q", proxy))
+		}
+	}
+
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin == "*" && c.CORSAllowCredentials {
+			problems = append(problems, errors.New("cors_allow_credentials can't be used with * in cors_allowed_origins, list the origins instead"))
+		}
+		if origin != "*" && !strings.Contains(origin, "://") {
+			problems = append(problems, fmt.Errorf("cors_allowed_origins has to hold origins like https://example.com, not %q", origin))
+		}
+	}
+	if c.CORSMaxAge < 0 {
+		problems = append(problems, errors.New("cors_max_age can't be negative"))
+	}
+
+	durations := map[string]time.Duration{
+		"access_token_lifetime":  c.AccessTokenLifetime,
+		"refresh_token_lifetime": c.RefreshTokenLifetime,
+		"shutdown_timeout":       c.ShutdownTimeout,
+	}
+	for name, duration := range durations {
+		if duration <= 0 {
+			problems = append(problems, fmt.Errorf("%s has to be positive", name))
+		}
+	}
+
+	switch c.ModerationLinks {
+	case "mask", "flag", "reject":
+	default:
+		problems = append(problems, fmt.Errorf("moderation_links has to be mask, flag or reject, not %q", c.ModerationLinks))
+	}
+	if c.ModerationMaxRepeatedChars < 1 {
+		problems = append(problems, errors.New("moderation_max_repeated_chars has to be at least 1"))
+	}
+
+	return errors.Join(problems...)
+}
+
+// Print writes the effective config as JSON, with secrets redacted
+func (c Config) Print(w io.Writer) error {
+	printed := map[string]any{}
+	for _, field := range fields(&c) {
+		value := field.value.Interface()
+
+		switch {
+		case field.secret && field.value.String() == "":
+			value = ""
+		case field.secret:
+			value = "[redacted]"
+		case field.value.Type() == reflect.TypeOf(time.Duration(0)):
+			value = time.Duration(field.value.Int()).String()
+		}
+
+		printed[field.name] = value
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(printed)
+}
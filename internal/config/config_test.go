@@ -0,0 +1,224 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "chirpy.json")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func envFrom(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfigFile(t, `{
+		"port": 9000,
+		"jwt_audience": "from-file",
+		"access_token_lifetime": "10m",
+		"host": "file.example.com",
+		"trusted_proxies": ["10.0.0.0/8", "192.168.0.1"],
+		"rate_limit": false
+	}`)
+	env := envFrom(map[string]string{
+		"JWT_AUDIENCE":          "from-env",
+		"HOST":                  "env.example.com",
+		"CORS_ALLOWED_ORIGINS":  "https://a.example.com, https://b.example.com",
+		"ACCESS_TOKEN_LIFETIME": "20m",
+	})
+
+	cfg, args, err := Load("chirpy", []string{"--config", file, "--host", "flag.example.com", "--debug", "create-admin", "-email", "a@b.c"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"default", cfg.DatabasePath, "database.json"},
+		{"file over default", cfg.Port, 9000},
+		{"file bool", cfg.RateLimit, false},
+		{"file list", cfg.TrustedProxies, []string{"10.0.0.0/8", "192.168.0.1"}},
+		{"env over file", cfg.JWTAudience, "from-env"},
+		{"env duration", cfg.AccessTokenLifetime, 20 * time.Minute},
+		{"env list", cfg.CORSAllowedOrigins, []string{"https://a.example.com", "https://b.example.com"}},
+		{"flag over env", cfg.Host, "flag.example.com"},
+		{"bare bool flag", cfg.Debug, true},
+		{"public url from host and port", cfg.PublicURL, "http://flag.example.com:9000"},
+		{"arguments after the flags", args, []string{"create-admin", "-email", "a@b.c"}},
+	}
+
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	file := writeConfigFile(t, `{"port": 9100}`)
+
+	cfg, _, err := Load("chirpy", []string{}, envFrom(map[string]string{"CHIRPY_CONFIG": file}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != 9100 {
+		t.Errorf("Port = %d, want 9100 from $CHIRPY_CONFIG", cfg.Port)
+	}
+}
+
+func TestLoadResetDatabaseIsFlagOnly(t *testing.T) {
+	cfg, _, err := Load("chirpy", []string{}, envFrom(map[string]string{"RESET_DATABASE": "true"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ResetDatabase {
+		t.Error("RESET_DATABASE in the environment reset the database")
+	}
+
+	file := writeConfigFile(t, `{"reset_database": true}`)
+	_, _, err = Load("chirpy", []string{"--config", file}, envFrom(nil))
+	if err == nil || !strings.Contains(err.Error(), "--reset-database") {
+		t.Errorf("reset_database in the config file: got %v, want an error pointing at the flag", err)
+	}
+
+	cfg, _, err = Load("chirpy", []string{"--reset-database"}, envFrom(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.ResetDatabase {
+		t.Error("--reset-database didn't reset the database")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"unknown file setting", `{"prot": 80}`, nil, nil, `unknown setting "prot"`},
+		{"bad file value", `{"port": "eighty"}`, nil, nil, "invalid port from config file"},
+		{"bad file JSON", `{"port": 80`, nil, nil, "parsing config file"},
+		{"nested file value", `{"port": {"value": 80}}`, nil, nil, "invalid port in config file"},
+		{"bad env value", `{}`, nil, map[string]string{"ACCESS_TOKEN_LIFETIME": "forever"}, "invalid access_token_lifetime from ACCESS_TOKEN_LIFETIME"},
+		{"bad flag value", `{}`, []string{"--rate-limit=maybe"}, nil, "invalid rate_limit from --rate-limit"},
+	}
+
+	for _, tt := range tests {
+		file := writeConfigFile(t, tt.file)
+		args := append([]string{"--config", file}, tt.args...)
+
+		_, _, err := Load("chirpy", args, envFrom(tt.env))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+
+	_, _, err := Load("chirpy", []string{"--config", filepath.Join(t.TempDir(), "missing.json")}, envFrom(nil))
+	if err == nil {
+		t.Error("a missing --config file was ignored, want an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		cfg := Default()
+		cfg.JWTSecret = "secret"
+		cfg.PublicURL = "http://localhost:8000"
+		return cfg
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"valid", func(*Config) {}, ""},
+		{"no secret", func(c *Config) { c.JWTSecret = "" }, "jwt_secret is required"},
+		{"bad port", func(c *Config) { c.Port = 70000 }, "port has to be between 1 and 65535"},
+		{"relative public url", func(c *Config) { c.PublicURL = "/chirpy" }, "public_url has to be an absolute URL"},
+		{"cert without key", func(c *Config) { c.TLSCertFile = "cert.pem" }, "have to be set together"},
+		{"redirect without TLS", func(c *Config) { c.RedirectPort = 8080 }, "redirect_port needs tls_cert_file"},
+		{"redirect to itself", func(c *Config) {
+			c.TLSCertFile, c.TLSKeyFile, c.RedirectPort = "cert.pem", "key.pem", c.Port
+		}, "redirect_port has to be 0 or a port other than"},
+		{"bad proxy", func(c *Config) { c.TrustedProxies = []string{"proxy.local"} }, "trusted_proxies has to hold addresses"},
+		{"credentials with any origin", func(c *Config) { c.CORSAllowCredentials = true }, "cors_allow_credentials can't be used with *"},
+		{"origin without scheme", func(c *Config) { c.CORSAllowedOrigins = []string{"example.com"} }, "cors_allowed_origins has to hold origins"},
+		{"zero lifetime", func(c *Config) { c.AccessTokenLifetime = 0 }, "access_token_lifetime has to be positive"},
+		{"no repeated characters", func(c *Config) { c.ModerationMaxRepeatedChars = 0 }, "moderation_max_repeated_chars has to be at least 1"},
+		{"unknown link handling", func(c *Config) { c.ModerationLinks = "ignore" }, "moderation_links has to be mask, flag or reject"},
+	}
+
+	for _, tt := range tests {
+		cfg := valid()
+		tt.change(&cfg)
+
+		err := cfg.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: Validate = %v, want nil", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: Validate = %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Port = 0
+	cfg.ShutdownTimeout = -time.Second
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate = nil, want an error")
+	}
+
+	for _, want := range []string{"jwt_secret", "port", "shutdown_timeout"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.JWTSecret = "hunter2"
+
+	out := strings.Builder{}
+	err := cfg.Print(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("Print showed the secret:\n%s", out.String())
+	}
+	for _, want := range []string{`"jwt_secret": "[redacted]"`, `"polka_key": ""`, `"access_token_lifetime": "1h0m0s"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Print wrote:\n%s\nwant it to contain %s", out.String(), want)
+		}
+	}
+}
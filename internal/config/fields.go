@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field is one setting of a Config, found from its struct tags
+type field struct {
+	name   string
+	usage  string
+	secret bool
+	// flagOnly settings are only read from the command line, so a stray environment
+	// variable or file entry can't turn them on
+	flagOnly bool
+	value    reflect.Value
+}
+
+func fields(cfg *Config) []field {
+	value := reflect.ValueOf(cfg).Elem()
+	found := []field{}
+
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		name := structField.Tag.Get("config")
+		if name == "" {
+			continue
+		}
+
+		found = append(found, field{
+			name:     name,
+			usage:    structField.Tag.Get("usage"),
+			secret:   structField.Tag.Get("secret") == "true",
+			flagOnly: structField.Tag.Get("flagonly") == "true",
+			value:    value.Field(i),
+		})
+	}
+
+	return found
+}
+
+func (f field) envName() string {
+	return strings.ToUpper(f.name)
+}
+
+func (f field) flagName() string {
+	return strings.ReplaceAll(f.name, "_", "-")
+}
+
+// set parses raw into the field the same way whether it came from the file, environment or a flag
+func (f field) set(raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if f.value.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(raw)
+	case reflect.Int:
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(number))
+	case reflect.Bool:
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(enabled)
+	case reflect.Slice:
+		list := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+
+	return nil
+}
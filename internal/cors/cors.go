@@ -0,0 +1,147 @@
+// Package cors decides which web pages on other origins can call the API
+//
+// Origins can be listed exactly ("https://chirpy.dev"), with a wildcard for subdomains
+// ("https://*.chirpy.dev"), or as "*" for any origin at all.
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Policy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// allowsAnyOrigin is true when every origin gets the same answer, so "*" can be sent as is
+func (p Policy) allowsAnyOrigin() bool {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOrigin reports whether pages served from origin can call the API
+func (p Policy) AllowsOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		prefix, suffix, wildcard := strings.Cut(strings.ToLower(allowed), "*")
+		if !wildcard {
+			continue
+		}
+
+		lowered := strings.ToLower(origin)
+		if len(lowered) <= len(prefix)+len(suffix) || !strings.HasPrefix(lowered, prefix) || !strings.HasSuffix(lowered, suffix) {
+			continue
+		}
+
+		// the wildcard only stands in for subdomains, never a path or port
+		middle := lowered[len(prefix) : len(lowered)-len(suffix)]
+		if !strings.ContainsAny(middle, "/:") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p Policy) allowsMethod(method string) bool {
+	for _, allowed := range p.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) allowsHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+
+		found := false
+		for _, allowed := range p.AllowedHeaders {
+			if allowed == "*" || strings.EqualFold(allowed, header) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler adds CORS headers for allowed origins. Only real preflight requests (an OPTIONS with
+// Origin and Access-Control-Request-Method) are answered here, everything else reaches next
+func (p Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// caches have to keep answers for different origins apart unless every origin gets "*"
+		if !p.allowsAnyOrigin() || p.AllowCredentials {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			headers := r.Header.Get("Access-Control-Request-Headers")
+			if p.AllowsOrigin(origin) && p.allowsMethod(method) && p.allowsHeaders(headers) {
+				p.setAllowOrigin(w, origin)
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+				if headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				if p.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+				}
+			}
+
+			// without the allow headers the browser refuses the real request itself
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if origin != "" && p.AllowsOrigin(origin) {
+			p.setAllowOrigin(w, origin)
+			if len(p.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setAllowOrigin echoes the origin back, apart from when any origin is fine without credentials.
+// Browsers don't accept "*" on requests that carry credentials
+func (p Policy) setAllowOrigin(w http.ResponseWriter, origin string) {
+	if p.allowsAnyOrigin() && !p.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if p.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,197 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAllowsOrigin(t *testing.T) {
+	policy := Policy{AllowedOrigins: []string{"https://chirpy.dev", "https://*.chirpy.dev", "http://localhost:3000"}}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://chirpy.dev", true},
+		{"HTTPS://Chirpy.dev", true},
+		{"https://app.chirpy.dev", true},
+		{"https://a.b.chirpy.dev", true},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"http://app.chirpy.dev", false},
+		{"https://.chirpy.dev", false},
+		{"https://evilchirpy.dev", false},
+		{"https://chirpy.dev.evil.com", false},
+		{"https://evil.com/.chirpy.dev", false},
+		{"https://evil.com:443.chirpy.dev", false},
+		{"null", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.AllowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("AllowsOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	anyOrigin := Policy{AllowedOrigins: []string{"*"}}
+	if !anyOrigin.AllowsOrigin("https://anywhere.example") {
+		t.Error("* didn't allow an arbitrary origin")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	listed := Policy{
+		AllowedOrigins: []string{"https://*.chirpy.dev"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		ExposedHeaders: []string{"X-Request-ID"},
+		MaxAge:         10 * time.Minute,
+	}
+	anyOrigin := Policy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET"},
+		AllowedHeaders: []string{"*"},
+	}
+	credentials := anyOrigin
+	credentials.AllowCredentials = true
+
+	preflightVary := []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		method      string
+		headers     map[string]string
+		wantStatus  int
+		wantHeaders map[string]string
+		wantVary    []string
+	}{
+		{
+			name:       "preflight",
+			policy:     listed,
+			method:     http.MethodOptions,
+			headers:    map[string]string{"Origin": "https://app.chirpy.dev", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "authorization, content-type"},
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.chirpy.dev",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "authorization, content-type",
+				"Access-Control-Max-Age":       "600",
+			},
+			wantVary: preflightVary,
+		},
+		{
+			name:        "preflight from another origin",
+			policy:      listed,
+			method:      http.MethodOptions,
+			headers:     map[string]string{"Origin": "https://evil.com", "Access-Control-Request-Method": "POST"},
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+			wantVary:    preflightVary,
+		},
+		{
+			name:        "preflight for a method that isn't allowed",
+			policy:      listed,
+			method:      http.MethodOptions,
+			headers:     map[string]string{"Origin": "https://app.chirpy.dev", "Access-Control-Request-Method": "DELETE"},
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+			wantVary:    preflightVary,
+		},
+		{
+			name:        "preflight with a header that isn't allowed",
+			policy:      listed,
+			method:      http.MethodOptions,
+			headers:     map[string]string{"Origin": "https://app.chirpy.dev", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Secret"},
+			wantStatus:  http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+			wantVary:    preflightVary,
+		},
+		{
+			name:        "OPTIONS without a requested method isn't a preflight",
+			policy:      listed,
+			method:      http.MethodOptions,
+			headers:     map[string]string{"Origin": "https://app.chirpy.dev"},
+			wantStatus:  http.StatusTeapot,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "https://app.chirpy.dev", "Access-Control-Allow-Methods": ""},
+			wantVary:    []string{"Origin"},
+		},
+		{
+			name:        "OPTIONS without an origin isn't a preflight",
+			policy:      listed,
+			method:      http.MethodOptions,
+			headers:     map[string]string{"Access-Control-Request-Method": "GET"},
+			wantStatus:  http.StatusTeapot,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+			wantVary:    []string{"Origin"},
+		},
+		{
+			name:        "simple request",
+			policy:      listed,
+			method:      http.MethodGet,
+			headers:     map[string]string{"Origin": "https://app.chirpy.dev"},
+			wantStatus:  http.StatusTeapot,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "https://app.chirpy.dev", "Access-Control-Expose-Headers": "X-Request-ID"},
+			wantVary:    []string{"Origin"},
+		},
+		{
+			name:        "simple request from another origin",
+			policy:      listed,
+			method:      http.MethodGet,
+			headers:     map[string]string{"Origin": "https://evil.com"},
+			wantStatus:  http.StatusTeapot,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Expose-Headers": ""},
+			wantVary:    []string{"Origin"},
+		},
+		{
+			name:        "any origin gets * and no Vary",
+			policy:      anyOrigin,
+			method:      http.MethodGet,
+			headers:     map[string]string{"Origin": "https://anywhere.example"},
+			wantStatus:  http.StatusTeapot,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Credentials": ""},
+			wantVary:    nil,
+		},
+		{
+			name:        "any origin with credentials echoes the origin",
+			policy:      credentials,
+			method:      http.MethodGet,
+			headers:     map[string]string{"Origin": "https://anywhere.example"},
+			wantStatus:  http.StatusTeapot,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "https://anywhere.example", "Access-Control-Allow-Credentials": "true"},
+			wantVary:    []string{"Origin"},
+		},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/chirps", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+
+			tt.policy.Handler(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if got := rec.Header().Values("Vary"); !reflect.DeepEqual(got, tt.wantVary) {
+				t.Errorf("Vary = %q, want %q", got, tt.wantVary)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const SCOPE_CHIRPS_READ = "chirps:read"
+const SCOPE_CHIRPS_WRITE = "chirps:write"
+const SCOPE_PROFILE_WRITE = "profile:write"
+
+// AllScopes lists every scope a credential can be limited to
+var AllScopes = []string{SCOPE_CHIRPS_READ, SCOPE_CHIRPS_WRITE, SCOPE_PROFILE_WRITE}
+
+const API_KEY_PREFIX = "chirpy_"
+
+// lastUsedPrecision stops every request made with a key from rewriting the database
+const lastUsedPrecision = time.Minute
+
+type APIKey struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ValidScope reports whether scope is one the server knows about
+func ValidScope(scope string) bool {
+	for _, known := range AllScopes {
+		if scope == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasScope reports whether scope is in the list
+func HasScope(scopes []string, scope string) bool {
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateAPIKey creates a named key for the user, returning the only copy of the plaintext key
+func (db *DB) CreateAPIKey(userId int, name string, scopes []string, expiresAt *time.Time) (string, APIKey, error) {
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return "", APIKey{}, invalid("Unknown scope: " + scope)
+		}
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	plaintext := API_KEY_PREFIX + token
+	newKey := APIKey{}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		if _, ok := currentDB.Users[userId]; !ok {
+			return notFound("Could not find user")
+		}
+
+		nextId := nextCountedId(&currentDB.LastAPIKeyId, currentDB.APIKeys)
+
+		newKey = APIKey{
+			Id:        nextId,
+			UserId:    userId,
+			Name:      name,
+			Prefix:    plaintext[:len(API_KEY_PREFIX)+6],
+			Hash:      hashToken(plaintext),
+			Scopes:    scopes,
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt,
+		}
+
+		currentDB.APIKeys[nextId] = newKey
+
+		return nil
+	})
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	return plaintext, newKey, nil
+}
+
+// GetAPIKeys returns all of a user's keys
+func (db *DB) GetAPIKeys(userId int) ([]APIKey, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []APIKey{}, err
+	}
+
+	keys := []APIKey{}
+	for _, key := range currentDB.APIKeys {
+		if key.UserId == userId {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Id < keys[j].Id
+	})
+
+	return keys, nil
+}
+
+// DeleteAPIKey revokes one of the user's keys
+func (db *DB) DeleteAPIKey(userId int, keyId int) error {
+	return db.update(func(currentDB *DBStructure) error {
+		key, ok := currentDB.APIKeys[keyId]
+		if !ok || key.UserId != userId {
+			return notFound("Could not find API key")
+		}
+
+		delete(currentDB.APIKeys, keyId)
+
+		return nil
+	})
+}
+
+// VerifyAPIKey looks up the key a plaintext API key belongs to, and notes that it has been used
+func (db *DB) VerifyAPIKey(plaintext string) (APIKey, error) {
+	if !strings.HasPrefix(plaintext, API_KEY_PREFIX) {
+		return APIKey{}, unauthorized("Malformed API key")
+	}
+
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	hash := hashToken(plaintext)
+
+	var matching APIKey
+	found := false
+	for _, key := range currentDB.APIKeys {
+		if key.Hash == hash {
+			matching = key
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return APIKey{}, unauthorized("Invalid API key")
+	}
+
+	now := time.Now().UTC()
+
+	if matching.ExpiresAt != nil && matching.ExpiresAt.Before(now) {
+		return APIKey{}, unauthorized("API key has expired")
+	}
+
+	err = checkRestricted(currentDB.Users[matching.UserId])
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	if matching.LastUsedAt == nil || now.Sub(*matching.LastUsedAt) >= lastUsedPrecision {
+		matching.LastUsedAt = &now
+
+		err = db.update(func(currentDB *DBStructure) error {
+			key, ok := currentDB.APIKeys[matching.Id]
+			if !ok {
+				return unauthorized("Invalid API key")
+			}
+
+			key.LastUsedAt = &now
+			currentDB.APIKeys[key.Id] = key
+
+			return nil
+		})
+		if err != nil {
+			return APIKey{}, err
+		}
+	}
+
+	return matching, nil
+}
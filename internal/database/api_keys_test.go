@@ -0,0 +1,32 @@
+package database
+
+import "testing"
+
+func TestAPIKeyIdsAreNeverReused(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "keys@example.com")
+
+	_, first, err := db.CreateAPIKey(user.Id, "first", []string{SCOPE_CHIRPS_READ}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.DeleteAPIKey(user.Id, first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, second, err := db.CreateAPIKey(user.Id, "second", []string{SCOPE_CHIRPS_READ}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Id == first.Id {
+		t.Errorf("a new key took the id %d of a deleted one", second.Id)
+	}
+
+	// deleting the old key again mustn't touch the new one
+	err = db.DeleteAPIKey(user.Id, first.Id)
+	if err == nil {
+		t.Error("deleting a deleted key succeeded")
+	}
+}
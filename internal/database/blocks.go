@@ -0,0 +1,161 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Block stops BlockedId from seeing, replying to, mentioning or following BlockerId
+type Block struct {
+	BlockerId int       `json:"blocker_id"`
+	BlockedId int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mute keeps MutedId's chirps out of MuterId's feeds, without MutedId knowing
+type Mute struct {
+	MuterId   int       `json:"muter_id"`
+	MutedId   int       `json:"muted_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BlockUser blocks another user, and removes any follows between the two of them
+func (db *DB) BlockUser(blockerId int, blockedId int) error {
+	if blockerId == blockedId {
+		return invalid("You can't block yourself")
+	}
+
+	return db.update(func(currentDB *DBStructure) error {
+		if _, ok := currentDB.Users[blockedId]; !ok {
+			return notFound("Could not find user")
+		}
+
+		key := followKey(blockerId, blockedId)
+		if _, already := currentDB.Blocks[key]; already {
+			return nil
+		}
+
+		currentDB.Blocks[key] = Block{
+			BlockerId: blockerId,
+			BlockedId: blockedId,
+			CreatedAt: time.Now().UTC(),
+		}
+
+		delete(currentDB.Follows, followKey(blockerId, blockedId))
+		delete(currentDB.Follows, followKey(blockedId, blockerId))
+
+		return nil
+	})
+}
+
+func (db *DB) UnblockUser(blockerId int, blockedId int) error {
+	return db.update(func(currentDB *DBStructure) error {
+		delete(currentDB.Blocks, followKey(blockerId, blockedId))
+
+		return nil
+	})
+}
+
+// IsBlocked reports whether blockerId has blocked blockedId
+func (db *DB) IsBlocked(blockerId int, blockedId int) (bool, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	_, blocked := currentDB.Blocks[followKey(blockerId, blockedId)]
+
+	return blocked, nil
+}
+
+// GetBlocks returns everyone the user has blocked
+func (db *DB) GetBlocks(blockerId int) ([]Block, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []Block{}, err
+	}
+
+	blocks := []Block{}
+	for _, block := range currentDB.Blocks {
+		if block.BlockerId == blockerId {
+			blocks = append(blocks, block)
+		}
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].CreatedAt.Before(blocks[j].CreatedAt)
+	})
+
+	return blocks, nil
+}
+
+// GetBlockers returns the ids of everyone who has blocked the user
+func (db *DB) GetBlockers(blockedId int) (map[int]bool, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return map[int]bool{}, err
+	}
+
+	blockers := map[int]bool{}
+	for _, block := range currentDB.Blocks {
+		if block.BlockedId == blockedId {
+			blockers[block.BlockerId] = true
+		}
+	}
+
+	return blockers, nil
+}
+
+func (db *DB) MuteUser(muterId int, mutedId int) error {
+	if muterId == mutedId {
+		return invalid("You can't mute yourself")
+	}
+
+	return db.update(func(currentDB *DBStructure) error {
+		if _, ok := currentDB.Users[mutedId]; !ok {
+			return notFound("Could not find user")
+		}
+
+		key := followKey(muterId, mutedId)
+		if _, already := currentDB.Mutes[key]; already {
+			return nil
+		}
+
+		currentDB.Mutes[key] = Mute{
+			MuterId:   muterId,
+			MutedId:   mutedId,
+			CreatedAt: time.Now().UTC(),
+		}
+
+		return nil
+	})
+}
+
+func (db *DB) UnmuteUser(muterId int, mutedId int) error {
+	return db.update(func(currentDB *DBStructure) error {
+		delete(currentDB.Mutes, followKey(muterId, mutedId))
+
+		return nil
+	})
+}
+
+// GetMutes returns everyone the user has muted
+func (db *DB) GetMutes(muterId int) ([]Mute, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []Mute{}, err
+	}
+
+	mutes := []Mute{}
+	for _, mute := range currentDB.Mutes {
+		if mute.MuterId == muterId {
+			mutes = append(mutes, mute)
+		}
+	}
+
+	sort.Slice(mutes, func(i, j int) bool {
+		return mutes[i].CreatedAt.Before(mutes[j].CreatedAt)
+	})
+
+	return mutes, nil
+}
@@ -1,11 +1,17 @@
 package database
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,35 +20,177 @@ import (
 )
 
 type DB struct {
-	path      string
-	lastChirp int
-	lastUser  int
-	mux       *sync.RWMutex
+	path    string
+	mux     *sync.RWMutex
+	tokens  TokenSettings
+	observe func(operation string, took time.Duration)
+	closed  bool
 }
 
+// ErrClosed means the database was used after Close
+var ErrClosed = errors.New("Database is closed")
+
 type DBStructure struct {
 	Chirps        map[int]Chirp             `json:"chirps"`
 	Users         map[int]AuthenticatedUser `json:"users"`
 	RevokedTokens map[string]RevokedToken   `json:"revoked_tokens"`
+
+	PasswordResets     map[string]PasswordReset     `json:"password_resets"`
+	EmailVerifications map[string]EmailVerification `json:"email_verifications"`
+	LockoutEvents      map[int]LockoutEvent         `json:"lockout_events"`
+	APIKeys            map[int]APIKey               `json:"api_keys"`
+	OAuthClients       map[string]OAuthClient       `json:"oauth_clients"`
+	OAuthCodes         map[string]OAuthCode         `json:"oauth_codes"`
+	WebhookEvents      map[string]WebhookEvent      `json:"webhook_events"`
+	WebhookEndpoints   map[int]WebhookEndpoint      `json:"webhook_endpoints"`
+	WebhookDeliveries  map[int]WebhookDelivery      `json:"webhook_deliveries"`
+	Follows            map[string]Follow            `json:"follows"`
+	Blocks             map[string]Block             `json:"blocks"`
+	Mutes              map[string]Mute              `json:"mutes"`
+	Reports            map[int]Report               `json:"reports"`
+	ModerationLog      map[int]ModerationAction     `json:"moderation_log"`
+
+	// LastChirpId is the highest chirp id ever handed out, deleted chirps included
+	LastChirpId int `json:"last_chirp_id,omitempty"`
+
+	// the same for webhook endpoints and deliveries, so a delivery in flight for a deleted
+	// endpoint can't be recorded against a new one
+	LastWebhookEndpointId int `json:"last_webhook_endpoint_id,omitempty"`
+	LastWebhookDeliveryId int `json:"last_webhook_delivery_id,omitempty"`
+
+	// and for reports and the moderation log, whose entries point at each other by id
+	LastReportId           int `json:"last_report_id,omitempty"`
+	LastModerationActionId int `json:"last_moderation_action_id,omitempty"`
+
+	// and for API keys, so deleting by a stale id can't revoke a newer key
+	LastAPIKeyId int `json:"last_api_key_id,omitempty"`
+}
+
+var ErrEmailTaken = conflict("A user already exists with that Email")
+
+// initMaps fills in any collections missing from older database files
+func (dbStructure *DBStructure) initMaps() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = map[int]Chirp{}
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = map[int]AuthenticatedUser{}
+	}
+	if dbStructure.RevokedTokens == nil {
+		dbStructure.RevokedTokens = map[string]RevokedToken{}
+	}
+	if dbStructure.PasswordResets == nil {
+		dbStructure.PasswordResets = map[string]PasswordReset{}
+	}
+	if dbStructure.EmailVerifications == nil {
+		dbStructure.EmailVerifications = map[string]EmailVerification{}
+	}
+	if dbStructure.LockoutEvents == nil {
+		dbStructure.LockoutEvents = map[int]LockoutEvent{}
+	}
+	if dbStructure.APIKeys == nil {
+		dbStructure.APIKeys = map[int]APIKey{}
+	}
+	if dbStructure.OAuthClients == nil {
+		dbStructure.OAuthClients = map[string]OAuthClient{}
+	}
+	if dbStructure.OAuthCodes == nil {
+		dbStructure.OAuthCodes = map[string]OAuthCode{}
+	}
+	if dbStructure.WebhookEvents == nil {
+		dbStructure.WebhookEvents = map[string]WebhookEvent{}
+	}
+	if dbStructure.WebhookEndpoints == nil {
+		dbStructure.WebhookEndpoints = map[int]WebhookEndpoint{}
+	}
+	if dbStructure.WebhookDeliveries == nil {
+		dbStructure.WebhookDeliveries = map[int]WebhookDelivery{}
+	}
+	if dbStructure.Follows == nil {
+		dbStructure.Follows = map[string]Follow{}
+	}
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = map[string]Block{}
+	}
+	if dbStructure.Mutes == nil {
+		dbStructure.Mutes = map[string]Mute{}
+	}
+	if dbStructure.Reports == nil {
+		dbStructure.Reports = map[int]Report{}
+	}
+	if dbStructure.ModerationLog == nil {
+		dbStructure.ModerationLog = map[int]ModerationAction{}
+	}
+}
+
+// migrateLegacyFields turns data saved by older versions into its current shape
+func (dbStructure *DBStructure) migrateLegacyFields() {
+	for id, user := range dbStructure.Users {
+		if !user.LegacyChirpyRed {
+			continue
+		}
+
+		if user.Subscription == nil {
+			now := time.Now().UTC()
+			user.Subscription = &Subscription{
+				Status:    SUBSCRIPTION_ACTIVE,
+				StartedAt: now,
+				ExpiresAt: now.Add(SUBSCRIPTION_PERIOD),
+				UpdatedAt: now,
+			}
+		}
+
+		user.LegacyChirpyRed = false
+		dbStructure.Users[id] = user
+	}
 }
 
 type Chirp struct {
-	Id       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorId int    `json:"author_id"`
+	Id        int        `json:"id"`
+	Body      string     `json:"body"`
+	AuthorId  int        `json:"author_id"`
+	ReplyTo   int        `json:"reply_to,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	// Flags are the reasons moderation wants a person to look at this chirp
+	Flags []string `json:"flags,omitempty"`
+	// Hidden chirps were taken down by a moderator, and only their author can still see them
+	Hidden bool `json:"hidden,omitempty"`
 }
 
 type User struct {
-	Email       string `json:"email"`
-	Id          int    `json:"id"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Email         string `json:"email"`
+	Id            int    `json:"id"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 type AuthenticatedUser struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	Password    []byte `json:"password"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id       int    `json:"id"`
+	Email    string `json:"email"`
+	Password []byte `json:"password"`
+	Role     string `json:"role"`
+
+	Subscription *Subscription `json:"subscription,omitempty"`
+	// LegacyChirpyRed is the flag from before subscriptions, see migrateLegacyFields
+	LegacyChirpyRed bool `json:"is_chirpy_red,omitempty"`
+
+	EmailVerified bool `json:"email_verified"`
+
+	FailedLogins int       `json:"failed_logins"`
+	LockedUntil  time.Time `json:"locked_until"`
+
+	// TokenVersion goes in every token issued to the user, and bumping it stops
+	// all of their outstanding tokens from being accepted
+	TokenVersion int `json:"token_version,omitempty"`
+
+	Restriction *Restriction `json:"restriction,omitempty"`
+
+	TOTPEnabled       bool     `json:"totp_enabled"`
+	TOTPSecret        string   `json:"totp_secret,omitempty"`
+	TOTPPendingSecret string   `json:"totp_pending_secret,omitempty"`
+	TOTPLastCounter   int64    `json:"totp_last_counter,omitempty"`
+	RecoveryCodes     [][]byte `json:"recovery_codes,omitempty"`
 }
 
 type RevokedToken struct {
@@ -57,13 +205,28 @@ type EditingUser struct {
 
 const ACCESS_ISSUER = "chirpy-access"
 const REFRESH_ISSUER = "chirpy-refresh"
+const MFA_ISSUER = "chirpy-mfa"
+
+// TokenSettings controls the lifetimes and audience of the JWTs the database issues
+type TokenSettings struct {
+	AccessLifetime  time.Duration
+	RefreshLifetime time.Duration
+	Audience        string
+}
+
+var DefaultTokenSettings = TokenSettings{
+	AccessLifetime:  time.Hour,
+	RefreshLifetime: 1440 * time.Hour,
+	Audience:        "chirpy",
+}
 
 // NewDB creates a new database connection
 // and creates the database file if it doesn't exist
 func NewDB(path string) (*DB, error) {
 	database := DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:   path,
+		mux:    &sync.RWMutex{},
+		tokens: DefaultTokenSettings,
 	}
 
 	_, err := os.ReadFile(path)
@@ -74,58 +237,185 @@ func NewDB(path string) (*DB, error) {
 	return &database, err
 }
 
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string, id int) (Chirp, error) {
-	currentStructure, err := db.loadDB()
+// ObserveOperations calls observe with how long every read ("load") and write ("write") of the database file took
+func (db *DB) ObserveOperations(observe func(operation string, took time.Duration)) {
+	db.observe = observe
+}
+
+func (db *DB) observeSince(operation string, start time.Time) {
+	if db.observe != nil {
+		db.observe(operation, time.Since(start))
+	}
+}
+
+// SetTokenSettings replaces the token lifetimes and audience,
+// falling back to the defaults for any zero values
+func (db *DB) SetTokenSettings(settings TokenSettings) {
+	if settings.AccessLifetime <= 0 {
+		settings.AccessLifetime = DefaultTokenSettings.AccessLifetime
+	}
+	if settings.RefreshLifetime <= 0 {
+		settings.RefreshLifetime = DefaultTokenSettings.RefreshLifetime
+	}
+	if settings.Audience == "" {
+		settings.Audience = DefaultTokenSettings.Audience
+	}
+
+	db.tokens = settings
+}
+
+// accessLifetime honors a client-requested lifetime as long as it is shorter than the server maximum
+func (db *DB) accessLifetime(requested time.Duration) time.Duration {
+	if requested > 0 && requested < db.tokens.AccessLifetime {
+		return requested
+	}
+
+	return db.tokens.AccessLifetime
+}
+
+// CreateChirp saves a new chirp, replying to the chirp with id replyTo unless it's 0
+func (db *DB) CreateChirp(body string, id int, replyTo int, flags []string) (Chirp, error) {
+	newChirp := Chirp{}
+
+	err := db.update(func(currentStructure *DBStructure) error {
+		if replyTo != 0 {
+			parent, ok := currentStructure.Chirps[replyTo]
+			if !ok {
+				return notFound("Could not find the chirp being replied to")
+			}
+
+			if _, blocked := currentStructure.Blocks[followKey(parent.AuthorId, id)]; blocked {
+				return ErrBlocked
+			}
+		}
+
+		nextId := currentStructure.nextChirpId()
+
+		newChirp = Chirp{
+			Id:        nextId,
+			Body:      body,
+			AuthorId:  id,
+			ReplyTo:   replyTo,
+			CreatedAt: time.Now().UTC(),
+			Flags:     flags,
+		}
+
+		currentStructure.Chirps[nextId] = newChirp
+
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	nextId := db.lastChirp + 1
+	return newChirp, nil
+}
+
+// nextChirpId never goes backwards, so a new chirp can't take the id of a deleted one and inherit
+// the replies and reports that pointed at it. Files from before LastChirpId was saved start from the highest id in use
+func (dbStructure *DBStructure) nextChirpId() int {
+	return nextCountedId(&dbStructure.LastChirpId, dbStructure.Chirps)
+}
 
-	newChirp := Chirp{
-		Id:       nextId,
-		Body:     body,
-		AuthorId: id,
+// GetChirp looks up a single chirp
+func (db *DB) GetChirp(id int) (Chirp, bool, error) {
+	currentStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, false, err
 	}
 
-	currentStructure.Chirps[nextId] = newChirp
-	err = db.writeDB(currentStructure)
+	chirp, ok := currentStructure.Chirps[id]
+
+	return chirp, ok, nil
+}
+
+// EditChirp replaces the body of a chirp, as long as it belongs to the user editing it
+func (db *DB) EditChirp(userId int, id int, body string, flags []string) (Chirp, error) {
+	chirp := Chirp{}
+
+	err := db.update(func(currentStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = currentStructure.Chirps[id]
+		if !ok {
+			return notFound("Could not find chirp")
+		}
+
+		if chirp.AuthorId != userId {
+			return forbidden("Wrong User!")
+		}
+
+		now := time.Now().UTC()
+		chirp.Body = body
+		chirp.EditedAt = &now
+		chirp.Flags = flags
+		currentStructure.Chirps[id] = chirp
+
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	db.lastChirp = nextId
-	return newChirp, nil
+	return chirp, nil
 }
 
-// CreateUser creates a new chirp User and saves it to disk
-func (db *DB) CreateUser(email string, password string) (User, error) {
+// CountChirpsSince returns how many chirps a user has posted since a given time, and when the earliest of them was
+func (db *DB) CountChirpsSince(authorId int, since time.Time) (int, time.Time, error) {
 	currentStructure, err := db.loadDB()
 	if err != nil {
-		return User{}, err
+		return 0, time.Time{}, err
 	}
 
-	nextId := db.lastUser + 1
+	count := 0
+	earliest := time.Time{}
+
+	for _, chirp := range currentStructure.Chirps {
+		if chirp.AuthorId != authorId || chirp.CreatedAt.Before(since) {
+			continue
+		}
+
+		count++
+		if earliest.IsZero() || chirp.CreatedAt.Before(earliest) {
+			earliest = chirp.CreatedAt
+		}
+	}
+
+	return count, earliest, nil
+}
+
+// CreateUser creates a new chirp User and saves it to disk, as long as nobody else has the email
+func (db *DB) CreateUser(email string, password string) (User, error) {
 	hashword, err := bcrypt.GenerateFromPassword([]byte(password), 0)
 	if err != nil {
 		return User{}, err
 	}
 
-	newUser := AuthenticatedUser{
-		Id:       nextId,
-		Email:    email,
-		Password: hashword,
-	}
+	newUser := AuthenticatedUser{}
+
+	err = db.update(func(currentStructure *DBStructure) error {
+		for _, user := range currentStructure.Users {
+			if strings.EqualFold(user.Email, email) {
+				return ErrEmailTaken
+			}
+		}
+
+		nextId := nextId(currentStructure.Users)
+
+		newUser = AuthenticatedUser{
+			Id:       nextId,
+			Email:    email,
+			Password: hashword,
+			Role:     ROLE_USER,
+		}
+
+		currentStructure.Users[nextId] = newUser
 
-	currentStructure.Users[nextId] = newUser
-	err = db.writeDB(currentStructure)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	db.lastUser = nextId
-
 	userResponse := User{
 		Id:          newUser.Id,
 		Email:       newUser.Email,
@@ -135,36 +425,56 @@ func (db *DB) CreateUser(email string, password string) (User, error) {
 	return userResponse, nil
 }
 
-func (db *DB) EditUser(id int, newUserData EditingUser) (AuthenticatedUser, error) {
-	currentDB, err := db.loadDB()
+// EditUser changes a user's email and/or password, marking a changed email as unverified
+// and reporting whether it changed
+func (db *DB) EditUser(id int, newUserData EditingUser) (AuthenticatedUser, bool, error) {
+	var hashword []byte
+	if newUserData.Password != "" {
+		var err error
+		hashword, err = bcrypt.GenerateFromPassword([]byte(newUserData.Password), 0)
 
-	if err != nil {
-		return AuthenticatedUser{}, err
+		if err != nil {
+			return AuthenticatedUser{}, false, err
+		}
 	}
 
-	databaseUser := currentDB.Users[id]
-	if newUserData.Password != "" {
-		hashword, err := bcrypt.GenerateFromPassword([]byte(newUserData.Password), 0)
+	databaseUser := AuthenticatedUser{}
+	emailChanged := false
 
-		if err != nil {
-			return AuthenticatedUser{}, err
+	err := db.update(func(currentDB *DBStructure) error {
+		var ok bool
+		databaseUser, ok = currentDB.Users[id]
+		if !ok {
+			return notFound("Could not find user")
 		}
 
-		databaseUser.Password = hashword
-	}
-	if newUserData.Email != "" {
-		databaseUser.Email = newUserData.Email
-	}
+		if hashword != nil {
+			databaseUser.Password = hashword
+			// like a reset, a new password logs out every session that knew the old one
+			databaseUser.TokenVersion++
+		}
+		if newUserData.Email != "" && !strings.EqualFold(newUserData.Email, databaseUser.Email) {
+			for _, user := range currentDB.Users {
+				if user.Id != id && strings.EqualFold(user.Email, newUserData.Email) {
+					return ErrEmailTaken
+				}
+			}
+
+			databaseUser.Email = newUserData.Email
+			databaseUser.EmailVerified = false
+			emailChanged = true
+		}
 
-	currentDB.Users[id] = databaseUser
+		currentDB.Users[id] = databaseUser
 
-	err = db.writeDB(currentDB)
+		return nil
+	})
 
 	if err != nil {
-		return AuthenticatedUser{}, err
+		return AuthenticatedUser{}, false, err
 	}
 
-	return databaseUser, nil
+	return databaseUser, emailChanged, nil
 }
 
 func (db *DB) GetUserByEmail(email string) (AuthenticatedUser, bool, error) {
@@ -176,7 +486,7 @@ func (db *DB) GetUserByEmail(email string) (AuthenticatedUser, bool, error) {
 	matchingUser := AuthenticatedUser{}
 
 	for _, user := range currentDB.Users {
-		if user.Email == email {
+		if strings.EqualFold(user.Email, email) {
 			matchingUser = user
 			break
 		}
@@ -189,21 +499,81 @@ func (db *DB) GetUserByEmail(email string) (AuthenticatedUser, bool, error) {
 	return matchingUser, true, nil
 }
 
+// GetUserById looks up a single user
+func (db *DB) GetUserById(id int) (AuthenticatedUser, bool, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return AuthenticatedUser{}, false, err
+	}
+
+	user, ok := currentDB.Users[id]
+
+	return user, ok, nil
+}
+
 type AuthUserResponse struct {
 	Id           int
 	Token        string
 	RefreshToken string
 	IsChirpyRed  bool
+	Role         string
+
+	// MFARequired is set instead of the tokens when the user still has to send a TOTP code
+	MFARequired bool
+	MFAToken    string
+}
+
+// TokenGrant describes what a token can be used for
+// Scopes and ClientId are only set for third-party apps, when the user logs in themselves they can do anything
+// Role is only ever set for the user logging in themselves, apps never get more than a normal user
+// Version is the user's TokenVersion when the token was issued
+type TokenGrant struct {
+	Scopes   []string
+	ClientId string
+	Role     string
+	Version  int
+}
+
+// chirpyClaims are the registered claims plus the user's role or what was granted to a third-party client
+type chirpyClaims struct {
+	Scope    string `json:"scope,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Version  int    `json:"ver,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (claims *chirpyClaims) grant() TokenGrant {
+	grant := TokenGrant{ClientId: claims.ClientId, Role: claims.Role, Version: claims.Version}
+	if claims.Scope != "" {
+		grant.Scopes = strings.Fields(claims.Scope)
+	}
+
+	return grant
 }
 
-func createJWT(expiration string, secret string, id string, keyType string) (string, error) {
-	expirationDuration, _ := time.ParseDuration(expiration)
+func createJWT(expiration time.Duration, secret string, id string, keyType string, audience string, grant TokenGrant) (string, error) {
+	jti, err := newTokenId()
+	if err != nil {
+		return "", err
+	}
 
-	claims := &jwt.RegisteredClaims{
-		Issuer:    keyType,
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expirationDuration)),
-		Subject:   id,
+	now := time.Now()
+
+	claims := &chirpyClaims{
+		Scope:    strings.Join(grant.Scopes, " "),
+		ClientId: grant.ClientId,
+		Role:     grant.Role,
+		Version:  grant.Version,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    keyType,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+			Subject:   id,
+		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -217,138 +587,280 @@ func createJWT(expiration string, secret string, id string, keyType string) (str
 	return signedString, nil
 }
 
+// newTokenId generates a random value for the jti claim
+func newTokenId() (string, error) {
+	raw := make([]byte, 16)
+
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(raw), nil
+}
+
+// newOpaqueToken generates a random url-safe token to hand to a user, which should only be stored hashed
+func newOpaqueToken() (string, error) {
+	raw := make([]byte, 32)
+
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashToken is how opaque tokens are stored and looked up
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// parseJWT validates the signature and the standard claims of a token from the given issuer
+func (db *DB) parseJWT(jwtToken string, secret string, issuer string) (*chirpyClaims, error) {
+	token, err := jwt.ParseWithClaims(
+		jwtToken,
+		&chirpyClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(db.tokens.Audience),
+		jwt.WithExpirationRequired(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*chirpyClaims)
+	if !ok {
+		return nil, unauthorized("Couldn't parse claims")
+	}
+
+	if claims.ID == "" {
+		return nil, unauthorized("Token is missing an id")
+	}
+
+	return claims, nil
+}
+
+// checkVersion rejects tokens issued before the user's tokens were last revoked
+func checkVersion(claims *chirpyClaims, user AuthenticatedUser) error {
+	if claims.Version != user.TokenVersion {
+		return unauthorized("Token is revoked")
+	}
+
+	return nil
+}
+
 // AuthenticateUser checks to see if the email and password match the one on disk
-func (db *DB) AuthenticateUser(email string, password string, secret string) (bool, AuthUserResponse, error) {
+// requestedLifetime shortens the access token lifetime, but can never extend it past the server maximum
+// Failed attempts count towards locking the account, in which case a *LockedError is returned
+func (db *DB) AuthenticateUser(email string, password string, secret string, requestedLifetime time.Duration, ip string) (bool, AuthUserResponse, error) {
 	userResponse := AuthUserResponse{
 		Id:    0,
 		Token: "",
 	}
 	matchingUser, exists, err := db.GetUserByEmail(email)
-	if err != nil || !exists {
-		return false, userResponse, errors.New("User does not exist")
+	if err != nil {
+		return false, userResponse, err
+	}
+
+	if !exists {
+		return false, userResponse, nil
+	}
+
+	err = checkLocked(matchingUser)
+	if err != nil {
+		return false, userResponse, err
 	}
 
 	validationError := bcrypt.CompareHashAndPassword(matchingUser.Password, []byte(password))
 	if validationError != nil {
-		return false, userResponse, nil
+		return false, userResponse, db.recordFailedLogin(matchingUser.Id, ip)
 	}
 
-	stringifiedId := fmt.Sprint(matchingUser.Id)
+	err = checkRestricted(matchingUser)
+	if err != nil {
+		return false, userResponse, err
+	}
+
+	if matchingUser.TOTPEnabled {
+		mfaToken, err := createJWT(MFA_CHALLENGE_LIFETIME, secret, fmt.Sprint(matchingUser.Id), MFA_ISSUER, db.tokens.Audience, TokenGrant{Version: matchingUser.TokenVersion})
+		if err != nil {
+			return false, userResponse, err
+		}
+
+		return true, AuthUserResponse{Id: matchingUser.Id, MFARequired: true, MFAToken: mfaToken}, nil
+	}
 
-	accessToken, err := createJWT("1h", secret, stringifiedId, ACCESS_ISSUER)
+	err = db.recordSuccessfulLogin(matchingUser.Id)
 	if err != nil {
 		return false, userResponse, err
 	}
 
-	refreshToken, err := createJWT("1440h", secret, stringifiedId, REFRESH_ISSUER)
+	userResponse, err = db.issueTokens(matchingUser, secret, requestedLifetime)
 	if err != nil {
 		return false, userResponse, err
 	}
 
-	return true, AuthUserResponse{Id: matchingUser.Id, Token: accessToken, RefreshToken: refreshToken, IsChirpyRed: matchingUser.IsChirpyRed}, nil
+	return true, userResponse, nil
 }
 
-func (db *DB) VerifyAccessToken(jwtToken string, secret string) (int, error) {
-	token, err := jwt.ParseWithClaims(
-		jwtToken,
-		&jwt.RegisteredClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(secret), nil
-		})
+// issueTokens creates the access and refresh tokens for a user that has fully logged in
+func (db *DB) issueTokens(user AuthenticatedUser, secret string, requestedLifetime time.Duration) (AuthUserResponse, error) {
+	stringifiedId := fmt.Sprint(user.Id)
+
+	grant := TokenGrant{Role: user.RoleOrDefault(), Version: user.TokenVersion}
 
+	accessToken, err := createJWT(db.accessLifetime(requestedLifetime), secret, stringifiedId, ACCESS_ISSUER, db.tokens.Audience, grant)
 	if err != nil {
-		return -1, err
+		return AuthUserResponse{}, err
 	}
 
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
-	if !ok {
-		return -1, errors.New("Couldn't parse claims")
+	refreshToken, err := createJWT(db.tokens.RefreshLifetime, secret, stringifiedId, REFRESH_ISSUER, db.tokens.Audience, grant)
+	if err != nil {
+		return AuthUserResponse{}, err
+	}
+
+	return AuthUserResponse{Id: user.Id, Token: accessToken, RefreshToken: refreshToken, IsChirpyRed: user.IsChirpyRed(), Role: grant.Role}, nil
+}
+
+func (db *DB) VerifyAccessToken(jwtToken string, secret string) (int, error) {
+	userId, _, err := db.VerifyAccessGrant(jwtToken, secret)
+
+	return userId, err
+}
+
+// VerifyAccessGrant checks an access token and returns the user it was issued for and what it was granted
+func (db *DB) VerifyAccessGrant(jwtToken string, secret string) (int, TokenGrant, error) {
+	claims, err := db.parseJWT(jwtToken, secret, ACCESS_ISSUER)
+	if err != nil {
+		return -1, TokenGrant{}, err
 	}
 
-	if claims.Issuer != ACCESS_ISSUER {
-		return -1, errors.New("Token is not an access token")
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return -1, TokenGrant{}, err
 	}
 
-	if claims.ExpiresAt.UTC().Unix() < time.Now().UTC().Unix() {
-		return -1, errors.New("JWT has expired")
+	if _, isRevoked := currentDB.RevokedTokens[jwtToken]; isRevoked {
+		return -1, TokenGrant{}, unauthorized("Token is revoked")
 	}
 
 	subject, err := claims.GetSubject()
 	if err != nil {
-		return -1, err
+		return -1, TokenGrant{}, err
 	}
 
 	userId, err := strconv.Atoi(subject)
 	if err != nil {
-		return -1, err
+		return -1, TokenGrant{}, err
 	}
 
-	return userId, nil
-}
-
-func (db *DB) VerifyRefreshToken(jwtToken string, secret string) (string, error) {
-	token, err := jwt.ParseWithClaims(jwtToken, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+	user, ok := currentDB.Users[userId]
+	if !ok {
+		return -1, TokenGrant{}, unauthorized("Could not find user")
+	}
 
+	err = checkVersion(claims, user)
 	if err != nil {
-		return "", err
+		return -1, TokenGrant{}, err
 	}
 
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
-	if !ok {
-		return "", errors.New("Couldn't parse claims")
+	// restrictions apply straight away, rather than once outstanding tokens expire
+	err = checkRestricted(user)
+	if err != nil {
+		return -1, TokenGrant{}, err
 	}
 
-	if claims.Issuer != REFRESH_ISSUER {
-		return "", errors.New("Token is not a refresh token")
+	// and so do role changes, rather than trusting the role the token was issued with
+	grant := claims.grant()
+	if grant.ClientId == "" {
+		grant.Role = user.RoleOrDefault()
 	}
 
-	if claims.ExpiresAt.UTC().Unix() < time.Now().UTC().Unix() {
-		return "", errors.New("JWT has expired")
+	return userId, grant, nil
+}
+
+// VerifyRefreshToken checks a refresh token and issues a new access token with the same grant
+func (db *DB) VerifyRefreshToken(jwtToken string, secret string) (string, error) {
+	_, accessToken, err := db.refreshGrant(jwtToken, secret)
+
+	return accessToken, err
+}
+
+// refreshGrant checks a refresh token, returning its claims along with a fresh access token
+func (db *DB) refreshGrant(jwtToken string, secret string) (*chirpyClaims, string, error) {
+	claims, err := db.parseJWT(jwtToken, secret, REFRESH_ISSUER)
+	if err != nil {
+		return nil, "", err
 	}
 
 	currentDB, err := db.loadDB()
 	if err != nil {
-		return "", errors.New("Something went wrong")
+		return nil, "", errors.New("Something went wrong")
 	}
 
 	_, isRevoked := currentDB.RevokedTokens[jwtToken]
 	if isRevoked {
-		return "", errors.New("Token is revoked")
+		return nil, "", unauthorized("Token is revoked")
 	}
 
 	subject, err := claims.GetSubject()
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	newAccessToken, err := createJWT("1h", secret, subject, ACCESS_ISSUER)
-
+	userId, err := strconv.Atoi(subject)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	return newAccessToken, nil
-}
+	user, ok := currentDB.Users[userId]
+	if !ok {
+		return nil, "", notFound("Could not find user")
+	}
 
-func (db *DB) RevokeRefreshToken(jwtToken string) error {
-	currentDB, err := db.loadDB()
+	err = checkVersion(claims, user)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	currentDB.RevokedTokens[jwtToken] = RevokedToken{
-		Value: jwtToken,
-		Time:  time.Now().UTC().Format("StampMilli"),
+	err = checkRestricted(user)
+	if err != nil {
+		return nil, "", err
 	}
 
-	err = db.writeDB(currentDB)
+	// the role is looked up again so that promotions and demotions apply from the next refresh
+	grant := claims.grant()
+	if grant.ClientId == "" {
+		grant.Role = user.RoleOrDefault()
+	}
+
+	newAccessToken, err := createJWT(db.tokens.AccessLifetime, secret, subject, ACCESS_ISSUER, db.tokens.Audience, grant)
+
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	return nil
+	return claims, newAccessToken, nil
+}
+
+// RevokeToken stops a refresh or access token from being accepted again before it expires
+func (db *DB) RevokeToken(jwtToken string) error {
+	return db.update(func(currentDB *DBStructure) error {
+		currentDB.RevokedTokens[jwtToken] = RevokedToken{
+			Value: jwtToken,
+			Time:  time.Now().UTC().Format("StampMilli"),
+		}
+
+		return nil
+	})
 }
 
 // GetChirps returns all chirps in the database
@@ -367,13 +879,21 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 	return chirpSlice, nil
 }
 
+// Totals counts the chirps and users in the database
+func (db *DB) Totals() (chirps int, users int, err error) {
+	currentStructure, err := db.loadDB()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(currentStructure.Chirps), len(currentStructure.Users), nil
+}
+
 // ensureDB creates a new database file if it doesn't exist
 func (db *DB) ensureDB(path string) error {
-	dbContents := DBStructure{
-		Chirps:        map[int]Chirp{},
-		Users:         map[int]AuthenticatedUser{},
-		RevokedTokens: map[string]RevokedToken{},
-	}
+	dbContents := DBStructure{}
+	dbContents.initMaps()
+
 	dat, err := json.Marshal(dbContents)
 	if err != nil {
 		return err
@@ -388,10 +908,41 @@ func (db *DB) ensureDB(path string) error {
 }
 
 // loadDB reads the database file into memory
+// Anything that goes on to change what it read has to use update instead
 func (db *DB) loadDB() (DBStructure, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 
+	return db.readFile()
+}
+
+// update loads the database, lets change modify it and writes it back, holding the write lock
+// throughout so that nothing else can write in between. Nothing is written if change returns an error
+func (db *DB) update(change func(currentDB *DBStructure) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	currentDB, err := db.readFile()
+	if err != nil {
+		return err
+	}
+
+	err = change(&currentDB)
+	if err != nil {
+		return err
+	}
+
+	return db.writeFile(currentDB)
+}
+
+// readFile reads the database file, the caller has to hold the lock
+func (db *DB) readFile() (DBStructure, error) {
+	defer db.observeSince("load", time.Now())
+
+	if db.closed {
+		return DBStructure{}, ErrClosed
+	}
+
 	rawData, err := os.ReadFile(db.path)
 	if err != nil {
 		return DBStructure{}, err
@@ -404,75 +955,88 @@ func (db *DB) loadDB() (DBStructure, error) {
 		return DBStructure{}, err
 	}
 
+	dbData.initMaps()
+	dbData.migrateLegacyFields()
+
 	return dbData, nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+// writeFile writes the database file to disk, the caller has to hold the write lock
+func (db *DB) writeFile(dbStructure DBStructure) error {
+	defer db.observeSince("write", time.Now())
 
-	binData, err := json.Marshal(dbStructure)
-	if err != nil {
-		return err
+	if db.closed {
+		return ErrClosed
 	}
 
-	err = os.WriteFile(db.path, binData, 0666)
+	binData, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return writeFileAtomic(db.path, binData)
 }
 
-func (db *DB) DeleteChirp(token string, secret string, id int) error {
-	currentDB, err := db.loadDB()
-
+// writeFileAtomic writes to a temporary file next to path and renames it into place,
+// so a crash part way through never leaves a half written database behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	tokenUserId, err := db.VerifyAccessToken(token, secret)
-
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
 
-	chirpAuthorId := currentDB.Chirps[id].AuthorId
-
-	if chirpAuthorId != tokenUserId {
-		return errors.New("Wrong User!")
+	// keep the permissions of the file being replaced, rather than CreateTemp's 0600
+	mode := os.FileMode(0644)
+	if info, statErr := os.Stat(path); statErr == nil {
+		mode = info.Mode().Perm()
 	}
 
-	delete(currentDB.Chirps, id)
-
-	err = db.writeDB(currentDB)
-
+	err = os.Chmod(tmp.Name(), mode)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return os.Rename(tmp.Name(), path)
 }
 
-func (db *DB) UpgradeUser(userId int) error {
-	currentDB, err := db.loadDB()
+// Close waits for any write in progress to finish, then stops any more from happening
+func (db *DB) Close() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
-	if err != nil {
-		return err
-	}
+	db.closed = true
 
-	userToUpgrade, ok := currentDB.Users[userId]
+	return nil
+}
 
-	if !ok {
-		return errors.New("Could not find user")
-	}
+// DeleteChirp deletes a chirp, as long as it belongs to the user deleting it
+func (db *DB) DeleteChirp(userId int, id int) error {
+	return db.update(func(currentDB *DBStructure) error {
+		chirp, exists := currentDB.Chirps[id]
+		if !exists {
+			return notFound("Could not find chirp")
+		}
 
-	userToUpgrade.IsChirpyRed = true
+		if chirp.AuthorId != userId {
+			return forbidden("Wrong User!")
+		}
 
-	currentDB.Users[userId] = userToUpgrade
+		delete(currentDB.Chirps, id)
 
-	db.writeDB(currentDB)
+		currentDB.resolveReports(id, userId, REPORT_AUTHOR_DELETED, time.Now().UTC())
 
-	return nil
+		return nil
+	})
 }
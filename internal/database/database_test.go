@@ -0,0 +1,35 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+const testSecret = "test-secret"
+
+// testIP is the address logins in tests come from
+const testIP = "192.0.2.1"
+
+// newTestDB returns a database in a fresh temporary directory
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// newTestUser signs up a user with the given email and the password "password"
+func newTestUser(t *testing.T, db *DB, email string) User {
+	t.Helper()
+
+	user, err := db.CreateUser(email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+const EMAIL_VERIFICATION_LIFETIME = 48 * time.Hour
+
+// EmailVerification is tied to the address it was sent to,
+// so an old link can't verify an email the user has since changed to
+type EmailVerification struct {
+	UserId    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateEmailVerification issues a verification token for the user's current email,
+// replacing any they had already been sent
+func (db *DB) CreateEmailVerification(userId int) (string, AuthenticatedUser, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", AuthenticatedUser{}, err
+	}
+
+	user := AuthenticatedUser{}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		var ok bool
+		user, ok = currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		if user.EmailVerified {
+			return conflict("Email is already verified")
+		}
+
+		for hash, verification := range currentDB.EmailVerifications {
+			if verification.UserId == userId || verification.ExpiresAt.Before(time.Now()) {
+				delete(currentDB.EmailVerifications, hash)
+			}
+		}
+
+		currentDB.EmailVerifications[hashToken(token)] = EmailVerification{
+			UserId:    userId,
+			Email:     user.Email,
+			ExpiresAt: time.Now().UTC().Add(EMAIL_VERIFICATION_LIFETIME),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", AuthenticatedUser{}, err
+	}
+
+	return token, user, nil
+}
+
+// VerifyEmail uses up a verification token and marks the email it was sent to as verified
+func (db *DB) VerifyEmail(token string) (AuthenticatedUser, error) {
+	user := AuthenticatedUser{}
+	// an expired token is still used up, so rejecting it mustn't stop the update being written
+	var rejected error
+
+	err := db.update(func(currentDB *DBStructure) error {
+		hash := hashToken(token)
+
+		verification, ok := currentDB.EmailVerifications[hash]
+		if !ok {
+			return invalid("Invalid verification token")
+		}
+
+		delete(currentDB.EmailVerifications, hash)
+
+		user, ok = currentDB.Users[verification.UserId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		if verification.ExpiresAt.Before(time.Now()) || !strings.EqualFold(user.Email, verification.Email) {
+			rejected = invalid("Verification token has expired")
+			return nil
+		}
+
+		user.EmailVerified = true
+		currentDB.Users[user.Id] = user
+
+		return nil
+	})
+	if err != nil {
+		return AuthenticatedUser{}, err
+	}
+	if rejected != nil {
+		return AuthenticatedUser{}, rejected
+	}
+
+	return user, nil
+}
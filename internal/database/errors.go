@@ -0,0 +1,47 @@
+package database
+
+import "errors"
+
+// Every error about something the caller did is one of these kinds, so the API can
+// tell them apart with errors.Is without matching on messages
+var (
+	ErrNotFound     = errors.New("Not found")
+	ErrConflict     = errors.New("Conflict")
+	ErrForbidden    = errors.New("Forbidden")
+	ErrUnauthorized = errors.New("Unauthorized")
+	ErrInvalid      = errors.New("Invalid")
+)
+
+// Error is an error of one of the kinds above, with a message that says what went wrong
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func notFound(message string) error {
+	return &Error{Kind: ErrNotFound, Message: message}
+}
+
+func conflict(message string) error {
+	return &Error{Kind: ErrConflict, Message: message}
+}
+
+func forbidden(message string) error {
+	return &Error{Kind: ErrForbidden, Message: message}
+}
+
+func unauthorized(message string) error {
+	return &Error{Kind: ErrUnauthorized, Message: message}
+}
+
+func invalid(message string) error {
+	return &Error{Kind: ErrInvalid, Message: message}
+}
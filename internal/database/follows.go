@@ -0,0 +1,79 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrBlocked means the other user has blocked the caller
+var ErrBlocked = forbidden("This user has blocked you")
+
+type Follow struct {
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func followKey(followerId int, followeeId int) string {
+	return fmt.Sprintf("%d:%d", followerId, followeeId)
+}
+
+// FollowUser makes follower follow followee, reporting false if they already did
+func (db *DB) FollowUser(followerId int, followeeId int) (bool, error) {
+	if followerId == followeeId {
+		return false, invalid("You can't follow yourself")
+	}
+
+	followed := false
+
+	err := db.update(func(currentDB *DBStructure) error {
+		if _, ok := currentDB.Users[followeeId]; !ok {
+			return notFound("Could not find user")
+		}
+
+		if _, blocked := currentDB.Blocks[followKey(followeeId, followerId)]; blocked {
+			return ErrBlocked
+		}
+
+		key := followKey(followerId, followeeId)
+		if _, already := currentDB.Follows[key]; already {
+			return nil
+		}
+
+		currentDB.Follows[key] = Follow{
+			FollowerId: followerId,
+			FolloweeId: followeeId,
+			CreatedAt:  time.Now().UTC(),
+		}
+		followed = true
+
+		return nil
+	})
+
+	return followed, err
+}
+
+func (db *DB) UnfollowUser(followerId int, followeeId int) error {
+	return db.update(func(currentDB *DBStructure) error {
+		delete(currentDB.Follows, followKey(followerId, followeeId))
+
+		return nil
+	})
+}
+
+// GetFollowing returns the ids of everyone the user follows
+func (db *DB) GetFollowing(followerId int) (map[int]bool, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return map[int]bool{}, err
+	}
+
+	following := map[int]bool{}
+	for _, follow := range currentDB.Follows {
+		if follow.FollowerId == followerId {
+			following[follow.FolloweeId] = true
+		}
+	}
+
+	return following, nil
+}
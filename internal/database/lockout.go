@@ -0,0 +1,221 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// BackoffPolicy decides how long to wait before allowing another login after repeated failures
+type BackoffPolicy struct {
+	// FreeAttempts is how many failures are allowed before any delay kicks in
+	FreeAttempts int
+	// Base is the first delay, which doubles with every further failure up to Max
+	Base time.Duration
+	Max  time.Duration
+	// LockoutAfter is the failure count at which the delay counts as a lockout worth recording
+	LockoutAfter int
+}
+
+// AccountLoginPolicy applies to failed logins for a single account
+var AccountLoginPolicy = BackoffPolicy{
+	FreeAttempts: 3,
+	Base:         time.Second,
+	Max:          time.Hour,
+	LockoutAfter: 10,
+}
+
+// Delay returns how long logins are blocked for after the given number of consecutive failures
+func (policy BackoffPolicy) Delay(failures int) time.Duration {
+	if failures < policy.FreeAttempts {
+		return 0
+	}
+
+	delay := policy.Base
+	for i := policy.FreeAttempts; i < failures; i++ {
+		delay *= 2
+		if delay >= policy.Max {
+			return policy.Max
+		}
+	}
+
+	return delay
+}
+
+// LockedError is returned when a login is refused because of too many failures
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("Too many failed logins, try again after %s", e.Until.Format(time.RFC3339))
+}
+
+// LockoutEvent records an account getting locked, so an admin can see it and unlock it
+type LockoutEvent struct {
+	Id          int        `json:"id"`
+	UserId      int        `json:"user_id"`
+	IP          string     `json:"ip"`
+	Failures    int        `json:"failures"`
+	LockedAt    time.Time  `json:"locked_at"`
+	LockedUntil time.Time  `json:"locked_until"`
+	UnlockedAt  *time.Time `json:"unlocked_at,omitempty"`
+	UnlockedBy  int        `json:"unlocked_by,omitempty"`
+}
+
+// checkLocked returns a LockedError if the user can't try to log in right now
+func checkLocked(user AuthenticatedUser) error {
+	if time.Now().Before(user.LockedUntil) {
+		return &LockedError{Until: user.LockedUntil}
+	}
+
+	return nil
+}
+
+// recordFailedLogin counts a failure against the user and locks the account for a while
+// once there have been too many in a row
+func (db *DB) recordFailedLogin(userId int, ip string) error {
+	return db.update(func(currentDB *DBStructure) error {
+		user, ok := currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		now := time.Now().UTC()
+
+		user.FailedLogins++
+		delay := AccountLoginPolicy.Delay(user.FailedLogins)
+		if delay > 0 {
+			user.LockedUntil = now.Add(delay)
+		}
+
+		if user.FailedLogins >= AccountLoginPolicy.LockoutAfter {
+			recordLockout(currentDB, user, ip, now)
+		}
+
+		currentDB.Users[userId] = user
+
+		return nil
+	})
+}
+
+// recordLockout opens a lockout event for the user, or extends the one that's already open
+func recordLockout(currentDB *DBStructure, user AuthenticatedUser, ip string, now time.Time) {
+	for id, event := range currentDB.LockoutEvents {
+		if event.UserId == user.Id && event.UnlockedAt == nil && event.LockedUntil.After(now) {
+			event.Failures = user.FailedLogins
+			event.LockedUntil = user.LockedUntil
+			event.IP = ip
+			currentDB.LockoutEvents[id] = event
+			return
+		}
+	}
+
+	nextId := nextId(currentDB.LockoutEvents)
+	currentDB.LockoutEvents[nextId] = LockoutEvent{
+		Id:          nextId,
+		UserId:      user.Id,
+		IP:          ip,
+		Failures:    user.FailedLogins,
+		LockedAt:    now,
+		LockedUntil: user.LockedUntil,
+	}
+}
+
+// recordSuccessfulLogin clears the user's failure count
+func (db *DB) recordSuccessfulLogin(userId int) error {
+	user, ok, err := db.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+
+	// most logins don't follow a failure, so there's nothing to write
+	if ok && user.FailedLogins == 0 && user.LockedUntil.IsZero() {
+		return nil
+	}
+
+	return db.update(func(currentDB *DBStructure) error {
+		user, ok := currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		user.FailedLogins = 0
+		user.LockedUntil = time.Time{}
+		currentDB.Users[userId] = user
+
+		return nil
+	})
+}
+
+// GetLockoutEvents returns lockout events, newest first, optionally only the ones still in effect
+func (db *DB) GetLockoutEvents(activeOnly bool) ([]LockoutEvent, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []LockoutEvent{}, err
+	}
+
+	now := time.Now()
+	events := []LockoutEvent{}
+
+	for _, event := range currentDB.LockoutEvents {
+		if activeOnly && (event.UnlockedAt != nil || event.LockedUntil.Before(now)) {
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Id > events[j].Id
+	})
+
+	return events, nil
+}
+
+// UnlockUser clears a user's failed logins and closes any open lockout events
+// unlockedBy is the id of the admin doing it
+func (db *DB) UnlockUser(userId int, unlockedBy int) error {
+	return db.update(func(currentDB *DBStructure) error {
+		user, ok := currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		now := time.Now().UTC()
+
+		user.FailedLogins = 0
+		user.LockedUntil = time.Time{}
+		currentDB.Users[userId] = user
+
+		for id, event := range currentDB.LockoutEvents {
+			if event.UserId == userId && event.UnlockedAt == nil {
+				event.UnlockedAt = &now
+				event.UnlockedBy = unlockedBy
+				currentDB.LockoutEvents[id] = event
+			}
+		}
+
+		return nil
+	})
+}
+
+// nextId picks the id after the highest one already in use
+func nextId[T any](collection map[int]T) int {
+	highest := 0
+	for id := range collection {
+		if id > highest {
+			highest = id
+		}
+	}
+
+	return highest + 1
+}
+
+// nextCountedId is nextId for records that can be deleted, whose ids mustn't be handed out again.
+// last holds the highest id ever used, and catches up with the collection for files saved before it was kept
+func nextCountedId[T any](last *int, collection map[int]T) int {
+	*last = max(*last, nextId(collection)-1) + 1
+
+	return *last
+}
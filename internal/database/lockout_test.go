@@ -0,0 +1,106 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	policy := BackoffPolicy{FreeAttempts: 3, Base: time.Second, Max: time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{8, 32 * time.Second},
+		{9, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, test := range tests {
+		got := policy.Delay(test.failures)
+		if got != test.want {
+			t.Errorf("Delay(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestAccountLocksAfterFailedLogins(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "locked@example.com")
+
+	for i := 0; i < AccountLoginPolicy.FreeAttempts; i++ {
+		ok, _, err := db.AuthenticateUser(user.Email, "wrong", testSecret, 0, testIP)
+		if err != nil || ok {
+			t.Fatalf("failed login %d = %v, %v, want false", i+1, ok, err)
+		}
+	}
+
+	_, _, err := db.AuthenticateUser(user.Email, "password", testSecret, 0, testIP)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("logging in after %d failures = %v, want a LockedError", AccountLoginPolicy.FreeAttempts, err)
+	}
+}
+
+func TestLockoutEventsAndUnlock(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "locked@example.com")
+
+	for i := 0; i < AccountLoginPolicy.LockoutAfter+2; i++ {
+		err := db.recordFailedLogin(user.Id, testIP)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := db.GetLockoutEvents(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d active lockout events, want a single one kept up to date", len(events))
+	}
+	if events[0].UserId != user.Id || events[0].Failures != AccountLoginPolicy.LockoutAfter+2 || events[0].IP != testIP {
+		t.Errorf("lockout event = %+v", events[0])
+	}
+
+	admin := newTestUser(t, db, "admin@example.com")
+
+	err = db.UnlockUser(user.Id, admin.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, _, err := db.AuthenticateUser(user.Email, "password", testSecret, 0, testIP)
+	if err != nil || !ok {
+		t.Errorf("logging in after an unlock = %v, %v, want true", ok, err)
+	}
+
+	active, err := db.GetLockoutEvents(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 0 {
+		t.Errorf("got %d active lockout events after an unlock, want 0", len(active))
+	}
+
+	all, err := db.GetLockoutEvents(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].UnlockedAt == nil || all[0].UnlockedBy != admin.Id {
+		t.Errorf("lockout events after an unlock = %+v, want the event closed by the admin", all)
+	}
+
+	err = db.UnlockUser(999, admin.Id)
+	if err == nil {
+		t.Error("unlocking a user that doesn't exist succeeded")
+	}
+}
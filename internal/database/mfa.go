@@ -0,0 +1,340 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thegouge/go-chirpy/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const MFA_CHALLENGE_LIFETIME = 5 * time.Minute
+const TOTP_ISSUER = "Chirpy"
+const RECOVERY_CODE_COUNT = 10
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// BeginTOTPEnrollment generates a new secret for the user, which only
+// takes effect once it has been confirmed with a valid code
+func (db *DB) BeginTOTPEnrollment(userId int) (TOTPEnrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	user := AuthenticatedUser{}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		var ok bool
+		user, ok = currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		if user.TOTPEnabled {
+			return conflict("Two-factor authentication is already enabled")
+		}
+
+		user.TOTPPendingSecret = secret
+		currentDB.Users[userId] = user
+
+		return nil
+	})
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	return TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(TOTP_ISSUER, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment turns on two-factor authentication if the code matches the pending secret,
+// and returns a fresh set of recovery codes that are only ever shown this once
+func (db *DB) ConfirmTOTPEnrollment(userId int, code string) ([]string, error) {
+	user, ok, err := db.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFound("Could not find user")
+	}
+
+	if user.TOTPPendingSecret == "" {
+		return nil, conflict("There is no pending two-factor enrollment")
+	}
+
+	pendingSecret := user.TOTPPendingSecret
+	counter, valid := totp.Match(pendingSecret, code, time.Now())
+	if !valid {
+		return nil, invalid("Invalid code")
+	}
+
+	// hashing the codes is slow, so it's done before taking the write lock
+	recoveryCodes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		user, ok := currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		// the enrollment was restarted or confirmed while the codes were being made
+		if user.TOTPEnabled || user.TOTPPendingSecret != pendingSecret {
+			return conflict("There is no pending two-factor enrollment")
+		}
+
+		user.TOTPEnabled = true
+		user.TOTPSecret = user.TOTPPendingSecret
+		user.TOTPPendingSecret = ""
+		user.TOTPLastCounter = counter
+		user.RecoveryCodes = hashes
+		currentDB.Users[userId] = user
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// DisableTOTP turns two-factor authentication off, after checking a current code or recovery code
+func (db *DB) DisableTOTP(userId int, code string) error {
+	user, ok, err := db.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return notFound("Could not find user")
+	}
+
+	if !user.TOTPEnabled {
+		return conflict("Two-factor authentication is not enabled")
+	}
+
+	// checking recovery codes is slow, so it's done before taking the write lock
+	factor, valid := matchSecondFactor(user, code)
+	if !valid {
+		return invalid("Invalid code")
+	}
+
+	return db.update(func(currentDB *DBStructure) error {
+		user, ok := currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		if !user.TOTPEnabled {
+			return conflict("Two-factor authentication is not enabled")
+		}
+
+		// the code was used, or TOTP was set up again, while it was being checked
+		user, valid := factor.use(user)
+		if !valid {
+			return invalid("Invalid code")
+		}
+
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPLastCounter = 0
+		user.RecoveryCodes = nil
+		currentDB.Users[userId] = user
+
+		return nil
+	})
+}
+
+// errWrongCode is how CompleteMFALogin's update hands back a wrong code, so the failure
+// can be counted once nothing is being written
+var errWrongCode = errors.New("wrong second factor")
+
+// CompleteMFALogin exchanges the challenge token from AuthenticateUser and a TOTP or recovery code
+// for the real access and refresh tokens
+// Wrong codes count towards locking the account just like wrong passwords
+func (db *DB) CompleteMFALogin(mfaToken string, code string, secret string, requestedLifetime time.Duration, ip string) (bool, AuthUserResponse, error) {
+	claims, err := db.parseJWT(mfaToken, secret, MFA_ISSUER)
+	if err != nil {
+		return false, AuthUserResponse{}, err
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return false, AuthUserResponse{}, err
+	}
+
+	user, ok, err := db.GetUserById(userId)
+	if err != nil {
+		return false, AuthUserResponse{}, err
+	}
+
+	if !ok || !user.TOTPEnabled {
+		return false, AuthUserResponse{}, notFound("Could not find user")
+	}
+
+	// checking recovery codes is slow, so it's done before taking the write lock
+	factor, matched := matchSecondFactor(user, code)
+
+	err = db.update(func(currentDB *DBStructure) error {
+		if _, used := currentDB.RevokedTokens[mfaToken]; used {
+			return unauthorized("Challenge has already been used")
+		}
+
+		user, ok = currentDB.Users[userId]
+		if !ok || !user.TOTPEnabled {
+			return notFound("Could not find user")
+		}
+
+		err := checkVersion(claims, user)
+		if err != nil {
+			return err
+		}
+
+		err = checkLocked(user)
+		if err != nil {
+			return err
+		}
+
+		if !matched {
+			return errWrongCode
+		}
+
+		// the code was used by another login while it was being checked
+		user, ok = factor.use(user)
+		if !ok {
+			return errWrongCode
+		}
+
+		err = checkRestricted(user)
+		if err != nil {
+			return err
+		}
+
+		user.FailedLogins = 0
+		user.LockedUntil = time.Time{}
+		currentDB.Users[userId] = user
+		currentDB.RevokedTokens[mfaToken] = RevokedToken{
+			Value: mfaToken,
+			Time:  time.Now().UTC().Format(time.StampMilli),
+		}
+
+		return nil
+	})
+	if errors.Is(err, errWrongCode) {
+		return false, AuthUserResponse{}, db.recordFailedLogin(userId, ip)
+	}
+	if err != nil {
+		return false, AuthUserResponse{}, err
+	}
+
+	response, err := db.issueTokens(user, secret, requestedLifetime)
+	if err != nil {
+		return false, AuthUserResponse{}, err
+	}
+
+	return true, response, nil
+}
+
+// secondFactor is a code that matched the user when it was checked, which still has to be used up
+// against the user as they are under the write lock
+type secondFactor struct {
+	totpSecret   string
+	totpCounter  int64
+	recoveryHash []byte
+}
+
+// matchSecondFactor accepts either a TOTP code that hasn't been used yet or an unused recovery code
+func matchSecondFactor(user AuthenticatedUser, code string) (secondFactor, bool) {
+	counter, valid := totp.Match(user.TOTPSecret, code, time.Now())
+	if valid {
+		return secondFactor{totpSecret: user.TOTPSecret, totpCounter: counter}, counter > user.TOTPLastCounter
+	}
+
+	normalized := normalizeRecoveryCode(code)
+
+	for _, hash := range user.RecoveryCodes {
+		if bcrypt.CompareHashAndPassword(hash, []byte(normalized)) == nil {
+			return secondFactor{recoveryHash: hash}, true
+		}
+	}
+
+	return secondFactor{}, false
+}
+
+// use returns the user updated so that the code can't be used again, failing if it
+// already has been since it was matched
+func (factor secondFactor) use(user AuthenticatedUser) (AuthenticatedUser, bool) {
+	if factor.recoveryHash == nil {
+		if user.TOTPSecret != factor.totpSecret || factor.totpCounter <= user.TOTPLastCounter {
+			return user, false
+		}
+
+		user.TOTPLastCounter = factor.totpCounter
+		return user, true
+	}
+
+	for i, hash := range user.RecoveryCodes {
+		if bytes.Equal(hash, factor.recoveryHash) {
+			remaining := make([][]byte, 0, len(user.RecoveryCodes)-1)
+			remaining = append(remaining, user.RecoveryCodes[:i]...)
+			remaining = append(remaining, user.RecoveryCodes[i+1:]...)
+			user.RecoveryCodes = remaining
+
+			return user, true
+		}
+	}
+
+	return user, false
+}
+
+// generateRecoveryCodes returns the plaintext codes for the user and the hashes to store
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	hashes := make([][]byte, 0, RECOVERY_CODE_COUNT)
+
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		raw := make([]byte, 7)
+
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		encoded := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(code)), 0)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+
+	return strings.ReplaceAll(code, " ", "")
+}
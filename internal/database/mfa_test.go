@@ -0,0 +1,180 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thegouge/go-chirpy/internal/totp"
+)
+
+// enrollTOTP turns on two-factor authentication for the user, returning the secret and recovery codes
+func enrollTOTP(t *testing.T, db *DB, userId int) (string, []string) {
+	t.Helper()
+
+	enrollment, err := db.BeginTOTPEnrollment(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.Code(enrollment.Secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recoveryCodes, err := db.ConfirmTOTPEnrollment(userId, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return enrollment.Secret, recoveryCodes
+}
+
+// challenge logs in with the password, returning the MFA challenge token
+func challenge(t *testing.T, db *DB, email string) string {
+	t.Helper()
+
+	ok, response, err := db.AuthenticateUser(email, "password", testSecret, 0, testIP)
+	if err != nil || !ok {
+		t.Fatalf("AuthenticateUser = %v, %v", ok, err)
+	}
+	if !response.MFARequired || response.Token != "" {
+		t.Fatalf("AuthenticateUser with TOTP on = %+v, want a challenge and no tokens", response)
+	}
+
+	return response.MFAToken
+}
+
+func TestConfirmTOTPEnrollment(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "mfa@example.com")
+
+	enrollment, err := db.BeginTOTPEnrollment(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.ConfirmTOTPEnrollment(user.Id, "000000")
+	if err == nil {
+		t.Error("ConfirmTOTPEnrollment with a wrong code succeeded")
+	}
+
+	code, err := totp.Code(enrollment.Secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recoveryCodes, err := db.ConfirmTOTPEnrollment(user.Id, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != RECOVERY_CODE_COUNT {
+		t.Errorf("got %d recovery codes, want %d", len(recoveryCodes), RECOVERY_CODE_COUNT)
+	}
+
+	_, err = db.BeginTOTPEnrollment(user.Id)
+	if err == nil {
+		t.Error("BeginTOTPEnrollment succeeded with TOTP already on")
+	}
+}
+
+func TestCompleteMFALogin(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "mfa@example.com")
+	secret, recoveryCodes := enrollTOTP(t, db, user.Id)
+
+	enrolled, _, err := db.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the code used to confirm enrollment can't be used again
+	used, err := totp.Code(secret, enrolled.TOTPLastCounter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, _, err := db.CompleteMFALogin(challenge(t, db, user.Email), used, testSecret, 0, testIP)
+	if err != nil || ok {
+		t.Errorf("CompleteMFALogin with a used code = %v, %v, want false", ok, err)
+	}
+
+	next, err := totp.Code(secret, enrolled.TOTPLastCounter+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfaToken := challenge(t, db, user.Email)
+	ok, response, err := db.CompleteMFALogin(mfaToken, next, testSecret, 0, testIP)
+	if err != nil || !ok || response.Token == "" || response.RefreshToken == "" {
+		t.Fatalf("CompleteMFALogin with the next code = %v, %+v, %v, want tokens", ok, response, err)
+	}
+
+	// each challenge only works once
+	_, _, err = db.CompleteMFALogin(mfaToken, recoveryCodes[0], testSecret, 0, testIP)
+	if err == nil {
+		t.Error("CompleteMFALogin with a used challenge succeeded")
+	}
+
+	// recovery codes work once each, however they're typed
+	typed := " " + recoveryCodes[0][:3] + " " + recoveryCodes[0][3:] + " "
+	ok, _, err = db.CompleteMFALogin(challenge(t, db, user.Email), typed, testSecret, 0, testIP)
+	if err != nil || !ok {
+		t.Errorf("CompleteMFALogin with a recovery code = %v, %v, want true", ok, err)
+	}
+	ok, _, err = db.CompleteMFALogin(challenge(t, db, user.Email), recoveryCodes[0], testSecret, 0, testIP)
+	if err != nil || ok {
+		t.Errorf("CompleteMFALogin with a used recovery code = %v, %v, want false", ok, err)
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "mfa@example.com")
+	_, recoveryCodes := enrollTOTP(t, db, user.Id)
+
+	err := db.DisableTOTP(user.Id, "00000-00000")
+	if err == nil {
+		t.Error("DisableTOTP with a wrong code succeeded")
+	}
+
+	err = db.DisableTOTP(user.Id, recoveryCodes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, response, err := db.AuthenticateUser(user.Email, "password", testSecret, 0, testIP)
+	if err != nil || !ok || response.MFARequired || response.Token == "" {
+		t.Errorf("AuthenticateUser after disabling TOTP = %v, %+v, %v, want tokens straight away", ok, response, err)
+	}
+}
+
+func TestSecondFactorIsUsedOnce(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "mfa@example.com")
+	secret, recoveryCodes := enrollTOTP(t, db, user.Id)
+
+	enrolled, _, err := db.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := totp.Code(secret, enrolled.TOTPLastCounter+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two requests can match the same code before either of them uses it up
+	for _, code := range []string{next, recoveryCodes[0]} {
+		factor, matched := matchSecondFactor(enrolled, code)
+		if !matched {
+			t.Fatalf("matchSecondFactor(%q) didn't match", code)
+		}
+		again, _ := matchSecondFactor(enrolled, code)
+
+		after, ok := factor.use(enrolled)
+		if !ok {
+			t.Fatalf("using %q failed", code)
+		}
+		if _, ok := again.use(after); ok {
+			t.Errorf("%q was used twice", code)
+		}
+	}
+}
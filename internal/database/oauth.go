@@ -0,0 +1,289 @@
+package database
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const OAUTH_CODE_LIFETIME = time.Minute
+const OAUTH_CLIENT_PREFIX = "chirpy-client-"
+
+// ErrInvalidGrant covers every way an authorization code or refresh token can be rejected
+var ErrInvalidGrant = errors.New("invalid_grant")
+
+// OAuthClient is a third-party app that users can grant access to their account
+type OAuthClient struct {
+	Id           string    `json:"id"`
+	Name         string    `json:"name"`
+	OwnerId      int       `json:"owner_id"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Confidential clients can keep a secret (e.g. a backend), public ones (e.g. mobile apps) only use PKCE
+func (client OAuthClient) Confidential() bool {
+	return client.SecretHash != ""
+}
+
+// AllowsRedirect reports whether redirectURI is one the client registered, and is still safe to send users to
+func (client OAuthClient) AllowsRedirect(redirectURI string) bool {
+	if !ValidRedirectURI(redirectURI) {
+		return false
+	}
+
+	for _, allowed := range client.RedirectURIs {
+		if allowed == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidRedirectURI only accepts https URIs, or http ones back to the user's own machine (RFC 8252),
+// since anything else (like a javascript: URI) could run in Chirpy's origin or leak the code
+func ValidRedirectURI(redirectURI string) bool {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || parsed.Fragment != "" || parsed.Host == "" || parsed.User != nil {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		if host == "localhost" {
+			return true
+		}
+
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	default:
+		return false
+	}
+}
+
+// CheckSecret compares a client secret in constant time
+func (client OAuthClient) CheckSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) == 1
+}
+
+// OAuthCode is a pending authorization code, only ever stored hashed
+type OAuthCode struct {
+	ClientId      string    `json:"client_id"`
+	UserId        int       `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	CodeChallenge string    `json:"code_challenge"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// OAuthTokens is what the token endpoint hands back to a client
+type OAuthTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+	Scopes       []string
+}
+
+// RegisterOAuthClient creates a client for the user, returning a secret if it's confidential
+func (db *DB) RegisterOAuthClient(ownerId int, name string, redirectURIs []string, confidential bool) (OAuthClient, string, error) {
+	for _, redirectURI := range redirectURIs {
+		if !ValidRedirectURI(redirectURI) {
+			return OAuthClient{}, "", invalid("Redirect URIs have to be https://, or http:// to localhost: " + redirectURI)
+		}
+	}
+
+	id, err := newTokenId()
+	if err != nil {
+		return OAuthClient{}, "", err
+	}
+
+	newClient := OAuthClient{
+		Id:           OAUTH_CLIENT_PREFIX + id,
+		Name:         name,
+		OwnerId:      ownerId,
+		RedirectURIs: redirectURIs,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	secret := ""
+	if confidential {
+		secret, err = newOpaqueToken()
+		if err != nil {
+			return OAuthClient{}, "", err
+		}
+
+		newClient.SecretHash = hashToken(secret)
+	}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		currentDB.OAuthClients[newClient.Id] = newClient
+
+		return nil
+	})
+	if err != nil {
+		return OAuthClient{}, "", err
+	}
+
+	return newClient, secret, nil
+}
+
+func (db *DB) GetOAuthClient(clientId string) (OAuthClient, bool, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return OAuthClient{}, false, err
+	}
+
+	client, ok := currentDB.OAuthClients[clientId]
+
+	return client, ok, nil
+}
+
+// CreateAuthorizationCode records the user's consent and returns a short-lived code
+// that the client can exchange along with the PKCE verifier
+func (db *DB) CreateAuthorizationCode(clientId string, userId int, redirectURI string, scopes []string, codeChallenge string) (string, error) {
+	code, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		now := time.Now()
+		for hash, pending := range currentDB.OAuthCodes {
+			if pending.ExpiresAt.Before(now) {
+				delete(currentDB.OAuthCodes, hash)
+			}
+		}
+
+		currentDB.OAuthCodes[hashToken(code)] = OAuthCode{
+			ClientId:      clientId,
+			UserId:        userId,
+			RedirectURI:   redirectURI,
+			Scopes:        scopes,
+			CodeChallenge: codeChallenge,
+			ExpiresAt:     now.UTC().Add(OAUTH_CODE_LIFETIME),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeAuthorizationCode uses up an authorization code and issues tokens carrying its scopes
+func (db *DB) ExchangeAuthorizationCode(code string, clientId string, redirectURI string, codeVerifier string, secret string) (OAuthTokens, error) {
+	hash := hashToken(code)
+	pending := OAuthCode{}
+	user := AuthenticatedUser{}
+	userFound := false
+
+	// the code is used up whether or not the exchange goes on to succeed
+	err := db.update(func(currentDB *DBStructure) error {
+		var ok bool
+		pending, ok = currentDB.OAuthCodes[hash]
+		if !ok {
+			return ErrInvalidGrant
+		}
+
+		delete(currentDB.OAuthCodes, hash)
+		user, userFound = currentDB.Users[pending.UserId]
+
+		return nil
+	})
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+
+	if pending.ExpiresAt.Before(time.Now()) || pending.ClientId != clientId || pending.RedirectURI != redirectURI {
+		return OAuthTokens{}, ErrInvalidGrant
+	}
+
+	if !verifyPKCE(codeVerifier, pending.CodeChallenge) {
+		return OAuthTokens{}, ErrInvalidGrant
+	}
+
+	if !userFound || checkRestricted(user) != nil {
+		return OAuthTokens{}, ErrInvalidGrant
+	}
+
+	grant := TokenGrant{Scopes: pending.Scopes, ClientId: clientId, Version: user.TokenVersion}
+	subject := strconv.Itoa(pending.UserId)
+
+	accessToken, err := createJWT(db.tokens.AccessLifetime, secret, subject, ACCESS_ISSUER, db.tokens.Audience, grant)
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+
+	refreshToken, err := createJWT(db.tokens.RefreshLifetime, secret, subject, REFRESH_ISSUER, db.tokens.Audience, grant)
+	if err != nil {
+		return OAuthTokens{}, err
+	}
+
+	return OAuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    db.tokens.AccessLifetime,
+		Scopes:       pending.Scopes,
+	}, nil
+}
+
+// RefreshOAuthToken issues a new access token from a refresh token that was issued to the client
+func (db *DB) RefreshOAuthToken(refreshToken string, clientId string, secret string) (OAuthTokens, error) {
+	claims, accessToken, err := db.refreshGrant(refreshToken, secret)
+	if err != nil {
+		return OAuthTokens{}, ErrInvalidGrant
+	}
+
+	if claims.ClientId != clientId {
+		return OAuthTokens{}, ErrInvalidGrant
+	}
+
+	return OAuthTokens{
+		AccessToken: accessToken,
+		ExpiresIn:   db.tokens.AccessLifetime,
+		Scopes:      claims.grant().Scopes,
+	}, nil
+}
+
+// verifyPKCE checks the verifier against an S256 code challenge (RFC 7636)
+func verifyPKCE(verifier string, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// RevokeOAuthToken revokes an access or refresh token, as long as it was issued to the client asking
+func (db *DB) RevokeOAuthToken(token string, clientId string, secret string) error {
+	claims, err := db.parseJWT(token, secret, REFRESH_ISSUER)
+	if err != nil {
+		claims, err = db.parseJWT(token, secret, ACCESS_ISSUER)
+	}
+
+	if err != nil {
+		// already expired or never valid, so there's nothing to revoke
+		return nil
+	}
+
+	if claims.ClientId != clientId {
+		return ErrInvalidGrant
+	}
+
+	return db.RevokeToken(token)
+}
@@ -0,0 +1,308 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"time"
+)
+
+const EVENT_CHIRP_CREATED = "chirp.created"
+const EVENT_CHIRP_DELETED = "chirp.deleted"
+const EVENT_USER_MENTIONED = "user.mentioned"
+const EVENT_USER_FOLLOWED = "user.followed"
+
+// AllWebhookEvents lists every event users can subscribe an endpoint to
+var AllWebhookEvents = []string{EVENT_CHIRP_CREATED, EVENT_CHIRP_DELETED, EVENT_USER_MENTIONED, EVENT_USER_FOLLOWED}
+
+const DELIVERY_PENDING = "pending"
+const DELIVERY_SUCCEEDED = "succeeded"
+const DELIVERY_FAILED = "failed"
+
+// WebhookEndpoint is a URL a user wants some of their events sent to
+type WebhookEndpoint struct {
+	Id        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (endpoint WebhookEndpoint) subscribedTo(eventType string) bool {
+	for _, subscribed := range endpoint.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeliveryAttempt is one try at sending a delivery
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// WebhookDelivery is one event on its way to one endpoint
+type WebhookDelivery struct {
+	Id            int               `json:"id"`
+	EndpointId    int               `json:"endpoint_id"`
+	EventId       string            `json:"event_id"`
+	EventType     string            `json:"event_type"`
+	Payload       json.RawMessage   `json:"payload"`
+	Status        string            `json:"status"`
+	Attempts      []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	DeliveredAt   *time.Time        `json:"delivered_at,omitempty"`
+}
+
+func ValidWebhookEvent(eventType string) bool {
+	for _, known := range AllWebhookEvents {
+		if eventType == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateWebhookEndpoint registers an HTTPS endpoint for the user, with a new secret to sign deliveries with
+func (db *DB) CreateWebhookEndpoint(userId int, endpointURL string, events []string) (WebhookEndpoint, error) {
+	parsed, err := url.Parse(endpointURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return WebhookEndpoint{}, invalid("Webhook URLs have to be absolute https:// URLs")
+	}
+
+	for _, eventType := range events {
+		if !ValidWebhookEvent(eventType) {
+			return WebhookEndpoint{}, invalid("Unknown event: " + eventType)
+		}
+	}
+
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	endpoint := WebhookEndpoint{}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		nextId := nextCountedId(&currentDB.LastWebhookEndpointId, currentDB.WebhookEndpoints)
+		endpoint = WebhookEndpoint{
+			Id:        nextId,
+			UserId:    userId,
+			URL:       endpointURL,
+			Events:    events,
+			Secret:    "whsec_" + secret,
+			CreatedAt: time.Now().UTC(),
+		}
+
+		currentDB.WebhookEndpoints[nextId] = endpoint
+
+		return nil
+	})
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	return endpoint, nil
+}
+
+func (db *DB) GetWebhookEndpoints(userId int) ([]WebhookEndpoint, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []WebhookEndpoint{}, err
+	}
+
+	endpoints := []WebhookEndpoint{}
+	for _, endpoint := range currentDB.WebhookEndpoints {
+		if endpoint.UserId == userId {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Id < endpoints[j].Id
+	})
+
+	return endpoints, nil
+}
+
+// GetWebhookEndpoint looks up an endpoint, as long as it belongs to the user
+func (db *DB) GetWebhookEndpoint(userId int, endpointId int) (WebhookEndpoint, bool, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return WebhookEndpoint{}, false, err
+	}
+
+	endpoint, ok := currentDB.WebhookEndpoints[endpointId]
+	if !ok || endpoint.UserId != userId {
+		return WebhookEndpoint{}, false, nil
+	}
+
+	return endpoint, true, nil
+}
+
+// DeleteWebhookEndpoint removes one of the user's endpoints along with its delivery history
+func (db *DB) DeleteWebhookEndpoint(userId int, endpointId int) error {
+	return db.update(func(currentDB *DBStructure) error {
+		endpoint, ok := currentDB.WebhookEndpoints[endpointId]
+		if !ok || endpoint.UserId != userId {
+			return notFound("Could not find webhook")
+		}
+
+		delete(currentDB.WebhookEndpoints, endpointId)
+
+		for id, delivery := range currentDB.WebhookDeliveries {
+			if delivery.EndpointId == endpointId {
+				delete(currentDB.WebhookDeliveries, id)
+			}
+		}
+
+		return nil
+	})
+}
+
+// errNothingQueued stops EnqueueWebhookEvent writing when nobody is subscribed
+var errNothingQueued = errors.New("nothing queued")
+
+// EnqueueWebhookEvent queues a delivery of the payload to every endpoint the given users
+// have subscribed to this event, returning how many were queued
+func (db *DB) EnqueueWebhookEvent(eventId string, eventType string, payload []byte, userIds []int) (int, error) {
+	recipients := map[int]bool{}
+	for _, userId := range userIds {
+		recipients[userId] = true
+	}
+
+	queued := 0
+
+	err := db.update(func(currentDB *DBStructure) error {
+		now := time.Now().UTC()
+
+		for _, endpoint := range currentDB.WebhookEndpoints {
+			if !recipients[endpoint.UserId] || !endpoint.subscribedTo(eventType) {
+				continue
+			}
+
+			nextId := nextCountedId(&currentDB.LastWebhookDeliveryId, currentDB.WebhookDeliveries)
+			currentDB.WebhookDeliveries[nextId] = WebhookDelivery{
+				Id:            nextId,
+				EndpointId:    endpoint.Id,
+				EventId:       eventId,
+				EventType:     eventType,
+				Payload:       json.RawMessage(payload),
+				Status:        DELIVERY_PENDING,
+				Attempts:      []DeliveryAttempt{},
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			}
+			queued++
+		}
+
+		if queued == 0 {
+			return errNothingQueued
+		}
+
+		return nil
+	})
+	if errors.Is(err, errNothingQueued) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return queued, nil
+}
+
+// DueWebhookDelivery is a pending delivery along with where it's going
+type DueWebhookDelivery struct {
+	Delivery WebhookDelivery
+	Endpoint WebhookEndpoint
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due, oldest first
+func (db *DB) DueWebhookDeliveries(now time.Time, limit int) ([]DueWebhookDelivery, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	due := []DueWebhookDelivery{}
+	for _, delivery := range currentDB.WebhookDeliveries {
+		if delivery.Status != DELIVERY_PENDING || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+
+		endpoint, ok := currentDB.WebhookEndpoints[delivery.EndpointId]
+		if !ok {
+			continue
+		}
+
+		due = append(due, DueWebhookDelivery{Delivery: delivery, Endpoint: endpoint})
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Delivery.Id < due[j].Delivery.Id
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+// RecordDeliveryAttempt saves how an attempt went, and either finishes the delivery or schedules the next try
+// A zero retryAt means there won't be another try
+func (db *DB) RecordDeliveryAttempt(deliveryId int, attempt DeliveryAttempt, succeeded bool, retryAt time.Time) error {
+	return db.update(func(currentDB *DBStructure) error {
+		delivery, ok := currentDB.WebhookDeliveries[deliveryId]
+		if !ok {
+			// the endpoint was deleted while we were sending to it
+			return nil
+		}
+
+		delivery.Attempts = append(delivery.Attempts, attempt)
+
+		switch {
+		case succeeded:
+			delivery.Status = DELIVERY_SUCCEEDED
+			delivery.DeliveredAt = &attempt.At
+		case retryAt.IsZero():
+			delivery.Status = DELIVERY_FAILED
+		default:
+			delivery.NextAttemptAt = retryAt
+		}
+
+		currentDB.WebhookDeliveries[deliveryId] = delivery
+
+		return nil
+	})
+}
+
+// GetWebhookDeliveries returns the delivery history of an endpoint, newest first
+func (db *DB) GetWebhookDeliveries(endpointId int) ([]WebhookDelivery, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []WebhookDelivery{}, err
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range currentDB.WebhookDeliveries {
+		if delivery.EndpointId == endpointId {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Id > deliveries[j].Id
+	})
+
+	return deliveries, nil
+}
@@ -0,0 +1,45 @@
+package database
+
+import "testing"
+
+func TestWebhookIdsAreNeverReused(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "hooks@example.com")
+
+	// createAndDeliver registers an endpoint and queues one delivery to it
+	createAndDeliver := func() (WebhookEndpoint, WebhookDelivery) {
+		t.Helper()
+
+		endpoint, err := db.CreateWebhookEndpoint(user.Id, "https://hooks.example.com/chirpy", []string{EVENT_CHIRP_CREATED})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.EnqueueWebhookEvent("evt_1", EVENT_CHIRP_CREATED, []byte(`{}`), []int{user.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deliveries, err := db.GetWebhookDeliveries(endpoint.Id)
+		if err != nil || len(deliveries) != 1 {
+			t.Fatalf("GetWebhookDeliveries = %+v, %v, want one delivery", deliveries, err)
+		}
+
+		return endpoint, deliveries[0]
+	}
+
+	first, firstDelivery := createAndDeliver()
+
+	err := db.DeleteWebhookEndpoint(user.Id, first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, secondDelivery := createAndDeliver()
+	if second.Id == first.Id {
+		t.Errorf("a new endpoint took the id %d of a deleted one", second.Id)
+	}
+	if secondDelivery.Id == firstDelivery.Id {
+		t.Errorf("a new delivery took the id %d of a deleted one", secondDelivery.Id)
+	}
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const PASSWORD_RESET_LIFETIME = 30 * time.Minute
+
+type PasswordReset struct {
+	UserId    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreatePasswordReset issues a single-use reset token for the user,
+// replacing any reset they had already asked for
+func (db *DB) CreatePasswordReset(userId int) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		if _, ok := currentDB.Users[userId]; !ok {
+			return notFound("Could not find user")
+		}
+
+		for hash, reset := range currentDB.PasswordResets {
+			if reset.UserId == userId || reset.ExpiresAt.Before(time.Now()) {
+				delete(currentDB.PasswordResets, hash)
+			}
+		}
+
+		currentDB.PasswordResets[hashToken(token)] = PasswordReset{
+			UserId:    userId,
+			ExpiresAt: time.Now().UTC().Add(PASSWORD_RESET_LIFETIME),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ResetPassword uses up a reset token and sets the user's new password, revoking every token
+// they already had so that anyone else who got into the account is logged out
+func (db *DB) ResetPassword(token string, password string) error {
+	hashword, err := bcrypt.GenerateFromPassword([]byte(password), 0)
+	if err != nil {
+		return err
+	}
+
+	hash := hashToken(token)
+	// an expired token is still used up, so rejecting it mustn't stop the update being written
+	var rejected error
+
+	err = db.update(func(currentDB *DBStructure) error {
+		reset, ok := currentDB.PasswordResets[hash]
+		if !ok {
+			return invalid("Invalid reset token")
+		}
+
+		delete(currentDB.PasswordResets, hash)
+
+		if reset.ExpiresAt.Before(time.Now()) {
+			rejected = invalid("Reset token has expired")
+			return nil
+		}
+
+		user, ok := currentDB.Users[reset.UserId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		user.Password = hashword
+		user.TokenVersion++
+		currentDB.Users[user.Id] = user
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return rejected
+}
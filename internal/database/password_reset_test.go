@@ -0,0 +1,87 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPasswordResetIsSingleUse(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "reset@example.com")
+
+	token, err := db.CreatePasswordReset(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, _, err := db.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.ResetPassword(token, "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, _, err := db.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.TokenVersion != before.TokenVersion+1 {
+		t.Errorf("TokenVersion = %d after a reset, want %d", after.TokenVersion, before.TokenVersion+1)
+	}
+
+	err = db.ResetPassword(token, "another password")
+	if err == nil {
+		t.Error("ResetPassword succeeded twice with the same token")
+	}
+}
+
+func TestPasswordResetReplacesEarlierReset(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "reset@example.com")
+
+	first, err := db.CreatePasswordReset(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreatePasswordReset(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.ResetPassword(first, "new password")
+	if err == nil {
+		t.Error("an earlier reset token still works after asking for another")
+	}
+	err = db.ResetPassword(second, "new password")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "reset@example.com")
+
+	token, err := db.CreatePasswordReset(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		reset := currentDB.PasswordResets[hashToken(token)]
+		reset.ExpiresAt = time.Now().Add(-time.Minute)
+		currentDB.PasswordResets[hashToken(token)] = reset
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.ResetPassword(token, "new password")
+	if err == nil {
+		t.Error("ResetPassword accepted an expired token")
+	}
+}
@@ -0,0 +1,270 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+const REPORT_SPAM = "spam"
+const REPORT_HARASSMENT = "harassment"
+const REPORT_HATE = "hate"
+const REPORT_VIOLENCE = "violence"
+const REPORT_MISINFORMATION = "misinformation"
+const REPORT_OTHER = "other"
+
+// REPORT_AUTOMOD is used for reports filed by chirp moderation rather than a person
+const REPORT_AUTOMOD = "automod"
+
+// AllReportReasons are the reasons users can report a chirp for
+var AllReportReasons = []string{REPORT_SPAM, REPORT_HARASSMENT, REPORT_HATE, REPORT_VIOLENCE, REPORT_MISINFORMATION, REPORT_OTHER}
+
+const REPORT_OPEN = "open"
+const REPORT_RESOLVED = "resolved"
+
+// REPORT_AUTHOR_DELETED is the resolution of reports on a chirp its author deleted
+const REPORT_AUTHOR_DELETED = "author_deleted"
+
+const MODERATION_HIDE = "hide"
+const MODERATION_UNHIDE = "unhide"
+const MODERATION_DELETE = "delete"
+const MODERATION_DISMISS = "dismiss"
+
+var AllModerationActions = []string{MODERATION_HIDE, MODERATION_UNHIDE, MODERATION_DELETE, MODERATION_DISMISS}
+
+var ErrAlreadyReported = conflict("You've already reported this chirp")
+
+type Report struct {
+	Id int `json:"id"`
+	// ReporterId is 0 for reports filed by chirp moderation
+	ReporterId int        `json:"reporter_id"`
+	ChirpId    int        `json:"chirp_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy int        `json:"resolved_by,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+}
+
+// ModerationAction is one entry in the audit trail of what moderators have done
+type ModerationAction struct {
+	Id           int       `json:"id"`
+	ModeratorId  int       `json:"moderator_id"`
+	Action       string    `json:"action"`
+	ChirpId      int       `json:"chirp_id,omitempty"`
+	TargetUserId int       `json:"target_user_id,omitempty"`
+	ReportIds    []int     `json:"report_ids,omitempty"`
+	Note         string    `json:"note,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// QueuedChirp is a chirp waiting for a moderator, along with its open reports
+type QueuedChirp struct {
+	Chirp   Chirp    `json:"chirp"`
+	Reports []Report `json:"reports"`
+}
+
+func ValidReportReason(reason string) bool {
+	for _, known := range AllReportReasons {
+		if reason == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidModerationAction(action string) bool {
+	for _, known := range AllModerationActions {
+		if action == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReportChirp files a report, as long as the reporter doesn't already have one open for the chirp
+func (db *DB) ReportChirp(reporterId int, chirpId int, reason string, details string) (Report, error) {
+	if !ValidReportReason(reason) && reason != REPORT_AUTOMOD {
+		return Report{}, invalid("Unknown report reason: " + reason)
+	}
+
+	report := Report{}
+
+	err := db.update(func(currentDB *DBStructure) error {
+		chirp, ok := currentDB.Chirps[chirpId]
+		if !ok {
+			return notFound("Could not find chirp")
+		}
+
+		if reporterId != 0 && chirp.AuthorId == reporterId {
+			return invalid("You can't report your own chirp")
+		}
+
+		for _, report := range currentDB.Reports {
+			if report.ChirpId == chirpId && report.ReporterId == reporterId && report.Status == REPORT_OPEN {
+				return ErrAlreadyReported
+			}
+		}
+
+		nextId := nextCountedId(&currentDB.LastReportId, currentDB.Reports)
+		report = Report{
+			Id:         nextId,
+			ReporterId: reporterId,
+			ChirpId:    chirpId,
+			Reason:     reason,
+			Details:    details,
+			Status:     REPORT_OPEN,
+			CreatedAt:  time.Now().UTC(),
+		}
+
+		currentDB.Reports[nextId] = report
+
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
+}
+
+// GetModerationQueue returns every chirp with open reports, the most reported first
+func (db *DB) GetModerationQueue() ([]QueuedChirp, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []QueuedChirp{}, err
+	}
+
+	byChirp := map[int][]Report{}
+	for _, report := range currentDB.Reports {
+		if report.Status == REPORT_OPEN {
+			byChirp[report.ChirpId] = append(byChirp[report.ChirpId], report)
+		}
+	}
+
+	queue := []QueuedChirp{}
+	for chirpId, reports := range byChirp {
+		chirp, ok := currentDB.Chirps[chirpId]
+		if !ok {
+			continue
+		}
+
+		sort.Slice(reports, func(i, j int) bool {
+			return reports[i].Id < reports[j].Id
+		})
+		queue = append(queue, QueuedChirp{Chirp: chirp, Reports: reports})
+	}
+
+	sort.Slice(queue, func(i, j int) bool {
+		if len(queue[i].Reports) != len(queue[j].Reports) {
+			return len(queue[i].Reports) > len(queue[j].Reports)
+		}
+		return queue[i].Reports[0].Id < queue[j].Reports[0].Id
+	})
+
+	return queue, nil
+}
+
+// ModerateChirp applies a moderator's decision to a chirp, resolving its open reports
+// and recording what was done in the audit trail
+func (db *DB) ModerateChirp(moderatorId int, chirpId int, action string, note string) (ModerationAction, error) {
+	if !ValidModerationAction(action) {
+		return ModerationAction{}, invalid("Unknown moderation action: " + action)
+	}
+
+	entry := ModerationAction{}
+
+	err := db.update(func(currentDB *DBStructure) error {
+		chirp, ok := currentDB.Chirps[chirpId]
+		if !ok {
+			return notFound("Could not find chirp")
+		}
+
+		switch action {
+		case MODERATION_HIDE:
+			chirp.Hidden = true
+			currentDB.Chirps[chirpId] = chirp
+		case MODERATION_UNHIDE:
+			chirp.Hidden = false
+			currentDB.Chirps[chirpId] = chirp
+		case MODERATION_DELETE:
+			delete(currentDB.Chirps, chirpId)
+		}
+
+		now := time.Now().UTC()
+		resolved := currentDB.resolveReports(chirpId, moderatorId, action, now)
+
+		entry = currentDB.recordModerationAction(ModerationAction{
+			ModeratorId:  moderatorId,
+			Action:       action,
+			ChirpId:      chirpId,
+			TargetUserId: chirp.AuthorId,
+			ReportIds:    resolved,
+			Note:         note,
+			CreatedAt:    now,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return ModerationAction{}, err
+	}
+
+	return entry, nil
+}
+
+// resolveReports closes the open reports on a chirp, returning their ids
+func (dbStructure *DBStructure) resolveReports(chirpId int, resolvedBy int, resolution string, now time.Time) []int {
+	resolved := []int{}
+	for id, report := range dbStructure.Reports {
+		if report.ChirpId != chirpId || report.Status != REPORT_OPEN {
+			continue
+		}
+
+		report.Status = REPORT_RESOLVED
+		report.ResolvedAt = &now
+		report.ResolvedBy = resolvedBy
+		report.Resolution = resolution
+		dbStructure.Reports[id] = report
+		resolved = append(resolved, id)
+	}
+	sort.Ints(resolved)
+
+	return resolved
+}
+
+// recordModerationAction adds an entry to the audit trail, for the caller to write out
+func (dbStructure *DBStructure) recordModerationAction(entry ModerationAction) ModerationAction {
+	entry.Id = nextCountedId(&dbStructure.LastModerationActionId, dbStructure.ModerationLog)
+	dbStructure.ModerationLog[entry.Id] = entry
+
+	return entry
+}
+
+// GetModerationLog returns the audit trail newest first, optionally only for one moderator or target user
+func (db *DB) GetModerationLog(moderatorId int, targetUserId int) ([]ModerationAction, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []ModerationAction{}, err
+	}
+
+	entries := []ModerationAction{}
+	for _, entry := range currentDB.ModerationLog {
+		if moderatorId != 0 && entry.ModeratorId != moderatorId {
+			continue
+		}
+		if targetUserId != 0 && entry.TargetUserId != targetUserId {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Id > entries[j].Id
+	})
+
+	return entries, nil
+}
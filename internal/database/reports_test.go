@@ -0,0 +1,242 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestChirp(t *testing.T, db *DB, authorId int, body string) Chirp {
+	t.Helper()
+
+	chirp, err := db.CreateChirp(body, authorId, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chirp
+}
+
+func TestChirpIdsAreNeverReused(t *testing.T) {
+	db := newTestDB(t)
+	author := newTestUser(t, db, "author@example.com")
+
+	newTestChirp(t, db, author.Id, "first")
+	second := newTestChirp(t, db, author.Id, "second")
+
+	err := db.DeleteChirp(author.Id, second.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	third := newTestChirp(t, db, author.Id, "third")
+	if third.Id == second.Id {
+		t.Errorf("a new chirp took the id %d of a deleted one", third.Id)
+	}
+
+	// files from before the counter was saved carry on from the highest chirp
+	err = db.update(func(currentDB *DBStructure) error {
+		currentDB.LastChirpId = 0
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fourth := newTestChirp(t, db, author.Id, "fourth")
+	if fourth.Id != third.Id+1 {
+		t.Errorf("chirp after a legacy file got id %d, want %d", fourth.Id, third.Id+1)
+	}
+}
+
+func TestReportIdsAreNeverReused(t *testing.T) {
+	db := newTestDB(t)
+	author := newTestUser(t, db, "author@example.com")
+	reporter := newTestUser(t, db, "reporter@example.com")
+	moderator := newTestUser(t, db, "moderator@example.com")
+
+	chirp := newTestChirp(t, db, author.Id, "reported")
+	report, err := db.ReportChirp(reporter.Id, chirp.Id, REPORT_SPAM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	action, err := db.ModerateChirp(moderator.Id, chirp.Id, MODERATION_HIDE, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// entries removed by hand still hold on to their ids
+	err = db.update(func(currentDB *DBStructure) error {
+		delete(currentDB.Reports, report.Id)
+		delete(currentDB.ModerationLog, action.Id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := db.ReportChirp(reporter.Id, chirp.Id, REPORT_SPAM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id == report.Id {
+		t.Errorf("a new report took the id %d of a removed one", again.Id)
+	}
+
+	nextAction, err := db.ModerateChirp(moderator.Id, chirp.Id, MODERATION_UNHIDE, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextAction.Id == action.Id {
+		t.Errorf("a new moderation log entry took the id %d of a removed one", nextAction.Id)
+	}
+}
+
+func TestAuthorDeleteResolvesReports(t *testing.T) {
+	db := newTestDB(t)
+	author := newTestUser(t, db, "author@example.com")
+	reporter := newTestUser(t, db, "reporter@example.com")
+
+	chirp := newTestChirp(t, db, author.Id, "reported")
+	report, err := db.ReportChirp(reporter.Id, chirp.Id, REPORT_SPAM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.DeleteChirp(author.Id, chirp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentDB, err := db.loadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved := currentDB.Reports[report.Id]
+	if resolved.Status != REPORT_RESOLVED || resolved.Resolution != REPORT_AUTHOR_DELETED || resolved.ResolvedBy != author.Id {
+		t.Errorf("report after the author deleted the chirp = %+v, want it resolved as author_deleted", resolved)
+	}
+
+	// the next chirp doesn't pick up the deleted one's reports
+	newTestChirp(t, db, author.Id, "innocent")
+	queue, err := db.GetModerationQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 0 {
+		t.Errorf("moderation queue = %+v, want it empty", queue)
+	}
+}
+
+func TestReportChirp(t *testing.T) {
+	db := newTestDB(t)
+	author := newTestUser(t, db, "author@example.com")
+	reporter := newTestUser(t, db, "reporter@example.com")
+	chirp := newTestChirp(t, db, author.Id, "reported")
+
+	_, err := db.ReportChirp(reporter.Id, chirp.Id, "boring", "")
+	if err == nil {
+		t.Error("reporting for an unknown reason succeeded")
+	}
+
+	_, err = db.ReportChirp(author.Id, chirp.Id, REPORT_SPAM, "")
+	if err == nil {
+		t.Error("reporting your own chirp succeeded")
+	}
+
+	_, err = db.ReportChirp(reporter.Id, 999, REPORT_SPAM, "")
+	if err == nil {
+		t.Error("reporting a missing chirp succeeded")
+	}
+
+	_, err = db.ReportChirp(reporter.Id, chirp.Id, REPORT_SPAM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.ReportChirp(reporter.Id, chirp.Id, REPORT_HATE, "")
+	if !errors.Is(err, ErrAlreadyReported) {
+		t.Errorf("reporting twice = %v, want ErrAlreadyReported", err)
+	}
+
+	// automod reports come from nobody, and can be on anyone's chirp
+	_, err = db.ReportChirp(0, chirp.Id, REPORT_AUTOMOD, "links")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestModerateChirp(t *testing.T) {
+	db := newTestDB(t)
+	author := newTestUser(t, db, "author@example.com")
+	moderator := newTestUser(t, db, "moderator@example.com")
+	reporters := []User{newTestUser(t, db, "a@example.com"), newTestUser(t, db, "b@example.com")}
+
+	once := newTestChirp(t, db, author.Id, "reported once")
+	twice := newTestChirp(t, db, author.Id, "reported twice")
+
+	for i, reporter := range reporters {
+		_, err := db.ReportChirp(reporter.Id, twice.Id, REPORT_SPAM, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			_, err = db.ReportChirp(reporter.Id, once.Id, REPORT_SPAM, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	queue, err := db.GetModerationQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 2 || queue[0].Chirp.Id != twice.Id || len(queue[0].Reports) != 2 {
+		t.Fatalf("moderation queue = %+v, want the most reported chirp first", queue)
+	}
+
+	entry, err := db.ModerateChirp(moderator.Id, twice.Id, MODERATION_HIDE, "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.TargetUserId != author.Id || len(entry.ReportIds) != 2 || entry.Note != "spam" {
+		t.Errorf("audit entry = %+v, want both reports resolved against the author", entry)
+	}
+
+	hidden, _, err := db.GetChirp(twice.Id)
+	if err != nil || !hidden.Hidden {
+		t.Errorf("chirp after hiding = %+v, %v, want it hidden", hidden, err)
+	}
+
+	_, err = db.ModerateChirp(moderator.Id, once.Id, MODERATION_DELETE, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, exists, err := db.GetChirp(once.Id)
+	if err != nil || exists {
+		t.Errorf("chirp after deleting = %v, %v, want it gone", exists, err)
+	}
+
+	queue, err = db.GetModerationQueue()
+	if err != nil || len(queue) != 0 {
+		t.Errorf("moderation queue after reviewing = %+v, %v, want it empty", queue, err)
+	}
+
+	_, err = db.ModerateChirp(moderator.Id, twice.Id, "ban", "")
+	if err == nil {
+		t.Error("an unknown moderation action succeeded")
+	}
+
+	entries, err := db.GetModerationLog(moderator.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != MODERATION_DELETE || entries[1].Action != MODERATION_HIDE {
+		t.Errorf("moderation log = %+v, want the delete then the hide", entries)
+	}
+
+	entries, err = db.GetModerationLog(0, reporters[0].Id)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("moderation log for a user nobody acted on = %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+const RESTRICTION_SUSPENDED = "suspended"
+const RESTRICTION_BANNED = "banned"
+const RESTRICTION_SHADOW_BANNED = "shadow_banned"
+
+var AllRestrictions = []string{RESTRICTION_SUSPENDED, RESTRICTION_BANNED, RESTRICTION_SHADOW_BANNED}
+
+// MODERATION_LIFT_RESTRICTION is the audit trail action for lifting a restriction,
+// the others are recorded under the restriction's own status
+const MODERATION_LIFT_RESTRICTION = "lift_restriction"
+
+// Restriction is an admin's limit on what a user can do
+// Suspensions end at Until, bans and shadow bans last until they're lifted
+type Restriction struct {
+	Status    string     `json:"status"`
+	Until     *time.Time `json:"until,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	By        int        `json:"by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func ValidRestriction(status string) bool {
+	for _, known := range AllRestrictions {
+		if status == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ActiveRestriction returns the user's restriction if it's still in effect
+func (user AuthenticatedUser) ActiveRestriction() *Restriction {
+	restriction := user.Restriction
+	if restriction == nil {
+		return nil
+	}
+
+	if restriction.Status == RESTRICTION_SUSPENDED && restriction.Until != nil && !time.Now().Before(*restriction.Until) {
+		return nil
+	}
+
+	return restriction
+}
+
+// ShadowBanned users can keep using Chirpy, but nobody else sees their chirps
+func (user AuthenticatedUser) ShadowBanned() bool {
+	restriction := user.ActiveRestriction()
+
+	return restriction != nil && restriction.Status == RESTRICTION_SHADOW_BANNED
+}
+
+// RestrictedError means a suspended or banned user tried to log in or use a token
+type RestrictedError struct {
+	Restriction Restriction
+}
+
+func (e *RestrictedError) Error() string {
+	if e.Restriction.Status == RESTRICTION_SUSPENDED && e.Restriction.Until != nil {
+		return fmt.Sprintf("Your account is suspended until %s", e.Restriction.Until.Format(time.RFC3339))
+	}
+
+	return "Your account has been banned"
+}
+
+func (e *RestrictedError) Unwrap() error {
+	return ErrForbidden
+}
+
+// checkRestricted returns a RestrictedError if the user is suspended or banned
+// Shadow bans aren't reported, so shadow banned users don't find out about them
+func checkRestricted(user AuthenticatedUser) error {
+	restriction := user.ActiveRestriction()
+	if restriction == nil || restriction.Status == RESTRICTION_SHADOW_BANNED {
+		return nil
+	}
+
+	return &RestrictedError{Restriction: *restriction}
+}
+
+// CheckRestricted is checkRestricted for callers outside the database
+func (user AuthenticatedUser) CheckRestricted() error {
+	return checkRestricted(user)
+}
+
+// RestrictUser suspends (until the given time), bans or shadow bans a user, recording it in the audit trail
+func (db *DB) RestrictUser(adminId int, userId int, status string, until *time.Time, reason string) (AuthenticatedUser, error) {
+	if !ValidRestriction(status) {
+		return AuthenticatedUser{}, invalid("Unknown restriction: " + status)
+	}
+
+	if status == RESTRICTION_SUSPENDED && (until == nil || until.Before(time.Now())) {
+		return AuthenticatedUser{}, invalid("Suspensions need an end in the future")
+	}
+
+	if status != RESTRICTION_SUSPENDED {
+		until = nil
+	}
+
+	user := AuthenticatedUser{}
+
+	err := db.update(func(currentDB *DBStructure) error {
+		var ok bool
+		user, ok = currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		now := time.Now().UTC()
+		user.Restriction = &Restriction{
+			Status:    status,
+			Until:     until,
+			Reason:    reason,
+			By:        adminId,
+			CreatedAt: now,
+		}
+		currentDB.Users[userId] = user
+
+		currentDB.recordModerationAction(ModerationAction{
+			ModeratorId:  adminId,
+			Action:       status,
+			TargetUserId: userId,
+			Note:         reason,
+			CreatedAt:    now,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return AuthenticatedUser{}, err
+	}
+
+	return user, nil
+}
+
+// LiftRestriction removes whatever restriction a user has, recording it in the audit trail
+func (db *DB) LiftRestriction(adminId int, userId int, note string) error {
+	return db.update(func(currentDB *DBStructure) error {
+		user, ok := currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		user.Restriction = nil
+		currentDB.Users[userId] = user
+
+		currentDB.recordModerationAction(ModerationAction{
+			ModeratorId:  adminId,
+			Action:       MODERATION_LIFT_RESTRICTION,
+			TargetUserId: userId,
+			Note:         note,
+			CreatedAt:    time.Now().UTC(),
+		})
+
+		return nil
+	})
+}
+
+// GetShadowBannedUsers returns the ids of every user who is currently shadow banned
+func (db *DB) GetShadowBannedUsers() (map[int]bool, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return map[int]bool{}, err
+	}
+
+	shadowBanned := map[int]bool{}
+	for id, user := range currentDB.Users {
+		if user.ShadowBanned() {
+			shadowBanned[id] = true
+		}
+	}
+
+	return shadowBanned, nil
+}
@@ -0,0 +1,54 @@
+package database
+
+const ROLE_USER = "user"
+const ROLE_MODERATOR = "moderator"
+const ROLE_ADMIN = "admin"
+
+var AllRoles = []string{ROLE_USER, ROLE_MODERATOR, ROLE_ADMIN}
+
+func ValidRole(role string) bool {
+	for _, known := range AllRoles {
+		if role == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RoleOrDefault treats users from before roles existed as normal users
+func (user AuthenticatedUser) RoleOrDefault() string {
+	if user.Role == "" {
+		return ROLE_USER
+	}
+
+	return user.Role
+}
+
+// SetUserRole changes a user's role, which applies to their requests straight away
+// and shows up in their tokens from their next login or refresh
+func (db *DB) SetUserRole(userId int, role string) (AuthenticatedUser, error) {
+	if !ValidRole(role) {
+		return AuthenticatedUser{}, invalid("Unknown role: " + role)
+	}
+
+	user := AuthenticatedUser{}
+
+	err := db.update(func(currentDB *DBStructure) error {
+		var ok bool
+		user, ok = currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		user.Role = role
+		currentDB.Users[userId] = user
+
+		return nil
+	})
+	if err != nil {
+		return AuthenticatedUser{}, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+const SUBSCRIPTION_ACTIVE = "active"
+const SUBSCRIPTION_PAST_DUE = "past_due"
+const SUBSCRIPTION_CANCELED = "canceled"
+const SUBSCRIPTION_REFUNDED = "refunded"
+const SUBSCRIPTION_EXPIRED = "expired"
+
+// SUBSCRIPTION_PERIOD is how long a payment or renewal keeps Chirpy Red going for
+const SUBSCRIPTION_PERIOD = 30 * 24 * time.Hour
+
+// PAYMENT_GRACE_PERIOD is how long Chirpy Red keeps working after a failed payment, at most
+const PAYMENT_GRACE_PERIOD = 3 * 24 * time.Hour
+
+// Subscription is a user's Chirpy Red subscription
+type Subscription struct {
+	Status    string     `json:"status"`
+	StartedAt time.Time  `json:"started_at"`
+	RenewedAt *time.Time `json:"renewed_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// grantsAccess is true while the subscription is paid up, or still inside its grace period
+func (sub Subscription) grantsAccess(now time.Time) bool {
+	if sub.Status != SUBSCRIPTION_ACTIVE && sub.Status != SUBSCRIPTION_PAST_DUE {
+		return false
+	}
+
+	return now.Before(sub.ExpiresAt)
+}
+
+// IsChirpyRed is worked out from the subscription every time, so lapsed subscriptions stop counting
+// the moment they expire rather than whenever something gets around to updating them
+func (user AuthenticatedUser) IsChirpyRed() bool {
+	return user.Subscription != nil && user.Subscription.grantsAccess(time.Now())
+}
+
+// updateSubscription loads a user, lets change modify their subscription and saves it
+func (db *DB) updateSubscription(userId int, change func(sub *Subscription, now time.Time)) error {
+	return db.update(func(currentDB *DBStructure) error {
+		user, ok := currentDB.Users[userId]
+		if !ok {
+			return notFound("Could not find user")
+		}
+
+		now := time.Now().UTC()
+
+		sub := Subscription{}
+		if user.Subscription != nil {
+			sub = *user.Subscription
+		}
+
+		change(&sub, now)
+		sub.UpdatedAt = now
+		user.Subscription = &sub
+
+		currentDB.Users[userId] = user
+
+		return nil
+	})
+}
+
+// UpgradeUser starts a Chirpy Red subscription, or renews it if it's still running
+func (db *DB) UpgradeUser(userId int) error {
+	return db.updateSubscription(userId, func(sub *Subscription, now time.Time) {
+		if sub.grantsAccess(now) {
+			renew(sub, now)
+			return
+		}
+
+		*sub = Subscription{
+			Status:    SUBSCRIPTION_ACTIVE,
+			StartedAt: now,
+			ExpiresAt: now.Add(SUBSCRIPTION_PERIOD),
+		}
+	})
+}
+
+// RenewSubscription extends a subscription by another period from whenever it would have run out
+func (db *DB) RenewSubscription(userId int) error {
+	return db.updateSubscription(userId, func(sub *Subscription, now time.Time) {
+		if sub.StartedAt.IsZero() {
+			sub.StartedAt = now
+		}
+
+		renew(sub, now)
+	})
+}
+
+func renew(sub *Subscription, now time.Time) {
+	from := sub.ExpiresAt
+	if from.Before(now) {
+		from = now
+	}
+
+	sub.Status = SUBSCRIPTION_ACTIVE
+	sub.RenewedAt = &now
+	sub.ExpiresAt = from.Add(SUBSCRIPTION_PERIOD)
+}
+
+// DowngradeUser cancels Chirpy Red straight away
+func (db *DB) DowngradeUser(userId int) error {
+	return db.updateSubscription(userId, func(sub *Subscription, now time.Time) {
+		sub.Status = SUBSCRIPTION_CANCELED
+		sub.ExpiresAt = now
+	})
+}
+
+// RefundSubscription ends Chirpy Red straight away because the payment was given back
+func (db *DB) RefundSubscription(userId int) error {
+	return db.updateSubscription(userId, func(sub *Subscription, now time.Time) {
+		sub.Status = SUBSCRIPTION_REFUNDED
+		sub.ExpiresAt = now
+	})
+}
+
+// SubscriptionPaymentFailed keeps Chirpy Red going for a grace period while the payment is retried
+func (db *DB) SubscriptionPaymentFailed(userId int) error {
+	return db.updateSubscription(userId, func(sub *Subscription, now time.Time) {
+		if !sub.grantsAccess(now) {
+			return
+		}
+
+		sub.Status = SUBSCRIPTION_PAST_DUE
+		graceEnds := now.Add(PAYMENT_GRACE_PERIOD)
+		if graceEnds.Before(sub.ExpiresAt) {
+			sub.ExpiresAt = graceEnds
+		}
+	})
+}
+
+// errNothingExpired stops ExpireSubscriptions writing when there was nothing to change
+var errNothingExpired = errors.New("nothing expired")
+
+// ExpireSubscriptions marks subscriptions that have run out as expired, returning how many it changed
+func (db *DB) ExpireSubscriptions() (int, error) {
+	expired := 0
+
+	err := db.update(func(currentDB *DBStructure) error {
+		now := time.Now().UTC()
+
+		for id, user := range currentDB.Users {
+			sub := user.Subscription
+			if sub == nil || sub.grantsAccess(now) {
+				continue
+			}
+
+			if sub.Status != SUBSCRIPTION_ACTIVE && sub.Status != SUBSCRIPTION_PAST_DUE {
+				continue
+			}
+
+			sub.Status = SUBSCRIPTION_EXPIRED
+			sub.UpdatedAt = now
+			currentDB.Users[id] = user
+			expired++
+		}
+
+		if expired == 0 {
+			return errNothingExpired
+		}
+
+		return nil
+	})
+	if errors.Is(err, errNothingExpired) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
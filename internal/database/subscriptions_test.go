@@ -0,0 +1,181 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// subscriptionOf reads the user straight from the database
+func subscriptionOf(t *testing.T, db *DB, userId int) (Subscription, bool) {
+	t.Helper()
+
+	currentDB, err := db.loadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := currentDB.Users[userId]
+	if user.Subscription == nil {
+		return Subscription{}, user.IsChirpyRed()
+	}
+
+	return *user.Subscription, user.IsChirpyRed()
+}
+
+func TestUpgradeAndRenew(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "red@example.com")
+
+	err := db.UpgradeUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, isRed := subscriptionOf(t, db, user.Id)
+	if !isRed || first.Status != SUBSCRIPTION_ACTIVE {
+		t.Fatalf("after upgrading = %+v, red %v, want active", first, isRed)
+	}
+	if until := time.Until(first.ExpiresAt); until < SUBSCRIPTION_PERIOD-time.Minute || until > SUBSCRIPTION_PERIOD {
+		t.Errorf("a new subscription expires in %v, want %v", until, SUBSCRIPTION_PERIOD)
+	}
+
+	// upgrading while it's still running adds another period on the end
+	err = db.UpgradeUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, _ := subscriptionOf(t, db, user.Id)
+	if renewed.ExpiresAt.Sub(first.ExpiresAt) != SUBSCRIPTION_PERIOD {
+		t.Errorf("renewing moved the expiry by %v, want %v", renewed.ExpiresAt.Sub(first.ExpiresAt), SUBSCRIPTION_PERIOD)
+	}
+	if !renewed.StartedAt.Equal(first.StartedAt) || renewed.RenewedAt == nil {
+		t.Errorf("renewed subscription = %+v, want the same start and a renewal time", renewed)
+	}
+}
+
+func TestPaymentFailedGracePeriod(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "red@example.com")
+	other := newTestUser(t, db, "free@example.com")
+
+	err := db.UpgradeUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.SubscriptionPaymentFailed(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, isRed := subscriptionOf(t, db, user.Id)
+	if !isRed || sub.Status != SUBSCRIPTION_PAST_DUE {
+		t.Errorf("after a failed payment = %+v, red %v, want past_due and still red", sub, isRed)
+	}
+	if time.Until(sub.ExpiresAt) > PAYMENT_GRACE_PERIOD {
+		t.Errorf("grace period runs for %v, want at most %v", time.Until(sub.ExpiresAt), PAYMENT_GRACE_PERIOD)
+	}
+
+	// a failed payment for someone without Chirpy Red doesn't give them any
+	err = db.SubscriptionPaymentFailed(other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub, isRed := subscriptionOf(t, db, other.Id); isRed || sub.Status == SUBSCRIPTION_PAST_DUE {
+		t.Errorf("failed payment without a subscription = %+v, red %v", sub, isRed)
+	}
+}
+
+func TestCancelAndRefundEndAccess(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "red@example.com")
+
+	for _, end := range []struct {
+		status string
+		apply  func(int) error
+	}{
+		{SUBSCRIPTION_CANCELED, db.DowngradeUser},
+		{SUBSCRIPTION_REFUNDED, db.RefundSubscription},
+	} {
+		err := db.UpgradeUser(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		started, _ := subscriptionOf(t, db, user.Id)
+
+		err = end.apply(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sub, isRed := subscriptionOf(t, db, user.Id)
+		if isRed || sub.Status != end.status {
+			t.Errorf("after ending = %+v, red %v, want %s", sub, isRed, end.status)
+		}
+
+		// upgrading again starts a fresh subscription
+		time.Sleep(time.Millisecond)
+		err = db.UpgradeUser(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sub, isRed = subscriptionOf(t, db, user.Id)
+		if !isRed || !sub.StartedAt.After(started.StartedAt) {
+			t.Errorf("upgrading after %s = %+v, red %v, want a new subscription", end.status, sub, isRed)
+		}
+
+		err = end.apply(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.UpgradeUser(999)
+	if err == nil {
+		t.Error("upgrading a user that doesn't exist succeeded")
+	}
+}
+
+func TestExpireSubscriptions(t *testing.T) {
+	db := newTestDB(t)
+	lapsed := newTestUser(t, db, "lapsed@example.com")
+	current := newTestUser(t, db, "current@example.com")
+
+	for _, user := range []User{lapsed, current} {
+		err := db.UpgradeUser(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.update(func(currentDB *DBStructure) error {
+		currentDB.Users[lapsed.Id].Subscription.ExpiresAt = time.Now().Add(-time.Minute)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, isRed := subscriptionOf(t, db, lapsed.Id); isRed {
+		t.Error("a subscription past its expiry still counts as Chirpy Red")
+	}
+
+	expired, err := db.ExpireSubscriptions()
+	if err != nil || expired != 1 {
+		t.Fatalf("ExpireSubscriptions = %d, %v, want 1", expired, err)
+	}
+
+	if sub, _ := subscriptionOf(t, db, lapsed.Id); sub.Status != SUBSCRIPTION_EXPIRED {
+		t.Errorf("lapsed subscription status = %s, want expired", sub.Status)
+	}
+	if sub, isRed := subscriptionOf(t, db, current.Id); !isRed || sub.Status != SUBSCRIPTION_ACTIVE {
+		t.Errorf("current subscription = %+v, want it left active", sub)
+	}
+
+	expired, err = db.ExpireSubscriptions()
+	if err != nil || expired != 0 {
+		t.Errorf("expiring again = %d, %v, want 0", expired, err)
+	}
+}
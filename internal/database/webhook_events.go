@@ -0,0 +1,149 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+const WEBHOOK_RECEIVED = "received"
+const WEBHOOK_PROCESSED = "processed"
+const WEBHOOK_IGNORED = "ignored"
+const WEBHOOK_FAILED = "failed"
+
+// WEBHOOK_CLAIM_TIMEOUT is how long an event can stay received before another delivery of it
+// takes over, in case whatever was processing it died part way through
+const WEBHOOK_CLAIM_TIMEOUT = 5 * time.Minute
+
+// ErrWebhookInFlight means another delivery of the same event is being processed right now
+var ErrWebhookInFlight = conflict("This event is already being processed")
+
+// WebhookEvent is a webhook we have received, kept so retries can be spotted and events replayed
+type WebhookEvent struct {
+	Id          string          `json:"id"`
+	Source      string          `json:"source"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ClaimedAt   time.Time       `json:"claimed_at"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int             `json:"attempts"`
+}
+
+// errAlreadyRecorded stops RecordWebhookEvent writing an event it has already finished with
+var errAlreadyRecorded = errors.New("already recorded")
+
+// RecordWebhookEvent saves an incoming event and claims it for processing, reporting whether the caller should process it
+// Events we've already handled (or deliberately ignored) are not processed again, but failed ones are retried.
+// The claim is made under the write lock, so while one delivery is processing an event any others get ErrWebhookInFlight
+func (db *DB) RecordWebhookEvent(source string, id string, eventType string, payload []byte) (WebhookEvent, bool, error) {
+	event := WebhookEvent{}
+
+	err := db.update(func(currentDB *DBStructure) error {
+		now := time.Now().UTC()
+		existing, exists := currentDB.WebhookEvents[id]
+
+		switch {
+		case !exists:
+			event = WebhookEvent{
+				Id:         id,
+				Source:     source,
+				Type:       eventType,
+				Payload:    json.RawMessage(payload),
+				ReceivedAt: now,
+			}
+		case existing.Status == WEBHOOK_FAILED:
+			event = existing
+		case existing.Status == WEBHOOK_RECEIVED && now.Sub(existing.ClaimedAt) < WEBHOOK_CLAIM_TIMEOUT:
+			event = existing
+			return ErrWebhookInFlight
+		case existing.Status == WEBHOOK_RECEIVED:
+			// whatever claimed it gave up without finishing
+			event = existing
+		default:
+			event = existing
+			return errAlreadyRecorded
+		}
+
+		event.Status = WEBHOOK_RECEIVED
+		event.ClaimedAt = now
+		currentDB.WebhookEvents[id] = event
+
+		return nil
+	})
+	if errors.Is(err, errAlreadyRecorded) {
+		return event, false, nil
+	}
+	if err != nil {
+		return WebhookEvent{}, false, err
+	}
+
+	return event, true, nil
+}
+
+// FinishWebhookEvent records the outcome of an attempt at processing an event
+func (db *DB) FinishWebhookEvent(id string, status string, processingErr error) (WebhookEvent, error) {
+	event := WebhookEvent{}
+
+	err := db.update(func(currentDB *DBStructure) error {
+		var ok bool
+		event, ok = currentDB.WebhookEvents[id]
+		if !ok {
+			return notFound("Could not find webhook event")
+		}
+
+		now := time.Now().UTC()
+
+		event.Status = status
+		event.ProcessedAt = &now
+		event.Attempts++
+		event.Error = ""
+		if processingErr != nil {
+			event.Error = processingErr.Error()
+		}
+
+		currentDB.WebhookEvents[id] = event
+
+		return nil
+	})
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	return event, nil
+}
+
+// GetWebhookEvents returns received events, newest first, optionally only ones with the given status
+func (db *DB) GetWebhookEvents(status string) ([]WebhookEvent, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return []WebhookEvent{}, err
+	}
+
+	events := []WebhookEvent{}
+	for _, event := range currentDB.WebhookEvents {
+		if status == "" || event.Status == status {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ReceivedAt.After(events[j].ReceivedAt)
+	})
+
+	return events, nil
+}
+
+func (db *DB) GetWebhookEvent(id string) (WebhookEvent, bool, error) {
+	currentDB, err := db.loadDB()
+	if err != nil {
+		return WebhookEvent{}, false, err
+	}
+
+	event, ok := currentDB.WebhookEvents[id]
+
+	return event, ok, nil
+}
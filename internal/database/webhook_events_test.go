@@ -0,0 +1,138 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecordWebhookEventSkipsHandledEvents(t *testing.T) {
+	db := newTestDB(t)
+	payload := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+
+	_, needsProcessing, err := db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", payload)
+	if err != nil || !needsProcessing {
+		t.Fatalf("recording a new event = %v, %v, want it processed", needsProcessing, err)
+	}
+
+	// a retry that arrives before the first attempt finished waits for it
+	_, needsProcessing, err = db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", payload)
+	if !errors.Is(err, ErrWebhookInFlight) || needsProcessing {
+		t.Errorf("recording an unfinished event again = %v, %v, want ErrWebhookInFlight", needsProcessing, err)
+	}
+
+	event, err := db.FinishWebhookEvent("evt_1", WEBHOOK_PROCESSED, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Attempts != 1 || event.ProcessedAt == nil {
+		t.Errorf("finished event = %+v, want one attempt with a processed time", event)
+	}
+
+	_, needsProcessing, err = db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", payload)
+	if err != nil || needsProcessing {
+		t.Errorf("recording a processed event again = %v, %v, want it skipped", needsProcessing, err)
+	}
+}
+
+func TestRecordWebhookEventRetriesFailedEvents(t *testing.T) {
+	db := newTestDB(t)
+	payload := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+
+	_, _, err := db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := db.FinishWebhookEvent("evt_1", WEBHOOK_FAILED, errors.New("could not find user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Error != "could not find user" {
+		t.Errorf("failed event error = %q", event.Error)
+	}
+
+	_, needsProcessing, err := db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", payload)
+	if err != nil || !needsProcessing {
+		t.Fatalf("recording a failed event again = %v, %v, want it retried", needsProcessing, err)
+	}
+
+	event, err = db.FinishWebhookEvent("evt_1", WEBHOOK_PROCESSED, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Attempts != 2 || event.Error != "" {
+		t.Errorf("retried event = %+v, want two attempts and the error cleared", event)
+	}
+}
+
+func TestRecordWebhookEventTakesOverAbandonedClaims(t *testing.T) {
+	db := newTestDB(t)
+	payload := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+
+	_, _, err := db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.update(func(currentDB *DBStructure) error {
+		event := currentDB.WebhookEvents["evt_1"]
+		event.ClaimedAt = event.ClaimedAt.Add(-WEBHOOK_CLAIM_TIMEOUT)
+		currentDB.WebhookEvents["evt_1"] = event
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, needsProcessing, err := db.RecordWebhookEvent("polka", "evt_1", "user.upgraded", payload)
+	if err != nil || !needsProcessing {
+		t.Errorf("recording an event whose claim timed out = %v, %v, want it processed", needsProcessing, err)
+	}
+}
+
+func TestGetWebhookEvents(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, id := range []string{"evt_1", "evt_2", "evt_3"} {
+		_, _, err := db.RecordWebhookEvent("polka", id, "user.upgraded", []byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := db.FinishWebhookEvent("evt_2", WEBHOOK_IGNORED, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := db.GetWebhookEvents("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("got %d events, want 3", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].ReceivedAt.After(all[i-1].ReceivedAt) {
+			t.Errorf("events aren't newest first: %v after %v", all[i].ReceivedAt, all[i-1].ReceivedAt)
+		}
+	}
+
+	ignored, err := db.GetWebhookEvents(WEBHOOK_IGNORED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ignored) != 1 || ignored[0].Id != "evt_2" {
+		t.Errorf("ignored events = %+v, want just evt_2", ignored)
+	}
+
+	_, exists, err := db.GetWebhookEvent("evt_missing")
+	if err != nil || exists {
+		t.Errorf("GetWebhookEvent for a missing event = %v, %v", exists, err)
+	}
+
+	_, err = db.FinishWebhookEvent("evt_missing", WEBHOOK_PROCESSED, nil)
+	if err == nil {
+		t.Error("finishing a missing event succeeded")
+	}
+}
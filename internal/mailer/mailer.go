@@ -0,0 +1,55 @@
+// Package mailer sends emails to users
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is anything that can deliver a message to a user
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// OutboxMailer writes each message to its own file in a local directory
+// instead of sending it, so the server works without an SMTP server
+type OutboxMailer struct {
+	Dir string
+}
+
+// NewOutboxMailer creates the outbox directory if it doesn't exist
+func NewOutboxMailer(dir string) (*OutboxMailer, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxMailer{Dir: dir}, nil
+}
+
+func (m *OutboxMailer) Send(msg Message) error {
+	suffix := make([]byte, 4)
+
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	contents := fmt.Sprintf("Date: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		now.Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(contents), 0600)
+}
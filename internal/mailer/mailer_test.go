@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewOutboxMailerCreatesDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox", "nested")
+
+	_, err := NewOutboxMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		t.Errorf("outbox dir %q not created: %v", dir, err)
+	}
+}
+
+func TestOutboxMailerSend(t *testing.T) {
+	m, err := NewOutboxMailer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = m.Send(Message{To: "user@example.com", Subject: "Reset your password", Body: "Your token is abc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(m.Dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d messages in the outbox, want 2 (one file each)", len(files))
+	}
+
+	contents, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers, body, found := strings.Cut(string(contents), "\r\n\r\n")
+	if !found {
+		t.Fatalf("message has no blank line between headers and body:\n%s", contents)
+	}
+	for _, want := range []string{"Date: ", "To: user@example.com", "Subject: Reset your password"} {
+		if !strings.Contains(headers, want) {
+			t.Errorf("headers missing %q:\n%s", want, headers)
+		}
+	}
+	if body != "Your token is abc\r\n" {
+		t.Errorf("body = %q", body)
+	}
+
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("message mode = %v, want 0600", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,300 @@
+// Package metrics keeps counters and histograms in memory and writes them out
+// in the Prometheus text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit request and database latencies, in seconds
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type collector interface {
+	write(w io.Writer) error
+}
+
+// Registry is every metric that gets exposed, in the order they were registered
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes every metric in the text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		err := c.write(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ContentType is what WriteText's output should be served as
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (f family) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+	return err
+}
+
+// CounterVec is a counter split up by label values
+type CounterVec struct {
+	family
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+func (r *Registry) NewCounter(name string, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{
+		family: family{name: name, help: help, kind: "counter", labels: labels},
+		series: map[string]*counterSeries{},
+	}
+	r.register(counter)
+
+	return counter
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increases the counter for the given label values, which have to line up with the counter's labels
+func (c *CounterVec) Add(delta float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := seriesKey(values)
+	series, ok := c.series[key]
+	if !ok {
+		series = &counterSeries{values: append([]string{}, values...)}
+		c.series[key] = series
+	}
+
+	series.value += delta
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.writeHeader(w)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range sortedKeys(c.series) {
+		series := c.series[key]
+		_, err = fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, series.values), formatValue(series.value))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HistogramVec is a histogram split up by label values
+type HistogramVec struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	histogram := &HistogramVec{
+		family:  family{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+	r.register(histogram)
+
+	return histogram
+}
+
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := seriesKey(values)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{values: append([]string{}, values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.writeHeader(w)
+	if err != nil {
+		return err
+	}
+
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+
+		for i, bound := range h.buckets {
+			labels := formatLabels(bucketLabels, append(append([]string{}, series.values...), formatValue(bound)))
+			_, err = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, series.counts[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		labels := formatLabels(bucketLabels, append(append([]string{}, series.values...), "+Inf"))
+		_, err = fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, labels, series.count,
+			h.name, formatLabels(h.labels, series.values), formatValue(series.sum),
+			h.name, formatLabels(h.labels, series.values), series.count)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// funcMetric is a single value worked out whenever metrics are written
+type funcMetric struct {
+	family
+	value func() (float64, error)
+}
+
+// NewGaugeFunc exposes a value that can go up and down, read when metrics are written
+func (r *Registry) NewGaugeFunc(name string, help string, value func() (float64, error)) {
+	r.register(&funcMetric{family: family{name: name, help: help, kind: "gauge"}, value: value})
+}
+
+// NewCounterFunc exposes a value that only goes up (or resets), read when metrics are written
+func (r *Registry) NewCounterFunc(name string, help string, value func() (float64, error)) {
+	r.register(&funcMetric{family: family{name: name, help: help, kind: "counter"}, value: value})
+}
+
+func (m *funcMetric) write(w io.Writer) error {
+	value, err := m.value()
+	if err != nil {
+		// a metric we can't read is left out rather than failing the whole scrape
+		return nil
+	}
+
+	err = m.writeHeader(w)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s %s\n", m.name, formatValue(value))
+	return err
+}
+
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabel(value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.NewCounter("http_requests_total", "Requests handled.", "route", "status")
+	requests.Inc("/api/chirps", "200")
+	requests.Inc("/api/chirps", "200")
+	requests.Add(0.5, "/api/login", "401")
+	requests.Inc(`/say "hi"`+"\n"+`\o/`, "404")
+
+	latency := registry.NewHistogram("http_request_duration_seconds", "How long requests took.", []float64{1, 0.1}, "route")
+	latency.Observe(0.05, "/api/chirps")
+	latency.Observe(0.1, "/api/chirps")
+	latency.Observe(3, "/api/chirps")
+
+	registry.NewGaugeFunc("chirps", "Chirps stored.\nDeleted ones aren't counted.", func() (float64, error) {
+		return 42, nil
+	})
+	registry.NewCounterFunc("broken_total", "Can't be read.", func() (float64, error) {
+		return 0, errors.New("database closed")
+	})
+
+	want := `# HELP http_requests_total Requests handled.
+# TYPE http_requests_total counter
+http_requests_total{route="/api/chirps",status="200"} 2
+http_requests_total{route="/api/login",status="401"} 0.5
+http_requests_total{route="/say \"hi\"\n\\o/",status="404"} 1
+# HELP http_request_duration_seconds How long requests took.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{route="/api/chirps",le="0.1"} 2
+http_request_duration_seconds_bucket{route="/api/chirps",le="1"} 2
+http_request_duration_seconds_bucket{route="/api/chirps",le="+Inf"} 3
+http_request_duration_seconds_sum{route="/api/chirps"} 3.15
+http_request_duration_seconds_count{route="/api/chirps"} 3
+# HELP chirps Chirps stored.\nDeleted ones aren't counted.
+# TYPE chirps gauge
+chirps 42
+`
+
+	out := strings.Builder{}
+	err := registry.WriteText(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != want {
+		t.Errorf("WriteText wrote:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{12, "12"},
+		{0.0025, "0.0025"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		if got := formatValue(tt.value); got != tt.want {
+			t.Errorf("formatValue(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCounterConcurrent(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("hits_total", "Hits.")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				counter.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+
+	out := strings.Builder{}
+	err := registry.WriteText(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "\nhits_total 5000\n") {
+		t.Errorf("WriteText wrote:\n%s\nwant hits_total 5000", out.String())
+	}
+}
@@ -0,0 +1,94 @@
+// Package moderation checks chirps against a pipeline of filters before they're saved
+//
+// Every filter can mask what it finds (the chirp is saved with the match hidden), flag it
+// (the chirp is saved but marked for a moderator to look at) or reject it outright. Each
+// thing a filter finds comes back as a Finding so the client can be told why.
+package moderation
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Action string
+
+const (
+	ActionMask   Action = "mask"
+	ActionFlag   Action = "flag"
+	ActionReject Action = "reject"
+)
+
+// Mask is what masked words are replaced with
+const Mask = "****"
+
+func ParseAction(raw string) (Action, error) {
+	switch action := Action(strings.ToLower(strings.TrimSpace(raw))); action {
+	case ActionMask, ActionFlag, ActionReject:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown moderation action %q, expected mask, flag or reject", raw)
+	}
+}
+
+// Finding is one thing a filter didn't like about a chirp
+type Finding struct {
+	Rule   string `json:"rule"`
+	Action Action `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// Filter looks at a chirp, returning it with anything it masks hidden along with what it found
+type Filter interface {
+	Apply(body string) (string, []Finding)
+}
+
+// Result is a chirp after it's been through every filter
+type Result struct {
+	Body     string    `json:"-"`
+	Findings []Finding `json:"findings"`
+}
+
+func (r Result) Rejected() bool {
+	return r.has(ActionReject)
+}
+
+func (r Result) Flagged() bool {
+	return r.has(ActionFlag)
+}
+
+// Reasons lists why each finding with the given action was made
+func (r Result) Reasons(action Action) []string {
+	reasons := []string{}
+	for _, finding := range r.Findings {
+		if finding.Action == action {
+			reasons = append(reasons, finding.Reason)
+		}
+	}
+
+	return reasons
+}
+
+func (r Result) has(action Action) bool {
+	for _, finding := range r.Findings {
+		if finding.Action == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pipeline runs filters in order, each one seeing the chirp as the last one left it
+type Pipeline []Filter
+
+func (p Pipeline) Moderate(body string) Result {
+	result := Result{Body: body, Findings: []Finding{}}
+
+	for _, filter := range p {
+		masked, findings := filter.Apply(result.Body)
+		result.Body = masked
+		result.Findings = append(result.Findings, findings...)
+	}
+
+	return result
+}
@@ -0,0 +1,57 @@
+package moderation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAction(t *testing.T) {
+	for raw, want := range map[string]Action{"mask": ActionMask, " Flag ": ActionFlag, "REJECT": ActionReject} {
+		got, err := ParseAction(raw)
+		if err != nil || got != want {
+			t.Errorf("ParseAction(%q) = %q, %v, want %q", raw, got, err, want)
+		}
+	}
+
+	_, err := ParseAction("delete")
+	if err == nil {
+		t.Error("ParseAction accepted an unknown action")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	pipeline := Pipeline{
+		testWordList(),
+		RepeatedCharacters{Max: 3, Action: ActionFlag},
+		LinkFilter{Action: ActionMask},
+	}
+
+	result := pipeline.Moderate("Kerfuffle!!!!! at https://evil.test")
+	if result.Body != "****!!!!! at [link removed]" {
+		t.Errorf("body = %q", result.Body)
+	}
+	if !result.Flagged() || result.Rejected() {
+		t.Errorf("flagged %v, rejected %v, want only flagged", result.Flagged(), result.Rejected())
+	}
+
+	rules := []string{}
+	for _, finding := range result.Findings {
+		rules = append(rules, finding.Rule)
+	}
+	if want := []string{"word_list", "repeated_characters", "links"}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("findings from %v, want %v in pipeline order", rules, want)
+	}
+	if reasons := result.Reasons(ActionFlag); len(reasons) != 1 {
+		t.Errorf("flag reasons = %v, want one", reasons)
+	}
+
+	result = pipeline.Moderate("spam")
+	if !result.Rejected() {
+		t.Error("a rejected word didn't reject the chirp")
+	}
+
+	result = pipeline.Moderate("all good")
+	if result.Body != "all good" || len(result.Findings) != 0 {
+		t.Errorf("clean chirp = %+v, want it untouched", result)
+	}
+}
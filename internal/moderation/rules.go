@@ -0,0 +1,192 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// RegexRule acts on anything matching Pattern
+type RegexRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Action  Action
+	Reason  string
+}
+
+func (rule RegexRule) Apply(body string) (string, []Finding) {
+	if !rule.Pattern.MatchString(body) {
+		return body, []Finding{}
+	}
+
+	finding := Finding{Rule: rule.Name, Action: rule.Action, Reason: rule.Reason}
+	if rule.Action == ActionMask {
+		body = rule.Pattern.ReplaceAllString(body, Mask)
+	}
+
+	return body, []Finding{finding}
+}
+
+// LoadRegexRules reads one rule per line as its name, the action to take and then the pattern,
+// which runs to the end of the line. Blank lines and lines starting with # are skipped.
+func LoadRegexRules(path string) ([]RegexRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := []RegexRule{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		name, rest := cutField(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+
+		rawAction, rawPattern := cutField(rest)
+		if rawPattern == "" {
+			return nil, fmt.Errorf("%s:%d: expected a name, an action and a pattern", path, line)
+		}
+
+		action, err := ParseAction(rawAction)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		pattern, err := regexp.Compile(rawPattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		rules = append(rules, RegexRule{
+			Name:    name,
+			Pattern: pattern,
+			Action:  action,
+			Reason:  fmt.Sprintf("Matches the %s pattern", name),
+		})
+	}
+
+	return rules, scanner.Err()
+}
+
+// cutField splits off the first whitespace separated field, returning it and the trimmed rest of s
+func cutField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end < 0 {
+		return s, ""
+	}
+
+	return s[:end], strings.TrimSpace(s[end:])
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s]+`)
+
+// LinkFilter acts on links, apart from ones to an allowed domain (or its subdomains)
+type LinkFilter struct {
+	Action         Action
+	AllowedDomains []string
+}
+
+func (filter LinkFilter) Apply(body string) (string, []Finding) {
+	blocked := 0
+
+	masked := linkPattern.ReplaceAllStringFunc(body, func(link string) string {
+		if filter.allowed(link) {
+			return link
+		}
+
+		blocked++
+		if filter.Action == ActionMask {
+			return "[link removed]"
+		}
+		return link
+	})
+
+	if blocked == 0 {
+		return body, []Finding{}
+	}
+
+	return masked, []Finding{{
+		Rule:   "links",
+		Action: filter.Action,
+		Reason: "Links aren't allowed in chirps",
+	}}
+}
+
+func (filter LinkFilter) allowed(link string) bool {
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, domain := range filter.AllowedDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RepeatedCharacters acts on any character other than whitespace repeated more than Max times in a row,
+// masking by cutting the run down to Max
+type RepeatedCharacters struct {
+	Max    int
+	Action Action
+}
+
+func (filter RepeatedCharacters) Apply(body string) (string, []Finding) {
+	masked := strings.Builder{}
+	found := false
+	var previous rune
+	run := 0
+
+	for _, r := range body {
+		if r == previous {
+			run++
+		} else {
+			previous = r
+			run = 1
+		}
+
+		if run > filter.Max && !unicode.IsSpace(r) {
+			found = true
+			if filter.Action == ActionMask {
+				continue
+			}
+		}
+
+		masked.WriteRune(r)
+	}
+
+	if !found {
+		return body, []Finding{}
+	}
+
+	if filter.Action != ActionMask {
+		masked.Reset()
+		masked.WriteString(body)
+	}
+
+	return masked.String(), []Finding{{
+		Rule:   "repeated_characters",
+		Action: filter.Action,
+		Reason: fmt.Sprintf("No character can be repeated more than %d times in a row", filter.Max),
+	}}
+}
@@ -0,0 +1,145 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRegexRule(t *testing.T) {
+	rule := RegexRule{
+		Name:    "secret",
+		Pattern: regexp.MustCompile(`(?i)\bsecret-\d+\b`),
+		Action:  ActionMask,
+		Reason:  "No secrets",
+	}
+
+	body, findings := rule.Apply("my code is SECRET-123 and secret-4")
+	if body != "my code is **** and ****" {
+		t.Errorf("masked body = %q", body)
+	}
+	if len(findings) != 1 || findings[0].Rule != "secret" || findings[0].Reason != "No secrets" {
+		t.Errorf("findings = %+v, want one secret finding", findings)
+	}
+
+	rule.Action = ActionFlag
+	body, findings = rule.Apply("secret-1")
+	if body != "secret-1" || len(findings) != 1 || findings[0].Action != ActionFlag {
+		t.Errorf("flagging = %q, %+v, want the body untouched and a flag", body, findings)
+	}
+
+	body, findings = rule.Apply("nothing here")
+	if body != "nothing here" || len(findings) != 0 {
+		t.Errorf("no match = %q, %+v", body, findings)
+	}
+}
+
+func TestLoadRegexRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.txt")
+	contents := strings.Join([]string{
+		"# comment",
+		"",
+		`secret  REJECT  (?i)\bsecret-\d+\b`,
+		`shout flag [A-Z]{5,} [A-Z]{5,}`,
+	}, "\n")
+
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := LoadRegexRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 2 {
+		t.Fatalf("loaded %d rules, want 2: %+v", len(rules), rules)
+	}
+	if rules[0].Name != "secret" || rules[0].Action != ActionReject || rules[0].Pattern.String() != `(?i)\bsecret-\d+\b` {
+		t.Errorf("first rule = %+v", rules[0])
+	}
+	// the pattern runs to the end of the line, spaces and all
+	if !rules[1].Pattern.MatchString("HELLO THERE") || rules[1].Pattern.MatchString("HELLO") {
+		t.Errorf("second rule pattern = %q", rules[1].Pattern)
+	}
+
+	for _, bad := range []string{"secret delete secret-\\d+", "secret mask (unclosed", "secret mask"} {
+		err = os.WriteFile(path, []byte("# comment\n"+bad+"\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = LoadRegexRules(path)
+		if err == nil || !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("loading %q = %v, want an error with the line number", bad, err)
+		}
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	filter := LinkFilter{Action: ActionMask, AllowedDomains: []string{"example.com"}}
+
+	tests := []struct {
+		body  string
+		want  string
+		found bool
+	}{
+		{"see https://evil.test/page", "see [link removed]", true},
+		{"see www.evil.test", "see [link removed]", true},
+		{"see HTTP://EVIL.TEST", "see [link removed]", true},
+		{"see https://example.com/page", "see https://example.com/page", false},
+		{"see https://docs.example.com/page", "see https://docs.example.com/page", false},
+		{"see https://example.com.evil.test", "see [link removed]", true},
+		{"see https://notexample.com", "see [link removed]", true},
+		{"example.com without a scheme isn't a link", "example.com without a scheme isn't a link", false},
+	}
+
+	for _, tt := range tests {
+		got, findings := filter.Apply(tt.body)
+		if got != tt.want || (len(findings) > 0) != tt.found {
+			t.Errorf("Apply(%q) = %q, %d findings, want %q, found %v", tt.body, got, len(findings), tt.want, tt.found)
+		}
+	}
+
+	filter.Action = ActionReject
+	body, findings := filter.Apply("https://evil.test and https://evil.test/again")
+	if body != "https://evil.test and https://evil.test/again" {
+		t.Errorf("rejecting changed the body: %q", body)
+	}
+	if len(findings) != 1 || findings[0].Action != ActionReject || findings[0].Rule != "links" {
+		t.Errorf("findings = %+v, want a single links rejection", findings)
+	}
+}
+
+func TestRepeatedCharacters(t *testing.T) {
+	filter := RepeatedCharacters{Max: 3, Action: ActionMask}
+
+	tests := []struct {
+		body  string
+		want  string
+		found bool
+	}{
+		{"sooo good", "sooo good", false},
+		{"soooooo good", "sooo good", true},
+		{"!!!!!!", "!!!", true},
+		{"nooooo waaaay", "nooo waaay", true},
+		{"ééééé", "ééé", true},
+		{"lots of          spaces", "lots of          spaces", false},
+	}
+
+	for _, tt := range tests {
+		got, findings := filter.Apply(tt.body)
+		if got != tt.want || (len(findings) > 0) != tt.found {
+			t.Errorf("Apply(%q) = %q, %d findings, want %q, found %v", tt.body, got, len(findings), tt.want, tt.found)
+		}
+	}
+
+	filter.Action = ActionFlag
+	body, findings := filter.Apply("soooooo")
+	if body != "soooooo" || len(findings) != 1 || findings[0].Action != ActionFlag {
+		t.Errorf("flagging = %q, %+v, want the body untouched and a flag", body, findings)
+	}
+}
@@ -0,0 +1,126 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// WordList catches listed words however they're capitalised, accented or punctuated,
+// so "Fornax!", "kerfuffle," and "ｆｏｒｎａｘ" are all caught
+type WordList struct {
+	Words map[string]Action
+}
+
+// LoadWordList reads one word per line, optionally followed by the action to take for it
+// (mask when left out). Blank lines and lines starting with # are skipped.
+func LoadWordList(path string) (WordList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return WordList{}, err
+	}
+	defer file.Close()
+
+	list := WordList{Words: map[string]Action{}}
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		action := ActionMask
+		if len(fields) > 1 {
+			action, err = ParseAction(fields[1])
+			if err != nil {
+				return WordList{}, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		}
+
+		list.Words[normalizeWord(fields[0])] = action
+	}
+
+	return list, scanner.Err()
+}
+
+func (list WordList) Apply(body string) (string, []Finding) {
+	findings := []Finding{}
+	found := map[string]bool{}
+	masked := strings.Builder{}
+	last := 0
+
+	for _, word := range splitWords(body) {
+		normalized := normalizeWord(body[word.start:word.end])
+		action, listed := list.Words[normalized]
+		if !listed {
+			continue
+		}
+
+		if !found[normalized] {
+			found[normalized] = true
+			findings = append(findings, Finding{
+				Rule:   "word_list",
+				Action: action,
+				Reason: fmt.Sprintf("%q is on the word list", normalized),
+			})
+		}
+
+		if action == ActionMask {
+			masked.WriteString(body[last:word.start])
+			masked.WriteString(Mask)
+			last = word.end
+		}
+	}
+
+	masked.WriteString(body[last:])
+
+	return masked.String(), findings
+}
+
+type wordSpan struct {
+	start int
+	end   int
+}
+
+// splitWords finds the runs of letters and digits in body, so punctuation never sticks to a word
+func splitWords(body string) []wordSpan {
+	words := []wordSpan{}
+	start := -1
+
+	for i, r := range body {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+		if inWord && start < 0 {
+			start = i
+		}
+		if !inWord && start >= 0 {
+			words = append(words, wordSpan{start, i})
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		words = append(words, wordSpan{start, len(body)})
+	}
+
+	return words
+}
+
+// normalizeWord folds compatibility characters (like full width letters) into plain ones,
+// drops accents and lowercases, so every spelling of a word compares the same
+func normalizeWord(word string) string {
+	stripped, _, err := transform.String(transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), word)
+	if err != nil {
+		stripped = word
+	}
+
+	return strings.ToLower(stripped)
+}
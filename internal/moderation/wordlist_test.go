@@ -0,0 +1,104 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testWordList() WordList {
+	return WordList{Words: map[string]Action{
+		"kerfuffle": ActionMask,
+		"fornax":    ActionMask,
+		"sharbert":  ActionFlag,
+		"spam":      ActionReject,
+	}}
+}
+
+func TestWordListMasksPunctuatedWords(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"what a kerfuffle, honestly", "what a ****, honestly"},
+		{"Fornax!", "****!"},
+		{"(kerfuffle)", "(****)"},
+		{"KERFUFFLE... Fornax?!", "****... ****?!"},
+		{"ｆｏｒｎａｘ", "****"},
+		{"Fórnax", "****"},
+		{"kerfuffles are fine", "kerfuffles are fine"},
+		{"nothing to see here", "nothing to see here"},
+	}
+
+	list := testWordList()
+	for _, tt := range tests {
+		got, _ := list.Apply(tt.body)
+		if got != tt.want {
+			t.Errorf("Apply(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestWordListFindings(t *testing.T) {
+	list := testWordList()
+
+	body, findings := list.Apply("Sharbert sharbert kerfuffle")
+	if body != "Sharbert sharbert ****" {
+		t.Errorf("flagged words were changed: %q", body)
+	}
+
+	// each word is only reported once, however many times it appears
+	if len(findings) != 2 {
+		t.Fatalf("got %d findings, want 2: %+v", len(findings), findings)
+	}
+	if findings[0].Action != ActionFlag || findings[1].Action != ActionMask {
+		t.Errorf("findings = %+v, want a flag then a mask", findings)
+	}
+
+	_, findings = list.Apply("buy spam now")
+	if len(findings) != 1 || findings[0].Action != ActionReject || findings[0].Rule != "word_list" {
+		t.Errorf("findings = %+v, want a word_list rejection", findings)
+	}
+}
+
+func TestLoadWordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	contents := strings.Join([]string{
+		"# comment",
+		"",
+		"Kerfuffle",
+		"sharbert flag",
+		"spam REJECT",
+	}, "\n")
+
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadWordList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Action{"kerfuffle": ActionMask, "sharbert": ActionFlag, "spam": ActionReject}
+	if len(list.Words) != len(want) {
+		t.Errorf("loaded %v, want %v", list.Words, want)
+	}
+	for word, action := range want {
+		if list.Words[word] != action {
+			t.Errorf("%s = %q, want %q", word, list.Words[word], action)
+		}
+	}
+
+	err = os.WriteFile(path, []byte("kerfuffle delete\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadWordList(path)
+	if err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("loading an unknown action = %v, want an error with the line number", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Package polka verifies the signatures on webhooks sent by Polka, our payment provider
+//
+// Every webhook carries the time it was sent in X-Polka-Timestamp, and an HMAC-SHA256
+// of "<timestamp>.<raw body>" in X-Polka-Signature as "sha256=<hex>". During a key rotation
+// the signature header can hold several comma separated signatures, one per key.
+package polka
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SignatureHeader = "X-Polka-Signature"
+const TimestampHeader = "X-Polka-Timestamp"
+
+// DefaultTolerance is how far a webhook's timestamp can be from now before it's treated as a replay
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrMissingSignature = errors.New("Missing Polka signature")
+	ErrInvalidTimestamp = errors.New("Invalid Polka timestamp")
+	ErrStaleTimestamp   = errors.New("Polka timestamp is outside the replay window")
+	ErrInvalidSignature = errors.New("Invalid Polka signature")
+)
+
+// Verifier checks webhooks against every key that is currently active,
+// so the old and new keys both work while a key is being rotated
+type Verifier struct {
+	Keys      []string
+	Tolerance time.Duration
+	Now       func() time.Time
+}
+
+// NewVerifier ignores empty keys, so an unset previous key can be passed straight in
+func NewVerifier(keys ...string) Verifier {
+	active := []string{}
+	for _, key := range keys {
+		if key != "" {
+			active = append(active, key)
+		}
+	}
+
+	return Verifier{
+		Keys:      active,
+		Tolerance: DefaultTolerance,
+		Now:       time.Now,
+	}
+}
+
+// Sign computes the signature Polka sends for a body at a given time
+func Sign(key string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the timestamp is recent and at least one signature matches an active key
+func (v Verifier) Verify(header http.Header, body []byte) error {
+	signatureHeader := header.Get(SignatureHeader)
+	timestampHeader := header.Get(TimestampHeader)
+	if signatureHeader == "" || timestampHeader == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(timestampHeader), 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+
+	age := v.Now().Sub(time.Unix(timestamp, 0))
+	if age > v.Tolerance || age < -v.Tolerance {
+		return ErrStaleTimestamp
+	}
+
+	for _, key := range v.Keys {
+		expected := []byte(Sign(key, timestamp, body))
+
+		for _, signature := range strings.Split(signatureHeader, ",") {
+			if hmac.Equal(expected, []byte(strings.TrimSpace(signature))) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidSignature
+}
@@ -0,0 +1,85 @@
+package polka
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// printf '1700000000.{"event":"user.upgraded"}' | openssl dgst -sha256 -hmac key
+	const want = "sha256=f3bace1944442c0a5a475d3012f60b62ce624804b7ae3bfa47c674ea1959337a"
+
+	got := Sign("key", 1700000000, []byte(`{"event":"user.upgraded"}`))
+	if got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":1}}`)
+
+	headers := func(timestamp int64, signatures string) http.Header {
+		header := http.Header{}
+		if signatures != "" {
+			header.Set(SignatureHeader, signatures)
+		}
+		if timestamp != 0 {
+			header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		}
+		return header
+	}
+	signed := func(key string, at time.Time) http.Header {
+		return headers(at.Unix(), Sign(key, at.Unix(), body))
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		want   error
+	}{
+		{"current key", signed("current", now), body, nil},
+		{"previous key", signed("previous", now), body, nil},
+		{"one of several signatures", headers(now.Unix(), "sha256=00, "+Sign("current", now.Unix(), body)), body, nil},
+		{"just inside the window", signed("current", now.Add(-DefaultTolerance)), body, nil},
+		{"too old", signed("current", now.Add(-DefaultTolerance-time.Second)), body, ErrStaleTimestamp},
+		{"too far ahead", signed("current", now.Add(DefaultTolerance+time.Second)), body, ErrStaleTimestamp},
+		{"unknown key", signed("retired", now), body, ErrInvalidSignature},
+		{"tampered body", signed("current", now), []byte(`{"event":"user.upgraded","data":{"user_id":2}}`), ErrInvalidSignature},
+		{"timestamp changed", headers(now.Unix()+1, Sign("current", now.Unix(), body)), body, ErrInvalidSignature},
+		{"no signature", headers(now.Unix(), ""), body, ErrMissingSignature},
+		{"no timestamp", headers(0, Sign("current", now.Unix(), body)), body, ErrMissingSignature},
+	}
+
+	verifier := NewVerifier("current", "previous", "")
+	verifier.Now = func() time.Time { return now }
+
+	for _, tt := range tests {
+		err := verifier.Verify(tt.header, tt.body)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyInvalidTimestamp(t *testing.T) {
+	header := http.Header{}
+	header.Set(SignatureHeader, "sha256=00")
+	header.Set(TimestampHeader, "yesterday")
+
+	err := NewVerifier("current").Verify(header, nil)
+	if !errors.Is(err, ErrInvalidTimestamp) {
+		t.Errorf("Verify = %v, want %v", err, ErrInvalidTimestamp)
+	}
+}
+
+func TestNewVerifierIgnoresEmptyKeys(t *testing.T) {
+	verifier := NewVerifier("", "current", "")
+	if len(verifier.Keys) != 1 || verifier.Keys[0] != "current" {
+		t.Errorf("Keys = %q, want [current]", verifier.Keys)
+	}
+}
@@ -0,0 +1,126 @@
+// Package ratelimit limits how often each client can do something with token buckets
+//
+// Every key (a user or an IP) gets a bucket holding up to Burst tokens, which refills at
+// Limit tokens per Window. Each request takes a token, and requests are refused once it's empty.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type Policy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	// Burst is how many requests can be made at once, and defaults to Limit
+	Burst int
+}
+
+// String describes the policy for the RateLimit-Policy header
+func (p Policy) String() string {
+	return fmt.Sprintf("%d;w=%d;burst=%d;comment=%q", p.Limit, int(p.Window.Seconds()), p.burst(), p.Name)
+}
+
+func (p Policy) burst() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// perSecond is how quickly a bucket refills
+func (p Policy) perSecond() float64 {
+	return float64(p.Limit) / p.Window.Seconds()
+}
+
+// Decision is whether a request is allowed, and what to tell the client about its bucket
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type Limiter struct {
+	Policy Policy
+	Now    func() time.Time
+
+	mux       sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewLimiter(policy Policy) *Limiter {
+	return &Limiter{
+		Policy:  policy,
+		Now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from key's bucket if there is one
+func (l *Limiter) Allow(key string) Decision {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := l.Now()
+	l.sweep(now)
+
+	burst := float64(l.Policy.burst())
+	rate := l.Policy.perSecond()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	decision := Decision{Limit: l.Policy.burst()}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+
+	decision.Remaining = int(b.tokens)
+	decision.Reset = secondsToDuration((burst - b.tokens) / rate)
+
+	return decision
+}
+
+// sweep drops buckets that have filled back up, since they'd be made the same way again,
+// so clients that have gone away don't use memory forever
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Policy.Window {
+		return
+	}
+	l.lastSweep = now
+
+	burst := float64(l.Policy.burst())
+	rate := l.Policy.perSecond()
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestLimiter refills one token a second, with room for 3
+func newTestLimiter() (*Limiter, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewLimiter(Policy{Name: "test", Limit: 60, Window: time.Minute, Burst: 3})
+	limiter.Now = func() time.Time { return now }
+
+	return limiter, &now
+}
+
+func TestAllow(t *testing.T) {
+	limiter, now := newTestLimiter()
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		key     string
+		want    Decision
+	}{
+		{"first", 0, "user:1", Decision{Allowed: true, Limit: 3, Remaining: 2, Reset: time.Second}},
+		{"second", 0, "user:1", Decision{Allowed: true, Limit: 3, Remaining: 1, Reset: 2 * time.Second}},
+		{"third", 0, "user:1", Decision{Allowed: true, Limit: 3, Remaining: 0, Reset: 3 * time.Second}},
+		{"empty", 0, "user:1", Decision{Allowed: false, Limit: 3, Remaining: 0, Reset: 3 * time.Second, RetryAfter: time.Second}},
+		{"other keys have their own bucket", 0, "ip:127.0.0.1", Decision{Allowed: true, Limit: 3, Remaining: 2, Reset: time.Second}},
+		{"partly refilled", 500 * time.Millisecond, "user:1", Decision{Allowed: false, Limit: 3, Remaining: 0, Reset: 2500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+		{"refilled a token", 500 * time.Millisecond, "user:1", Decision{Allowed: true, Limit: 3, Remaining: 0, Reset: 3 * time.Second}},
+		{"never more than the burst", time.Hour, "user:1", Decision{Allowed: true, Limit: 3, Remaining: 2, Reset: time.Second}},
+	}
+
+	for _, step := range steps {
+		*now = now.Add(step.advance)
+
+		got := limiter.Allow(step.key)
+		if got != step.want {
+			t.Errorf("%s: Allow = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestBurstDefaultsToLimit(t *testing.T) {
+	limiter := NewLimiter(Policy{Name: "test", Limit: 5, Window: time.Minute})
+	limiter.Now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if limiter.Allow("user:1").Allowed {
+			allowed++
+		}
+	}
+
+	if allowed != 5 {
+		t.Errorf("allowed %d requests at once, want 5", allowed)
+	}
+}
+
+func TestSweepDropsFullBuckets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	// takes two windows to fill up from empty
+	limiter := NewLimiter(Policy{Name: "test", Limit: 3, Window: time.Minute, Burst: 6})
+	limiter.Now = func() time.Time { return now }
+
+	limiter.Allow("user:1")
+	for i := 0; i < 6; i++ {
+		limiter.Allow("user:2")
+	}
+
+	now = now.Add(time.Minute - time.Second)
+	limiter.Allow("user:3")
+	if len(limiter.buckets) != 3 {
+		t.Fatalf("swept before a window had passed, %d buckets left", len(limiter.buckets))
+	}
+
+	now = now.Add(time.Second)
+	limiter.Allow("user:3")
+
+	if _, ok := limiter.buckets["user:1"]; ok {
+		t.Error("kept user:1's bucket once it had filled back up")
+	}
+	for _, key := range []string{"user:2", "user:3"} {
+		if _, ok := limiter.buckets[key]; !ok {
+			t.Errorf("dropped %s's bucket before it had filled back up", key)
+		}
+	}
+}
+
+func TestPolicyString(t *testing.T) {
+	policy := Policy{Name: "chirps", Limit: 20, Window: time.Minute, Burst: 10}
+
+	want := `20;w=60;burst=10;comment="chirps"`
+	if got := policy.String(); got != want {
+		t.Errorf("String = %s, want %s", got, want)
+	}
+}
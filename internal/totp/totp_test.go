@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key from the test vectors in RFC 6238 appendix B
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 gives 8 digit codes, these are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeRejectsBadSecret(t *testing.T) {
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("Code with an invalid secret succeeded, want an error")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Counter(now)
+
+	code := func(step int64) string {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOk   bool
+	}{
+		{"current", code(current), current, true},
+		{"previous step", code(current - 1), current - 1, true},
+		{"next step", code(current + 1), current + 1, true},
+		{"too old", code(current - 2), 0, false},
+		{"too new", code(current + 2), 0, false},
+		{"spaces", " " + code(current)[:3] + " " + code(current)[3:] + " ", current, true},
+		{"too short", code(current)[:5], 0, false},
+		{"wrong", "000000", 0, false},
+	}
+
+	for _, tt := range tests {
+		step, ok := Match(rfcSecret, tt.code, now)
+		if ok != tt.wantOk || step != tt.wantStep {
+			t.Errorf("%s: Match = %d, %v, want %d, %v", tt.name, step, ok, tt.wantStep, tt.wantOk)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Code(secret, 0)
+	if err != nil {
+		t.Errorf("generated secret %q isn't usable: %v", secret, err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Chirpy", "you@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Chirpy:you@example.com" {
+		t.Errorf("URI = %s, want otpauth://totp/Chirpy:you@example.com", uri)
+	}
+
+	query := uri.Query()
+	want := map[string]string{"secret": rfcSecret, "issuer": "Chirpy", "digits": "6", "period": "30", "algorithm": "SHA1"}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package webhooks delivers events to the HTTPS endpoints users have registered
+//
+// Deliveries are signed the same way Polka signs the webhooks it sends us: X-Chirpy-Timestamp
+// holds the time it was sent, and X-Chirpy-Signature holds an HMAC-SHA256 of
+// "<timestamp>.<raw body>" keyed with the endpoint's secret, as "sha256=<hex>".
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+const SignatureHeader = "X-Chirpy-Signature"
+const TimestampHeader = "X-Chirpy-Timestamp"
+const EventHeader = "X-Chirpy-Event"
+const DeliveryHeader = "X-Chirpy-Delivery"
+
+// Event is the JSON body every delivery carries
+type Event struct {
+	Id        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Sign computes the signature sent along with a body at a given time
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher queues events for delivery and sends them from a background worker,
+// retrying failures with exponential backoff
+type Dispatcher struct {
+	DB          *database.DB
+	Client      *http.Client
+	Interval    time.Duration
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int
+	BatchSize   int
+	Now         func() time.Time
+	// OnAttempt, when set, hears how every attempt went: "delivered", "retrying" or "failed"
+	OnAttempt func(eventType string, outcome string)
+
+	wake chan struct{}
+}
+
+func NewDispatcher(db *database.DB) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      NewClient(),
+		Interval:    5 * time.Second,
+		BaseBackoff: 30 * time.Second,
+		MaxBackoff:  6 * time.Hour,
+		MaxAttempts: 8,
+		BatchSize:   50,
+		Now:         time.Now,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// ErrInternalAddress means an endpoint resolved to an address on the server's own network
+var ErrInternalAddress = errors.New("webhook endpoints can't be on private, loopback or link-local addresses")
+
+// internalRanges are the ranges not already covered by netip.Addr's own checks
+var internalRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// NewClient returns the client deliveries are sent with. Since anyone can register an endpoint, it refuses to
+// connect to internal addresses however the endpoint's host resolves, and never follows redirects
+func NewClient() *http.Client {
+	return newClient(publicAddress)
+}
+
+func newClient(allowed func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		// Control sees the address actually being connected to, after DNS, so it can't be fooled by a host
+		// that resolves to something else the second time round
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+
+			if !allowed(ip.Unmap()) {
+				return ErrInternalAddress
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the endpoint, skipping the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicAddress is false for anything that isn't on the public internet
+func publicAddress(ip netip.Addr) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, internal := range internalRanges {
+		if internal.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Publish queues an event for every endpoint the given users have subscribed to it,
+// and nudges the worker so it doesn't wait for its next tick
+func (d *Dispatcher) Publish(eventType string, data any, userIds ...int) error {
+	eventId, err := newEventId()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(Event{
+		Id:        eventId,
+		Type:      eventType,
+		CreatedAt: d.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	queued, err := d.DB.EnqueueWebhookEvent(eventId, eventType, payload, userIds)
+	if err != nil {
+		return err
+	}
+
+	if queued > 0 {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Run delivers due events until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	due, err := d.DB.DueWebhookDeliveries(d.Now(), d.BatchSize)
+	if err != nil {
+		slog.Error("Error loading webhook deliveries", "error", err)
+		return
+	}
+
+	for _, next := range due {
+		if ctx.Err() != nil {
+			return
+		}
+
+		attempt := d.send(ctx, next)
+		if ctx.Err() != nil {
+			// shutting down cut the attempt short, so leave it to be tried again next time
+			return
+		}
+		succeeded := attempt.Error == ""
+
+		retryAt := time.Time{}
+		if !succeeded && len(next.Delivery.Attempts)+1 < d.MaxAttempts {
+			retryAt = attempt.At.Add(d.backoff(len(next.Delivery.Attempts) + 1))
+		}
+
+		err = d.DB.RecordDeliveryAttempt(next.Delivery.Id, attempt, succeeded, retryAt)
+		if err != nil {
+			slog.Error("Error recording webhook delivery", "delivery_id", next.Delivery.Id, "error", err)
+		}
+
+		if d.OnAttempt != nil {
+			d.OnAttempt(next.Delivery.EventType, attemptOutcome(succeeded, retryAt))
+		}
+	}
+}
+
+func attemptOutcome(succeeded bool, retryAt time.Time) string {
+	switch {
+	case succeeded:
+		return "delivered"
+	case retryAt.IsZero():
+		return "failed"
+	default:
+		return "retrying"
+	}
+}
+
+// backoff doubles the wait after every failed attempt, up to MaxBackoff
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, d.MaxBackoff)
+}
+
+func (d *Dispatcher) send(ctx context.Context, due database.DueWebhookDelivery) database.DeliveryAttempt {
+	now := d.Now().UTC()
+	attempt := database.DeliveryAttempt{At: now}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, due.Endpoint.URL, bytes.NewReader(due.Delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventHeader, due.Delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(due.Delivery.Id))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(due.Endpoint.Secret, now.Unix(), due.Delivery.Payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	attempt.StatusCode = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("Endpoint responded with %d", res.StatusCode)
+	}
+
+	return attempt
+}
+
+func newEventId() (string, error) {
+	raw := make([]byte, 16)
+
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+
+	return "evt_" + hex.EncodeToString(raw), nil
+}
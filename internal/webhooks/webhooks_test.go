@@ -0,0 +1,308 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// receiver is an endpoint that records what it's sent and answers with the next of its statuses,
+// repeating the last one once it runs out
+type receiver struct {
+	mux      sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func (rec *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+
+	rec.requests = append(rec.requests, receivedRequest{header: r.Header.Clone(), body: body})
+
+	status := 200
+	if len(rec.statuses) > 0 {
+		status = rec.statuses[0]
+		if len(rec.statuses) > 1 {
+			rec.statuses = rec.statuses[1:]
+		}
+	}
+	w.WriteHeader(status)
+}
+
+func (rec *receiver) received() []receivedRequest {
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+
+	return append([]receivedRequest{}, rec.requests...)
+}
+
+// testClock is a Now that only moves when told to
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// newTestDispatcher returns a dispatcher sending to a new endpoint for user 1, served by rec.
+// The test server is on loopback, so the dispatcher uses its client rather than the guarded one
+func newTestDispatcher(t *testing.T, rec *receiver) (*Dispatcher, database.WebhookEndpoint, *testClock) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(rec)
+	t.Cleanup(srv.Close)
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint, err := db.CreateWebhookEndpoint(1, srv.URL+"/hook", []string{"chirp.created"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// new deliveries are due straight away by the real clock, so start a little after it
+	clock := &testClock{now: time.Now().Add(time.Minute)}
+
+	d := NewDispatcher(db)
+	d.Client = srv.Client()
+	d.Now = clock.Now
+
+	return d, endpoint, clock
+}
+
+func deliveries(t *testing.T, d *Dispatcher, endpointId int) []database.WebhookDelivery {
+	t.Helper()
+
+	found, err := d.DB.GetWebhookDeliveries(endpointId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return found
+}
+
+func TestDeliverySigned(t *testing.T) {
+	rec := &receiver{}
+	d, endpoint, clock := newTestDispatcher(t, rec)
+
+	err := d.Publish("chirp.created", map[string]int{"id": 1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.deliverDue(context.Background())
+
+	received := rec.received()
+	if len(received) != 1 {
+		t.Fatalf("got %d requests, want 1", len(received))
+	}
+
+	header := received[0].header
+	timestamp := strconv.FormatInt(clock.now.Unix(), 10)
+	if got := header.Get(TimestampHeader); got != timestamp {
+		t.Errorf("%s = %q, want %q", TimestampHeader, got, timestamp)
+	}
+
+	want := Sign(endpoint.Secret, clock.now.Unix(), received[0].body)
+	if got := header.Get(SignatureHeader); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+
+	if got := header.Get(EventHeader); got != "chirp.created" {
+		t.Errorf("%s = %q, want chirp.created", EventHeader, got)
+	}
+
+	delivered := deliveries(t, d, endpoint.Id)
+	if len(delivered) != 1 || delivered[0].Status != database.DELIVERY_SUCCEEDED {
+		t.Errorf("deliveries = %+v, want one that succeeded", delivered)
+	}
+}
+
+func TestDeliveryRetriedAfterFailure(t *testing.T) {
+	rec := &receiver{statuses: []int{500, 200}}
+	d, endpoint, clock := newTestDispatcher(t, rec)
+
+	err := d.Publish("chirp.created", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.deliverDue(context.Background())
+
+	pending := deliveries(t, d, endpoint.Id)[0]
+	if pending.Status != database.DELIVERY_PENDING {
+		t.Fatalf("status after a 500 = %q, want %q", pending.Status, database.DELIVERY_PENDING)
+	}
+	if want := clock.now.Add(d.BaseBackoff); !pending.NextAttemptAt.Equal(want) {
+		t.Errorf("next attempt at %v, want %v", pending.NextAttemptAt, want)
+	}
+
+	// nothing is sent again until the backoff is up
+	clock.now = clock.now.Add(d.BaseBackoff - time.Second)
+	d.deliverDue(context.Background())
+	if got := len(rec.received()); got != 1 {
+		t.Fatalf("got %d requests before the backoff was up, want 1", got)
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	d.deliverDue(context.Background())
+	if got := len(rec.received()); got != 2 {
+		t.Fatalf("got %d requests after the backoff, want 2", got)
+	}
+
+	delivered := deliveries(t, d, endpoint.Id)[0]
+	if delivered.Status != database.DELIVERY_SUCCEEDED || len(delivered.Attempts) != 2 {
+		t.Errorf("delivery = %+v, want succeeded after 2 attempts", delivered)
+	}
+	if delivered.Attempts[0].StatusCode != 500 {
+		t.Errorf("first attempt status = %d, want 500", delivered.Attempts[0].StatusCode)
+	}
+}
+
+func TestDeliveryGivesUpAfterMaxAttempts(t *testing.T) {
+	rec := &receiver{statuses: []int{500}}
+	d, endpoint, clock := newTestDispatcher(t, rec)
+	d.MaxAttempts = 3
+
+	err := d.Publish("chirp.created", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		d.deliverDue(context.Background())
+		clock.now = clock.now.Add(d.MaxBackoff)
+	}
+
+	if got := len(rec.received()); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+
+	failed := deliveries(t, d, endpoint.Id)[0]
+	if failed.Status != database.DELIVERY_FAILED || len(failed.Attempts) != 3 {
+		t.Errorf("delivery = %+v, want failed after 3 attempts", failed)
+	}
+}
+
+func TestNoDeliveryToDeletedEndpoint(t *testing.T) {
+	rec := &receiver{}
+	d, endpoint, _ := newTestDispatcher(t, rec)
+
+	err := d.Publish("chirp.created", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.DB.DeleteWebhookEndpoint(1, endpoint.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.deliverDue(context.Background())
+
+	if got := len(rec.received()); got != 0 {
+		t.Errorf("got %d requests after the endpoint was deleted, want 0", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseBackoff: 30 * time.Second, MaxBackoff: 6 * time.Hour}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 4*time.Hour + 16*time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := publicAddress(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := NewClient().Get(srv.URL)
+	if !errors.Is(err, ErrInternalAddress) {
+		t.Errorf("got %v sending to loopback, want %v", err, ErrInternalAddress)
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := newClient(func(netip.Addr) bool { return true })
+	res, err := client.Post(srv.URL+"/hook", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusTemporaryRedirect || followed {
+		t.Errorf("got %d (followed: %v), want the redirect itself", res.StatusCode, followed)
+	}
+}
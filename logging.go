@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+const maxRequestIdLength = 128
+
+type loggerContextKey struct{}
+
+// requestLog is filled in while a request is handled, for the line logged once it's done
+type requestLog struct {
+	userId int
+}
+
+type requestLogContextKey struct{}
+
+// loggerFrom returns the logger for the request ctx belongs to, which tags every line with its request id
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// noteUser records who made the request, once they've been authenticated
+func noteUser(ctx context.Context, userId int) {
+	if entry, ok := ctx.Value(requestLogContextKey{}).(*requestLog); ok {
+		entry.userId = userId
+	}
+}
+
+// requestId keeps a caller's X-Request-ID if it's sensible, so ids can be followed across services,
+// and makes up a new one otherwise
+func requestId(r *http.Request) string {
+	incoming := r.Header.Get(requestIdHeader)
+	if incoming != "" && len(incoming) <= maxRequestIdLength && printableASCII(incoming) {
+		return incoming
+	}
+
+	raw := make([]byte, 16)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(raw)
+}
+
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// middlewareRequestLog gives every request an id and a logger carrying it,
+// then logs one line for the request once it's been handled
+func middlewareRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestId(r)
+		w.Header().Set(requestIdHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		entry := &requestLog{}
+		ctx := context.WithValue(r.Context(), loggerContextKey{}, logger)
+		ctx = context.WithValue(ctx, requestLogContextKey{}, entry)
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := ""
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+			route = routeContext.RoutePattern()
+		}
+
+		level := slog.LevelInfo
+		if rec.statusCode() >= 500 {
+			level = slog.LevelError
+		}
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"status", rec.statusCode(),
+			"bytes", rec.bytes,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr", clientIP(r),
+		}
+		if entry.userId != 0 {
+			attrs = append(attrs, "user_id", entry.userId)
+		}
+
+		logger.Log(r.Context(), level, "request", attrs...)
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// ipLoginPolicy is looser than the per account one, since several people can share an IP
+var ipLoginPolicy = database.BackoffPolicy{
+	FreeAttempts: 10,
+	Base:         time.Second,
+	Max:          time.Hour,
+}
+
+// ipFailureMemory is how long an IP's failures are remembered after its last one
+const ipFailureMemory = 24 * time.Hour
+
+type ipAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// loginThrottle keeps track of failed logins per client IP in memory
+type loginThrottle struct {
+	mux      sync.Mutex
+	attempts map[string]*ipAttempts
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{
+		attempts: map[string]*ipAttempts{},
+	}
+}
+
+// check returns a LockedError if the IP has to back off before trying again
+func (t *loginThrottle) check(ip string) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	attempts, ok := t.attempts[ip]
+	if !ok {
+		return nil
+	}
+
+	if time.Now().Before(attempts.lockedUntil) {
+		return &database.LockedError{Until: attempts.lockedUntil}
+	}
+
+	return nil
+}
+
+func (t *loginThrottle) recordFailure(ip string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	now := time.Now()
+	t.forgetOld(now)
+
+	attempts, ok := t.attempts[ip]
+	if !ok {
+		attempts = &ipAttempts{}
+		t.attempts[ip] = attempts
+	}
+
+	attempts.failures++
+	attempts.lastFailure = now
+
+	delay := ipLoginPolicy.Delay(attempts.failures)
+	if delay > 0 {
+		attempts.lockedUntil = now.Add(delay)
+	}
+}
+
+func (t *loginThrottle) recordSuccess(ip string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.attempts, ip)
+}
+
+// forgetOld drops IPs that haven't failed in a while so the map doesn't grow forever
+func (t *loginThrottle) forgetOld(now time.Time) {
+	for ip, attempts := range t.attempts {
+		if now.Sub(attempts.lastFailure) > ipFailureMemory && now.After(attempts.lockedUntil) {
+			delete(t.attempts, ip)
+		}
+	}
+}
+
+// respondIfLocked answers with a 429 and Retry-After if err is a LockedError
+func respondIfLocked(w http.ResponseWriter, r *http.Request, err error) bool {
+	var locked *database.LockedError
+	if !errors.As(err, &locked) {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(time.Until(locked.Until).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	respondWithCode(w, r, 429, "login_locked", "Too many failed logins, try again later")
+
+	return true
+}
+
+func (cfg *apiConfig) getLockouts(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePrincipal(w, r); !ok {
+		return
+	}
+
+	activeOnly := r.URL.Query().Get("active") == "true"
+
+	events, err := cfg.db.GetLockoutEvents(activeOnly)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, events)
+}
+
+func (cfg *apiConfig) unlockUser(w http.ResponseWriter, r *http.Request) {
+	param := chi.URLParam(r, "userId")
+	userId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	admin, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	err = cfg.db.UnlockUser(userId, admin.UserId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
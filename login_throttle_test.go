@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+func logInAs(t *testing.T, cfg *apiConfig, ip, email, password string) *http.Response {
+	t.Helper()
+
+	r := newRequest(t, http.MethodPost, "/api/login", "", fullUser{Email: email, Password: password})
+	r.RemoteAddr = ip + ":1234"
+
+	return serve(cfg.logInUser, r).Result()
+}
+
+func TestAccountBackoffAnswers429(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+
+	for i := 0; i < database.AccountLoginPolicy.FreeAttempts; i++ {
+		resp := logInAs(t, cfg, "192.0.2.1", user.Email, "wrong")
+		if resp.StatusCode != 401 {
+			t.Fatalf("failed login %d = %d, want 401", i+1, resp.StatusCode)
+		}
+	}
+
+	// the account is backing off, even from a different IP and with the right password
+	resp := logInAs(t, cfg, "192.0.2.2", user.Email, "password")
+	if resp.StatusCode != 429 {
+		t.Fatalf("login while backing off = %d, want 429", resp.StatusCode)
+	}
+
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || retryAfter < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestIPBackoffAnswers429(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	signUp(t, cfg, "user@example.com")
+
+	// spread across emails that don't exist, so no single account backs off
+	for i := 0; i < ipLoginPolicy.FreeAttempts; i++ {
+		resp := logInAs(t, cfg, "192.0.2.1", "nobody"+strconv.Itoa(i)+"@example.com", "wrong")
+		if resp.StatusCode != 401 {
+			t.Fatalf("failed login %d = %d, want 401", i+1, resp.StatusCode)
+		}
+	}
+
+	resp := logInAs(t, cfg, "192.0.2.1", "user@example.com", "password")
+	if resp.StatusCode != 429 || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("login from a backed off IP = %d, want 429 with Retry-After", resp.StatusCode)
+	}
+
+	resp = logInAs(t, cfg, "192.0.2.2", "user@example.com", "password")
+	if resp.StatusCode != 200 {
+		t.Errorf("login from another IP = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestAdminUnlock(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	_, adminToken := signUpAdmin(t, cfg, "admin@example.com")
+
+	for i := 0; i < database.AccountLoginPolicy.FreeAttempts; i++ {
+		logInAs(t, cfg, "192.0.2.1", user.Email, "wrong")
+	}
+
+	r := withURLParams(newRequest(t, http.MethodPost, "/admin/users/999/unlock", adminToken, nil), map[string]string{"userId": "999"})
+	if w := serveWithRole(cfg, cfg.unlockUser, r, database.ROLE_ADMIN); w.Code != 404 {
+		t.Errorf("unlocking a missing user = %d, want 404", w.Code)
+	}
+
+	id := strconv.Itoa(user.Id)
+	r = withURLParams(newRequest(t, http.MethodPost, "/admin/users/"+id+"/unlock", adminToken, nil), map[string]string{"userId": id})
+	if w := serveWithRole(cfg, cfg.unlockUser, r, database.ROLE_ADMIN); w.Code != 200 {
+		t.Fatalf("unlocking = %d, want 200", w.Code)
+	}
+
+	resp := logInAs(t, cfg, "192.0.2.2", user.Email, "password")
+	if resp.StatusCode != 200 {
+		t.Errorf("login after an unlock = %d, want 200", resp.StatusCode)
+	}
+
+	w := serveWithRole(cfg, cfg.getLockouts, newRequest(t, http.MethodGet, "/admin/lockouts", adminToken, nil), database.ROLE_ADMIN)
+	decodeBody[[]database.LockoutEvent](t, w, 200)
+}
@@ -1,126 +1,336 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
+	"github.com/thegouge/go-chirpy/internal/certs"
+	"github.com/thegouge/go-chirpy/internal/config"
+	"github.com/thegouge/go-chirpy/internal/cors"
 	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/mailer"
+	"github.com/thegouge/go-chirpy/internal/polka"
+	"github.com/thegouge/go-chirpy/internal/webhooks"
 )
 
-const PORT string = "8000"
-const DATABASE_PATH string = "database.json"
-
 func main() {
-	debug := flag.Bool("debug", false, "Enable debug mode")
-	flag.Parse()
-
-	if *debug {
-		fmt.Println("Starting server in debug mode")
-		error := os.Remove(DATABASE_PATH)
-		if error != nil {
-			fmt.Printf("could not delete file: %s", DATABASE_PATH)
+	// the .env file only fills in environment variables that aren't already set
+	godotenv.Load()
+
+	cfg, args, err := config.Load(os.Args[0], os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logLevel := slog.LevelInfo
+	if cfg.Debug {
+		logLevel = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})))
+
+	if len(args) > 0 && args[0] == "config" {
+		err := configCommand(cfg, args[1:])
+		if err != nil {
+			log.Fatal(err)
 		}
+		return
 	}
 
-	db, dbErr := database.NewDB(DATABASE_PATH)
+	if len(args) > 0 && args[0] == "create-admin" {
+		db, err := database.NewDB(cfg.DatabasePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = createAdmin(db, args[1:], os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		log.Fatalf("Invalid config:\n%v", err)
+	}
+
+	// only once we know the server is actually going to start
+	if cfg.ResetDatabase {
+		slog.Warn("Deleting the database before starting", "path", cfg.DatabasePath)
+		err := os.Remove(cfg.DatabasePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatal(err)
+		}
+	}
+
+	db, dbErr := database.NewDB(cfg.DatabasePath)
 	if dbErr != nil {
 		log.Fatal(dbErr)
 	}
 
-	godotenv.Load()
-	jwtSecret := os.Getenv("JWT_SECRET")
-	polkaKey := os.Getenv("POLKA_KEY")
+	db.SetTokenSettings(database.TokenSettings{
+		AccessLifetime:  cfg.AccessTokenLifetime,
+		RefreshLifetime: cfg.RefreshTokenLifetime,
+		Audience:        cfg.JWTAudience,
+	})
+
+	outbox, err := mailer.NewOutboxMailer(cfg.OutboxDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chirpModeration, err := newModerationPipeline(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	apiCfg := apiConfig{
-		db:       db,
-		secret:   jwtSecret,
-		polkaKey: polkaKey,
+		db:         db,
+		secret:     cfg.JWTSecret,
+		polka:      polka.NewVerifier(cfg.PolkaKey, cfg.PolkaKeyPrevious),
+		mailer:     outbox,
+		publicURL:  cfg.PublicURL,
+		webhooks:   webhooks.NewDispatcher(db),
+		moderation: chirpModeration,
+
+		metricsToken: cfg.MetricsToken,
+
+		requireVerifiedEmail: cfg.RequireVerifiedEmail,
+		loginThrottle:        newLoginThrottle(),
+		rateLimitEnabled:     cfg.RateLimit,
 	}
+	apiCfg.metrics = newServerMetrics(db, &apiCfg.fileserverHits)
+	apiCfg.webhooks.OnAttempt = func(eventType string, outcome string) {
+		apiCfg.metrics.webhookDeliveries.Inc(eventType, outcome)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workers := sync.WaitGroup{}
+	runWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+
+	runWorker(func(ctx context.Context) { runSubscriptionExpiry(ctx, db) })
+	runWorker(apiCfg.webhooks.Run)
 
 	r := chi.NewRouter()
 	api := chi.NewRouter()
 	admin := chi.NewRouter()
 
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r.Use(middlewareClientIP(trustedProxies))
+	r.Use(middlewareRequestLog)
+	r.Use(apiCfg.metrics.middleware)
+	r.Use(middlewareHSTS(cfg.HSTSMaxAge, cfg.HSTSIncludeSubdomains))
+
 	r.Handle("/app", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir("./pages")))))
 	r.Handle("/app/*", apiCfg.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir("./pages")))))
 
+	// credentials are checked before rate limiting, which counts logged in users' requests by user
+	api.Use(apiCfg.middlewareAuthenticate)
+	api.Use(apiCfg.rateLimit(apiRateLimit))
+	chirpLimit := apiCfg.rateLimit(chirpRateLimit)
+	signupLimit := apiCfg.rateLimit(signupRateLimit)
+	authLimit := apiCfg.rateLimit(authRateLimit)
+
 	api.Get("/healthz", healthHandler)
-	api.Handle("/reset", http.HandlerFunc(apiCfg.resetHandler))
-	api.Post("/chirps", http.HandlerFunc(apiCfg.chirpValidationHandler))
+	api.With(apiCfg.requireRole(database.ROLE_ADMIN)).Handle("/reset", http.HandlerFunc(apiCfg.resetHandler))
+	api.With(chirpLimit).Post("/chirps", http.HandlerFunc(apiCfg.chirpValidationHandler))
 	api.Get("/chirps", http.HandlerFunc(apiCfg.getAllChirps))
 	api.Get("/chirps/{chirpId}", http.HandlerFunc(apiCfg.getChirpByID))
-	api.Post("/users", http.HandlerFunc(apiCfg.createUser))
+	api.Put("/chirps/{chirpId}", http.HandlerFunc(apiCfg.editChirp))
+	api.With(signupLimit).Post("/users", http.HandlerFunc(apiCfg.createUser))
 	api.Put("/users", http.HandlerFunc(apiCfg.updateUser))
-	api.Post("/login", http.HandlerFunc(apiCfg.logInUser))
+	api.Get("/users/{userId}", http.HandlerFunc(apiCfg.getUserProfile))
+	api.Post("/users/{userId}/follow", http.HandlerFunc(apiCfg.followUser))
+	api.Delete("/users/{userId}/follow", http.HandlerFunc(apiCfg.unfollowUser))
+	api.Post("/users/{userId}/block", apiCfg.userRelationHandler((*database.DB).BlockUser))
+	api.Delete("/users/{userId}/block", apiCfg.userRelationHandler((*database.DB).UnblockUser))
+	api.Post("/users/{userId}/mute", apiCfg.userRelationHandler((*database.DB).MuteUser))
+	api.Delete("/users/{userId}/mute", apiCfg.userRelationHandler((*database.DB).UnmuteUser))
+	api.Get("/blocks", http.HandlerFunc(apiCfg.getBlocks))
+	api.Get("/mutes", http.HandlerFunc(apiCfg.getMutes))
+	api.Get("/timeline", http.HandlerFunc(apiCfg.getTimeline))
+	api.With(authLimit).Post("/login", http.HandlerFunc(apiCfg.logInUser))
+	api.Get("/verify-email", http.HandlerFunc(apiCfg.verifyEmail))
+	api.With(authLimit).Post("/verify-email/resend", http.HandlerFunc(apiCfg.resendVerificationEmail))
+	api.With(authLimit).Post("/password-reset", http.HandlerFunc(apiCfg.requestPasswordReset))
+	api.With(authLimit).Post("/password-reset/confirm", http.HandlerFunc(apiCfg.confirmPasswordReset))
+	api.With(authLimit).Post("/login/mfa", http.HandlerFunc(apiCfg.completeMFALogin))
+	api.Post("/mfa/totp", http.HandlerFunc(apiCfg.enrollTOTP))
+	api.Post("/mfa/totp/confirm", http.HandlerFunc(apiCfg.confirmTOTP))
+	api.Delete("/mfa/totp", http.HandlerFunc(apiCfg.disableTOTP))
 	api.Post("/refresh", http.HandlerFunc(apiCfg.refreshUserToken))
 	api.Post("/revoke", http.HandlerFunc(apiCfg.revokeUserToken))
+	api.Get("/keys", http.HandlerFunc(apiCfg.getAPIKeys))
+	api.Post("/keys", http.HandlerFunc(apiCfg.createAPIKey))
+	api.Delete("/keys/{keyId}", http.HandlerFunc(apiCfg.deleteAPIKey))
+	api.Post("/oauth/clients", http.HandlerFunc(apiCfg.registerOAuthClient))
+	api.Get("/oauth/authorize", http.HandlerFunc(apiCfg.describeAuthorization))
+	api.Post("/oauth/authorize", http.HandlerFunc(apiCfg.authorizeClient))
+	api.With(authLimit).Post("/oauth/token", http.HandlerFunc(apiCfg.issueOAuthToken))
+	api.Post("/oauth/revoke", http.HandlerFunc(apiCfg.revokeOAuthToken))
 	api.Delete("/chirps/{chirpId}", http.HandlerFunc(apiCfg.deleteChirp))
+	api.Post("/chirps/{chirpId}/report", http.HandlerFunc(apiCfg.reportChirp))
+	api.Route("/moderation", func(moderation chi.Router) {
+		moderation.Use(apiCfg.requireRole(database.ROLE_MODERATOR, database.ROLE_ADMIN))
+		moderation.Get("/queue", http.HandlerFunc(apiCfg.getModerationQueue))
+		moderation.Post("/chirps/{chirpId}", http.HandlerFunc(apiCfg.reviewChirp))
+	})
 	api.Post("/polka/webhooks", http.HandlerFunc(apiCfg.handlePayment))
+	api.Get("/webhooks", http.HandlerFunc(apiCfg.getWebhookEndpoints))
+	api.Post("/webhooks", http.HandlerFunc(apiCfg.createWebhookEndpoint))
+	api.Delete("/webhooks/{webhookId}", http.HandlerFunc(apiCfg.deleteWebhookEndpoint))
+	api.Get("/webhooks/{webhookId}/deliveries", http.HandlerFunc(apiCfg.getWebhookDeliveries))
 
-	admin.Get("/metrics", http.HandlerFunc(apiCfg.metricsHandler))
+	admin.Use(apiCfg.middlewareAuthenticate)
+	admin.With(apiCfg.requireMetricsAccess).Get("/metrics/prometheus", http.HandlerFunc(apiCfg.prometheusHandler))
+	admin.Group(func(admin chi.Router) {
+		admin.Use(apiCfg.requireRole(database.ROLE_ADMIN))
+		admin.Get("/metrics", http.HandlerFunc(apiCfg.metricsHandler))
+		admin.Get("/lockouts", http.HandlerFunc(apiCfg.getLockouts))
+		admin.Post("/users/{userId}/unlock", http.HandlerFunc(apiCfg.unlockUser))
+		admin.Put("/users/{userId}/role", http.HandlerFunc(apiCfg.setUserRole))
+		admin.Get("/webhooks/events", http.HandlerFunc(apiCfg.getWebhookEvents))
+		admin.Post("/webhooks/events/{eventId}/replay", http.HandlerFunc(apiCfg.replayWebhookEvent))
+		admin.Get("/moderation/log", http.HandlerFunc(apiCfg.getModerationLog))
+		admin.Put("/users/{userId}/restriction", http.HandlerFunc(apiCfg.restrictUser))
+		admin.Delete("/users/{userId}/restriction", http.HandlerFunc(apiCfg.liftRestriction))
+	})
 
 	r.Mount("/api", api)
 	r.Mount("/admin", admin)
 
-	corsMux := middlewareCors(r)
+	corsPolicy := cors.Policy{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		ExposedHeaders:   cfg.CORSExposedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
+	}
+	corsMux := corsPolicy.Handler(r)
 
-	server := http.Server{
-		Addr:    "localhost:" + PORT,
-		Handler: corsMux,
+	server := &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           corsMux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
 	}
 
-	fmt.Printf("Booting up Server on port %v\n", PORT)
-	err := server.ListenAndServe()
+	servers := []*http.Server{server}
 
-	if err != nil {
-		log.Fatal(err)
+	if cfg.TLS() {
+		reloader, err := certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runWorker(reloader.Run)
+		useTLS(server, reloader, cfg.HTTP2)
+
+		if cfg.RedirectPort != 0 {
+			servers = append(servers, &http.Server{
+				Addr:              cfg.RedirectAddr(),
+				Handler:           httpsRedirectHandler(cfg.Port),
+				ReadHeaderTimeout: 5 * time.Second,
+			})
+		}
 	}
 
-}
+	slog.Info("Booting up server", "addr", server.Addr, "tls", cfg.TLS())
+	serveErr := serveUntilDone(ctx, cfg.ShutdownTimeout, servers...)
 
-func healthHandler(w http.ResponseWriter, Request *http.Request) {
-	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(200)
+	// the server has stopped handling requests, so stop the workers and make sure nothing is mid-write
+	stop()
+	workers.Wait()
 
-	w.Write([]byte("OK"))
-}
+	err = db.Close()
+	if err != nil {
+		slog.Error("Error closing database", "error", err)
+	}
 
-func cleanString(input string) string {
-	CURSE_WORDS := [4]string{
-		"kerfuffle",
-		"sharbert",
-		"fornax",
-		"fuck",
+	if serveErr != nil {
+		log.Fatal(serveErr)
 	}
 
-	words := strings.Split(input, " ")
+	slog.Info("Server stopped")
+}
 
-	for i, word := range words {
-		for _, curse := range CURSE_WORDS {
-			if curse == strings.ToLower(word) {
-				words[i] = "****"
+// serveUntilDone runs the servers until ctx is done or one of them fails, then gives
+// in-flight requests up to timeout to finish before cutting them off
+func serveUntilDone(ctx context.Context, timeout time.Duration, servers ...*http.Server) error {
+	serveErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if server.TLSConfig != nil {
+				serveErr <- server.ListenAndServeTLS("", "")
+				return
 			}
+			serveErr <- server.ListenAndServe()
+		}(server)
+	}
+
+	var firstErr error
+	select {
+	case firstErr = <-serveErr:
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down", "timeout", timeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, server := range servers {
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			slog.Error("Requests didn't finish in time, closing their connections", "addr", server.Addr, "error", err)
+			server.Close()
 		}
 	}
 
-	return strings.Join(words, " ")
+	stopped := len(servers)
+	if firstErr != nil {
+		stopped--
+	}
+	for i := 0; i < stopped; i++ {
+		if err := <-serveErr; firstErr == nil && !errors.Is(err, http.ErrServerClosed) {
+			firstErr = err
+		}
+	}
+
+	return firstErr
 }
 
-func middlewareCors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+func healthHandler(w http.ResponseWriter, Request *http.Request) {
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+
+	w.Write([]byte("OK"))
 }
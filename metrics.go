@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/metrics"
+)
+
+// serverMetrics is everything exposed at /admin/metrics/prometheus
+type serverMetrics struct {
+	registry          *metrics.Registry
+	requests          *metrics.CounterVec
+	requestDuration   *metrics.HistogramVec
+	dbOperations      *metrics.HistogramVec
+	webhooksReceived  *metrics.CounterVec
+	webhookDeliveries *metrics.CounterVec
+}
+
+func newServerMetrics(db *database.DB, fileserverHits *atomic.Int64) *serverMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &serverMetrics{
+		registry:          registry,
+		requests:          registry.NewCounter("chirpy_http_requests_total", "HTTP requests handled, by route pattern and status code.", "method", "route", "status"),
+		requestDuration:   registry.NewHistogram("chirpy_http_request_duration_seconds", "How long HTTP requests took, by route pattern and status code.", metrics.DefaultBuckets, "method", "route", "status"),
+		dbOperations:      registry.NewHistogram("chirpy_db_operation_duration_seconds", "How long reading and writing the database file took.", metrics.DefaultBuckets, "operation"),
+		webhooksReceived:  registry.NewCounter("chirpy_webhooks_received_total", "Incoming webhooks, by source and outcome.", "source", "outcome"),
+		webhookDeliveries: registry.NewCounter("chirpy_webhook_deliveries_total", "Outgoing webhook delivery attempts, by event and outcome.", "event", "outcome"),
+	}
+
+	registry.NewCounterFunc("chirpy_fileserver_hits_total", "Requests for the /app file server since the last reset.", func() (float64, error) {
+		return float64(fileserverHits.Load()), nil
+	})
+	registry.NewGaugeFunc("chirpy_chirps", "Chirps currently in the database.", func() (float64, error) {
+		chirps, _, err := db.Totals()
+		return float64(chirps), err
+	})
+	registry.NewGaugeFunc("chirpy_users", "Users currently in the database.", func() (float64, error) {
+		_, users, err := db.Totals()
+		return float64(users), err
+	})
+
+	db.ObserveOperations(func(operation string, took time.Duration) {
+		m.dbOperations.Observe(took.Seconds(), operation)
+	})
+
+	return m
+}
+
+// statusRecorder remembers the status code a handler responded with, and how much it wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	written, err := rec.ResponseWriter.Write(b)
+	rec.bytes += written
+
+	return written, err
+}
+
+// statusCode is the status sent, which is a 200 if the handler never wrote anything
+func (rec *statusRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+
+	return rec.status
+}
+
+// middleware counts and times every request under the chi route pattern it matched,
+// so /api/chirps/1 and /api/chirps/2 both count towards /api/chirps/{chirpId}
+func (m *serverMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+			route = routeContext.RoutePattern()
+		}
+
+		labels := []string{r.Method, route, strconv.Itoa(rec.statusCode())}
+		m.requests.Inc(labels...)
+		m.requestDuration.Observe(time.Since(start).Seconds(), labels...)
+	})
+}
+
+func (cfg *apiConfig) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.WriteHeader(200)
+
+	cfg.metrics.registry.WriteText(w)
+}
+
+// requireMetricsAccess lets scrapers in with METRICS_TOKEN as a bearer token,
+// and otherwise needs an admin like the rest of /admin
+func (cfg *apiConfig) requireMetricsAccess(next http.Handler) http.Handler {
+	adminOnly := cfg.requireRole(database.ROLE_ADMIN)(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := bearerToken(r)
+		if cfg.metricsToken != "" && err == nil && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		adminOnly.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type mfaCodeParams struct {
+	Code string `json:"code"`
+}
+
+func (cfg *apiConfig) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to set up two-factor authentication")
+		return
+	}
+
+	enrollment, err := cfg.db.BeginTOTPEnrollment(userId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, enrollment)
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (cfg *apiConfig) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to set up two-factor authentication")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := mfaCodeParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	recoveryCodes, err := cfg.db.ConfirmTOTPEnrollment(userId, params.Code)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, recoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+func (cfg *apiConfig) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to turn off two-factor authentication")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := mfaCodeParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	err = cfg.db.DisableTOTP(userId, params.Code)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
+
+type mfaLoginParams struct {
+	MFAToken         string `json:"mfa_token"`
+	Code             string `json:"code"`
+	ExpiresInSeconds int    `json:"expires_in_seconds"`
+}
+
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+func (cfg *apiConfig) completeMFALogin(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	params := mfaLoginParams{}
+
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	ip := clientIP(r)
+
+	err = cfg.loginThrottle.check(ip)
+	if respondIfLocked(w, r, err) {
+		return
+	}
+
+	requestedLifetime := time.Duration(params.ExpiresInSeconds) * time.Second
+
+	response, authUser, err := cfg.db.CompleteMFALogin(params.MFAToken, params.Code, cfg.secret, requestedLifetime, ip)
+	if respondIfLocked(w, r, err) {
+		return
+	}
+
+	if respondIfRestricted(w, r, err) {
+		return
+	}
+
+	if err != nil {
+		respondWithError(w, r, 401, "Invalid or expired challenge")
+		return
+	}
+
+	if !response {
+		cfg.loginThrottle.recordFailure(ip)
+		respondWithCode(w, r, 401, "invalid_code", "Invalid code")
+		return
+	}
+
+	cfg.loginThrottle.recordSuccess(ip)
+
+	user, _, err := cfg.db.GetUserById(authUser.Id)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, UserWithToken{
+		Email:        user.Email,
+		Id:           authUser.Id,
+		Token:        authUser.Token,
+		RefreshToken: authUser.RefreshToken,
+		IsChirpyRed:  authUser.IsChirpyRed,
+		Role:         authUser.Role,
+	})
+}
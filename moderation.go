@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thegouge/go-chirpy/internal/config"
+	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/moderation"
+)
+
+// newModerationPipeline builds the filters every chirp goes through from the moderation_* settings
+func newModerationPipeline(cfg config.Config) (moderation.Pipeline, error) {
+	wordList, err := moderation.LoadWordList(cfg.ModerationWordList)
+	if err != nil {
+		return nil, fmt.Errorf("loading word list: %w", err)
+	}
+
+	rules, err := moderation.LoadRegexRules(cfg.ModerationPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("loading patterns: %w", err)
+	}
+
+	linkAction, err := moderation.ParseAction(cfg.ModerationLinks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid moderation_links: %w", err)
+	}
+
+	pipeline := moderation.Pipeline{wordList}
+	for _, rule := range rules {
+		pipeline = append(pipeline, rule)
+	}
+
+	return append(pipeline,
+		moderation.LinkFilter{Action: linkAction, AllowedDomains: cfg.ModerationAllowedDomains},
+		moderation.RepeatedCharacters{Max: cfg.ModerationMaxRepeatedChars, Action: moderation.ActionMask},
+	), nil
+}
+
+// moderatedChirp is a saved chirp along with what moderation found in it
+type moderatedChirp struct {
+	database.Chirp
+	Moderation []moderation.Finding `json:"moderation"`
+}
+
+// moderateChirp runs a chirp through the pipeline, answering with every reason when it's rejected
+func (cfg *apiConfig) moderateChirp(w http.ResponseWriter, r *http.Request, body string) (moderation.Result, bool) {
+	result := cfg.moderation.Moderate(body)
+	if result.Rejected() {
+		fieldErrors := []fieldError{}
+		for _, finding := range result.Findings {
+			if finding.Action == moderation.ActionReject {
+				fieldErrors = append(fieldErrors, fieldError{Field: "body", Code: finding.Rule, Message: finding.Reason})
+			}
+		}
+
+		respondWithProblem(w, r, problem{
+			Status:     422,
+			Code:       "chirp_rejected",
+			Detail:     "Chirp was rejected: " + strings.Join(result.Reasons(moderation.ActionReject), ", "),
+			Errors:     fieldErrors,
+			Moderation: result.Findings,
+		})
+		return result, false
+	}
+
+	return result, true
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/config"
+)
+
+func TestModerationPipelineCatchesPhoneNumbers(t *testing.T) {
+	pipeline, err := newModerationPipeline(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body    string
+		flagged bool
+	}{
+		{"call me on 555-123-4567", true},
+		{"call me on (555) 123-4567", true},
+		{"call me on 555.123.4567", true},
+		{"call me on +1 555 123 4567", true},
+		{"it's happening on 2024-01-01", false},
+		{"counting 1 2 3 4 5 6 7 8 9 10", false},
+		{"my order number is 1234567890", false},
+		{"the score was 3-1", false},
+	}
+
+	for _, tt := range tests {
+		result := pipeline.Moderate(tt.body)
+		if result.Flagged() != tt.flagged {
+			t.Errorf("Moderate(%q) flagged = %v, want %v: %+v", tt.body, result.Flagged(), tt.flagged, result.Findings)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+type oauthClientParams struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"`
+}
+
+type oauthClientResponse struct {
+	ClientId     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+func (cfg *apiConfig) registerOAuthClient(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to register an app")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := oauthClientParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	fieldErrors := []fieldError{}
+	if params.Name == "" {
+		fieldErrors = append(fieldErrors, requiredField("name", "Apps need a name"))
+	}
+	if len(params.RedirectURIs) == 0 {
+		fieldErrors = append(fieldErrors, requiredField("redirect_uris", "Apps need at least one redirect URI"))
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, r, fieldErrors...)
+		return
+	}
+
+	client, secret, err := cfg.db.RegisterOAuthClient(userId, params.Name, params.RedirectURIs, params.Confidential)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 201, oauthClientResponse{
+		ClientId:     client.Id,
+		ClientSecret: secret,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+	})
+}
+
+type authorizeParams struct {
+	ResponseType        string `json:"response_type"`
+	ClientId            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// validateAuthorization checks an authorization request and works out the scopes being asked for
+func (cfg *apiConfig) validateAuthorization(params authorizeParams) (database.OAuthClient, []string, error) {
+	client, exists, err := cfg.db.GetOAuthClient(params.ClientId)
+	if err != nil {
+		return database.OAuthClient{}, nil, err
+	}
+
+	if !exists {
+		return database.OAuthClient{}, nil, fieldError{Field: "client_id", Code: "unknown_value", Message: "Unknown client_id"}
+	}
+
+	if !client.AllowsRedirect(params.RedirectURI) {
+		return database.OAuthClient{}, nil, fieldError{Field: "redirect_uri", Code: "not_registered", Message: "redirect_uri isn't registered for this client"}
+	}
+
+	if params.ResponseType != "code" {
+		return database.OAuthClient{}, nil, fieldError{Field: "response_type", Code: "unsupported", Message: "Only the code response_type is supported"}
+	}
+
+	if params.CodeChallenge == "" || params.CodeChallengeMethod != "S256" {
+		return database.OAuthClient{}, nil, requiredField("code_challenge", "A PKCE code_challenge using S256 is required")
+	}
+
+	scopes := strings.Fields(params.Scope)
+	if len(scopes) == 0 {
+		scopes = []string{database.SCOPE_CHIRPS_READ}
+	}
+
+	for _, scope := range scopes {
+		if !database.ValidScope(scope) {
+			return database.OAuthClient{}, nil, unknownValue("scope", "scope", scope, database.AllScopes)
+		}
+	}
+
+	return client, scopes, nil
+}
+
+type authorizationDetails struct {
+	ClientId    string   `json:"client_id"`
+	ClientName  string   `json:"client_name"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+}
+
+// describeAuthorization lets the consent page check a request and show the user what's being asked for
+func (cfg *apiConfig) describeAuthorization(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := authorizeParams{
+		ResponseType:        query.Get("response_type"),
+		ClientId:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	client, scopes, err := cfg.validateAuthorization(params)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, authorizationDetails{
+		ClientId:    client.Id,
+		ClientName:  client.Name,
+		RedirectURI: params.RedirectURI,
+		Scopes:      scopes,
+	})
+}
+
+type authorizationResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// authorizeClient is called by the consent page once the logged in user approves or denies the request
+func (cfg *apiConfig) authorizeClient(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to authorize an app")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := authorizeParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	client, scopes, err := cfg.validateAuthorization(params)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	result := url.Values{}
+	if params.State != "" {
+		result.Set("state", params.State)
+	}
+
+	if !params.Approve {
+		result.Set("error", "access_denied")
+	} else {
+		code, err := cfg.db.CreateAuthorizationCode(client.Id, userId, params.RedirectURI, scopes, params.CodeChallenge)
+		if err != nil {
+			respondWithErrorFrom(w, r, err)
+			return
+		}
+
+		result.Set("code", code)
+	}
+
+	redirectTo, err := url.Parse(params.RedirectURI)
+	if err != nil {
+		respondWithFieldErrors(w, r, fieldError{Field: "redirect_uri", Code: "invalid_url", Message: "Invalid redirect_uri"})
+		return
+	}
+
+	query := redirectTo.Query()
+	for key, values := range result {
+		query[key] = values
+	}
+	redirectTo.RawQuery = query.Encode()
+
+	respondWithJson(w, 200, authorizationResponse{
+		RedirectTo: redirectTo.String(),
+	})
+}
+
+// oauthError is the error format OAuth clients expect (RFC 6749 section 5.2)
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func respondWithOAuthError(w http.ResponseWriter, r *http.Request, code int, oauthCode string, description string) {
+	loggerFrom(r.Context()).Info(description, "status", code, "oauth_error", oauthCode)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if code == 401 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+
+	respondWithJson(w, code, oauthError{
+		Error:       oauthCode,
+		Description: description,
+	})
+}
+
+// authenticateClient reads the client credentials from HTTP Basic auth or the form,
+// requiring the secret only for confidential clients
+func (cfg *apiConfig) authenticateClient(r *http.Request) (database.OAuthClient, error) {
+	clientId, clientSecret, usedBasic := r.BasicAuth()
+	if !usedBasic {
+		clientId = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	client, exists, err := cfg.db.GetOAuthClient(clientId)
+	if err != nil {
+		return database.OAuthClient{}, err
+	}
+
+	if !exists {
+		return database.OAuthClient{}, errors.New("Unknown client")
+	}
+
+	if client.Confidential() && !client.CheckSecret(clientSecret) {
+		return database.OAuthClient{}, errors.New("Invalid client secret")
+	}
+
+	return client, nil
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+func (cfg *apiConfig) issueOAuthToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, r, 400, "invalid_request", "Could not parse the form body")
+		return
+	}
+
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		respondWithOAuthError(w, r, 401, "invalid_client", err.Error())
+		return
+	}
+
+	var tokens database.OAuthTokens
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		tokens, err = cfg.db.ExchangeAuthorizationCode(
+			r.PostForm.Get("code"),
+			client.Id,
+			r.PostForm.Get("redirect_uri"),
+			r.PostForm.Get("code_verifier"),
+			cfg.secret,
+		)
+	case "refresh_token":
+		tokens, err = cfg.db.RefreshOAuthToken(r.PostForm.Get("refresh_token"), client.Id, cfg.secret)
+	default:
+		respondWithOAuthError(w, r, 400, "unsupported_grant_type", "Only authorization_code and refresh_token are supported")
+		return
+	}
+
+	if errors.Is(err, database.ErrInvalidGrant) {
+		respondWithOAuthError(w, r, 400, "invalid_grant", "The code or refresh token is invalid, expired or was issued to someone else")
+		return
+	}
+
+	if err != nil {
+		respondWithOAuthError(w, r, 500, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJson(w, 200, oauthTokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(tokens.ExpiresIn.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		Scope:        strings.Join(tokens.Scopes, " "),
+	})
+}
+
+// revokeOAuthToken follows RFC 7009, so it answers 200 even for tokens that were already invalid
+func (cfg *apiConfig) revokeOAuthToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, r, 400, "invalid_request", "Could not parse the form body")
+		return
+	}
+
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		respondWithOAuthError(w, r, 401, "invalid_client", err.Error())
+		return
+	}
+
+	err = cfg.db.RevokeOAuthToken(r.PostForm.Get("token"), client.Id, cfg.secret)
+	if errors.Is(err, database.ErrInvalidGrant) {
+		respondWithOAuthError(w, r, 400, "invalid_grant", "That token was issued to another client")
+		return
+	}
+
+	if err != nil {
+		respondWithOAuthError(w, r, 500, "server_error", "")
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
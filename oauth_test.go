@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// the example pair from RFC 7636 appendix B
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+func registerClient(t *testing.T, cfg *apiConfig, token string, confidential bool) oauthClientResponse {
+	t.Helper()
+
+	params := oauthClientParams{Name: "Test App", RedirectURIs: []string{testRedirectURI}, Confidential: confidential}
+	w := serve(cfg.registerOAuthClient, newRequest(t, http.MethodPost, "/api/oauth/clients", token, params))
+
+	return decodeBody[oauthClientResponse](t, w, 201)
+}
+
+func authorizeRequest(clientId string) authorizeParams {
+	return authorizeParams{
+		ResponseType:        "code",
+		ClientId:            clientId,
+		RedirectURI:         testRedirectURI,
+		Scope:               database.SCOPE_CHIRPS_READ,
+		State:               "xyz",
+		CodeChallenge:       testChallenge,
+		CodeChallengeMethod: "S256",
+		Approve:             true,
+	}
+}
+
+// authorizeCode approves the request and returns the query the user gets redirected back with
+func authorizeCode(t *testing.T, cfg *apiConfig, token string, params authorizeParams) url.Values {
+	t.Helper()
+
+	w := serve(cfg.authorizeClient, newRequest(t, http.MethodPost, "/api/oauth/authorize", token, params))
+	response := decodeBody[authorizationResponse](t, w, 200)
+
+	redirectTo, err := url.Parse(response.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(response.RedirectTo, testRedirectURI+"?") {
+		t.Errorf("redirected to %s, want the registered redirect_uri", response.RedirectTo)
+	}
+
+	return redirectTo.Query()
+}
+
+// oauthToken posts a form to the token endpoint, using Basic auth when there's a secret
+func oauthToken(t *testing.T, cfg *apiConfig, clientId, clientSecret string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	if clientSecret == "" {
+		form.Set("client_id", clientId)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientSecret != "" {
+		r.SetBasicAuth(clientId, clientSecret)
+	}
+
+	return serve(cfg.issueOAuthToken, r)
+}
+
+func codeExchange(code, verifier string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {verifier},
+	}
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	token := logIn(t, cfg, user.Email)
+	client := registerClient(t, cfg, token, false)
+
+	result := authorizeCode(t, cfg, token, authorizeRequest(client.ClientId))
+	if result.Get("state") != "xyz" || result.Get("code") == "" {
+		t.Fatalf("redirect query = %v, want the state and a code", result)
+	}
+
+	w := oauthToken(t, cfg, client.ClientId, "", codeExchange(result.Get("code"), testVerifier))
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("token response can be cached")
+	}
+	tokens := decodeBody[oauthTokenResponse](t, w, 200)
+	if tokens.TokenType != "Bearer" || tokens.Scope != database.SCOPE_CHIRPS_READ || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("token response = %+v", tokens)
+	}
+
+	// the token only has the approved scope
+	chirp := newRequest(t, http.MethodPost, "/api/chirps", tokens.AccessToken, map[string]string{"body": "hello"})
+	if w := serve(cfg.chirpValidationHandler, chirp); w.Code != 403 {
+		t.Errorf("chirping with a chirps:read token = %d, want 403", w.Code)
+	}
+	read := newRequest(t, http.MethodGet, "/api/chirps", tokens.AccessToken, nil)
+	if w := serve(cfg.getAllChirps, read); w.Code != 200 {
+		t.Errorf("reading chirps with a chirps:read token = %d, want 200", w.Code)
+	}
+
+	// and can't be used to manage the user's credentials
+	if w := serve(cfg.registerOAuthClient, newRequest(t, http.MethodPost, "/api/oauth/clients", tokens.AccessToken, oauthClientParams{})); w.Code != 401 {
+		t.Errorf("registering an app with a third-party token = %d, want 401", w.Code)
+	}
+
+	w = oauthToken(t, cfg, client.ClientId, "", codeExchange(result.Get("code"), testVerifier))
+	if oauthErr := decodeBody[oauthError](t, w, 400); oauthErr.Error != "invalid_grant" {
+		t.Errorf("reusing a code = %+v, want invalid_grant", oauthErr)
+	}
+
+	w = oauthToken(t, cfg, client.ClientId, "", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}})
+	refreshed := decodeBody[oauthTokenResponse](t, w, 200)
+	if refreshed.AccessToken == "" || refreshed.Scope != database.SCOPE_CHIRPS_READ {
+		t.Errorf("refresh response = %+v", refreshed)
+	}
+}
+
+func TestOAuthRejectsWrongVerifier(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	token := logIn(t, cfg, user.Email)
+	client := registerClient(t, cfg, token, false)
+
+	result := authorizeCode(t, cfg, token, authorizeRequest(client.ClientId))
+
+	w := oauthToken(t, cfg, client.ClientId, "", codeExchange(result.Get("code"), strings.Repeat("a", 43)))
+	if oauthErr := decodeBody[oauthError](t, w, 400); oauthErr.Error != "invalid_grant" {
+		t.Errorf("exchanging with the wrong verifier = %+v, want invalid_grant", oauthErr)
+	}
+}
+
+func TestOAuthAuthorizeValidation(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	token := logIn(t, cfg, user.Email)
+	client := registerClient(t, cfg, token, false)
+
+	tests := []struct {
+		name   string
+		change func(*authorizeParams)
+	}{
+		{"unknown client", func(p *authorizeParams) { p.ClientId = "nope" }},
+		{"unregistered redirect_uri", func(p *authorizeParams) { p.RedirectURI = "https://evil.example.com/callback" }},
+		{"token response_type", func(p *authorizeParams) { p.ResponseType = "token" }},
+		{"no code_challenge", func(p *authorizeParams) { p.CodeChallenge = "" }},
+		{"plain code_challenge_method", func(p *authorizeParams) { p.CodeChallengeMethod = "plain" }},
+		{"unknown scope", func(p *authorizeParams) { p.Scope = "admin" }},
+	}
+
+	for _, tt := range tests {
+		params := authorizeRequest(client.ClientId)
+		tt.change(&params)
+
+		w := serve(cfg.authorizeClient, newRequest(t, http.MethodPost, "/api/oauth/authorize", token, params))
+		if w.Code != 400 {
+			t.Errorf("%s: authorize = %d, want 400", tt.name, w.Code)
+		}
+	}
+
+	params := authorizeRequest(client.ClientId)
+	w := serve(cfg.authorizeClient, newRequest(t, http.MethodPost, "/api/oauth/authorize", "", params))
+	if w.Code != 401 {
+		t.Errorf("authorizing while logged out = %d, want 401", w.Code)
+	}
+
+	params.Approve = false
+	result := authorizeCode(t, cfg, token, params)
+	if result.Get("error") != "access_denied" || result.Get("code") != "" || result.Get("state") != "xyz" {
+		t.Errorf("denying = %v, want access_denied with the state and no code", result)
+	}
+}
+
+func TestOAuthConfidentialClientNeedsSecret(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	token := logIn(t, cfg, user.Email)
+	client := registerClient(t, cfg, token, true)
+	if client.ClientSecret == "" {
+		t.Fatal("a confidential client wasn't given a secret")
+	}
+
+	result := authorizeCode(t, cfg, token, authorizeRequest(client.ClientId))
+
+	w := oauthToken(t, cfg, client.ClientId, "", codeExchange(result.Get("code"), testVerifier))
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("invalid_client response has no WWW-Authenticate header")
+	}
+	if oauthErr := decodeBody[oauthError](t, w, 401); oauthErr.Error != "invalid_client" {
+		t.Errorf("exchanging without the secret = %+v, want invalid_client", oauthErr)
+	}
+
+	w = oauthToken(t, cfg, client.ClientId, client.ClientSecret, codeExchange(result.Get("code"), testVerifier))
+	decodeBody[oauthTokenResponse](t, w, 200)
+}
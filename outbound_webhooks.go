@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// webhookEndpointResponse is an endpoint without its signing secret
+type webhookEndpointResponse struct {
+	Id        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+	// Secret is only ever filled in when the endpoint is first created
+	Secret string `json:"secret,omitempty"`
+}
+
+func toWebhookEndpointResponse(endpoint database.WebhookEndpoint) webhookEndpointResponse {
+	return webhookEndpointResponse{
+		Id:        endpoint.Id,
+		URL:       endpoint.URL,
+		Events:    endpoint.Events,
+		CreatedAt: endpoint.CreatedAt,
+	}
+}
+
+func (cfg *apiConfig) getWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to manage webhooks")
+		return
+	}
+
+	endpoints, err := cfg.db.GetWebhookEndpoints(userId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	response := make([]webhookEndpointResponse, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		response = append(response, toWebhookEndpointResponse(endpoint))
+	}
+
+	respondWithJson(w, 200, response)
+}
+
+type createWebhookParams struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func (cfg *apiConfig) createWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to manage webhooks")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := createWebhookParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	fieldErrors := []fieldError{}
+	if len(params.Events) == 0 {
+		fieldErrors = append(fieldErrors, requiredField("events", fmt.Sprintf("Webhooks need at least one event out of %v", database.AllWebhookEvents)))
+	}
+	for _, event := range params.Events {
+		if !database.ValidWebhookEvent(event) {
+			fieldErrors = append(fieldErrors, unknownValue("events", "event", event, database.AllWebhookEvents))
+		}
+	}
+	if len(fieldErrors) > 0 {
+		respondWithFieldErrors(w, r, fieldErrors...)
+		return
+	}
+
+	endpoint, err := cfg.db.CreateWebhookEndpoint(userId, params.URL, params.Events)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	response := toWebhookEndpointResponse(endpoint)
+	response.Secret = endpoint.Secret
+
+	respondWithJson(w, 201, response)
+}
+
+func (cfg *apiConfig) deleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to manage webhooks")
+		return
+	}
+
+	param := chi.URLParam(r, "webhookId")
+	endpointId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	err = cfg.db.DeleteWebhookEndpoint(userId, endpointId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
+
+func (cfg *apiConfig) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.authenticatedUserId(r)
+	if err != nil {
+		respondWithError(w, r, 401, "You need to be logged in to manage webhooks")
+		return
+	}
+
+	param := chi.URLParam(r, "webhookId")
+	endpointId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	_, exists, err := cfg.db.GetWebhookEndpoint(userId, endpointId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+	if !exists {
+		respondWithError(w, r, 404, fmt.Sprintf("Unable to find webhook with ID: %s", param))
+		return
+	}
+
+	deliveries, err := cfg.db.GetWebhookDeliveries(endpointId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, deliveries)
+}
+
+// publishEvent queues an event for the given users' webhooks
+// Delivery is best effort, so failing to queue is logged rather than failing the request
+func (cfg *apiConfig) publishEvent(ctx context.Context, eventType string, data any, userIds ...int) {
+	err := cfg.webhooks.Publish(eventType, data, userIds...)
+	if err != nil {
+		loggerFrom(ctx).Error("Error queueing webhooks", "event", eventType, "error", err)
+	}
+}
+
+// mentionPattern matches "@someone@example.com" style mentions in a chirp
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([^\s@]+@[^\s@]+\.[^\s@.,!?;:]+)`)
+
+// mentionedUsers returns the ids of everyone a chirp mentions, apart from its author
+// and anyone who has blocked them
+func (cfg *apiConfig) mentionedUsers(ctx context.Context, chirp database.Chirp) []int {
+	blockers, err := cfg.db.GetBlockers(chirp.AuthorId)
+	if err != nil {
+		loggerFrom(ctx).Error("Error looking up blocks", "user_id", chirp.AuthorId, "error", err)
+		return []int{}
+	}
+
+	seen := map[int]bool{}
+	ids := []int{}
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(chirp.Body, -1) {
+		user, exists, err := cfg.db.GetUserByEmail(match[1])
+		if err != nil || !exists || user.Id == chirp.AuthorId || seen[user.Id] || blockers[user.Id] {
+			continue
+		}
+
+		seen[user.Id] = true
+		ids = append(ids, user.Id)
+	}
+
+	return ids
+}
+
+type mentionEvent struct {
+	Chirp       database.Chirp `json:"chirp"`
+	MentionedBy int            `json:"mentioned_by"`
+}
+
+// publishChirpCreated lets the author know their chirp went out, and anyone it mentions that they were
+// Nobody else hears about chirps from shadow banned users
+func (cfg *apiConfig) publishChirpCreated(ctx context.Context, chirp database.Chirp) {
+	cfg.publishEvent(ctx, database.EVENT_CHIRP_CREATED, chirp, chirp.AuthorId)
+
+	if cfg.shadowBanned(ctx, chirp.AuthorId) {
+		return
+	}
+
+	mentioned := cfg.mentionedUsers(ctx, chirp)
+	if len(mentioned) > 0 {
+		cfg.publishEvent(ctx, database.EVENT_USER_MENTIONED, mentionEvent{Chirp: chirp, MentionedBy: chirp.AuthorId}, mentioned...)
+	}
+}
+
+func (cfg *apiConfig) shadowBanned(ctx context.Context, userId int) bool {
+	user, _, err := cfg.db.GetUserById(userId)
+	if err != nil {
+		loggerFrom(ctx).Error("Error looking up user", "user_id", userId, "error", err)
+		return false
+	}
+
+	return user.ShadowBanned()
+}
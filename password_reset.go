@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/mailer"
+)
+
+type passwordResetParams struct {
+	Email string `json:"email"`
+}
+
+// requestPasswordReset always answers the same way whether or not the email exists,
+// so it can't be used to find out who has an account
+func (cfg *apiConfig) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	params := passwordResetParams{}
+
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	user, exists, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if exists {
+		token, err := cfg.db.CreatePasswordReset(user.Id)
+		if err != nil {
+			respondWithErrorFrom(w, r, err)
+			return
+		}
+
+		err = cfg.mailer.Send(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Chirpy password",
+			Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+				"If that was you, send this token to %s/api/password-reset/confirm along with your new password:\n\n%s\n\n"+
+				"It expires in %v. If it wasn't you, you can ignore this email.",
+				cfg.publicURL, token, database.PASSWORD_RESET_LIFETIME),
+		})
+		// failing here would give away that the email has an account, so it's only logged
+		if err != nil {
+			loggerFrom(r.Context()).Error("Error sending password reset email", "user_id", user.Id, "error", err)
+		}
+	}
+
+	respondWithJson(w, 202, nil)
+}
+
+type passwordResetConfirmParams struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (cfg *apiConfig) confirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	params := passwordResetConfirmParams{}
+
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	if passwordErr, ok := passwordProblem(params.Password); !ok {
+		respondWithFieldErrors(w, r, passwordErr)
+		return
+	}
+
+	err = cfg.db.ResetPassword(params.Token, params.Password)
+	if errors.Is(err, database.ErrInvalid) {
+		respondWithCode(w, r, 400, "invalid_token", "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+func requestReset(t *testing.T, cfg *apiConfig, email string) int {
+	t.Helper()
+
+	r := newRequest(t, http.MethodPost, "/api/password-reset", "", passwordResetParams{Email: email})
+	return serve(cfg.requestPasswordReset, r).Code
+}
+
+func TestRequestPasswordResetDoesNotRevealAccounts(t *testing.T) {
+	cfg, outbox := newTestAPI(t)
+	signUp(t, cfg, "walt@example.com")
+
+	if code := requestReset(t, cfg, "walt@example.com"); code != 202 {
+		t.Errorf("resetting an account = %d, want 202", code)
+	}
+	outbox.last(t, "walt@example.com")
+
+	if code := requestReset(t, cfg, "nobody@example.com"); code != 202 {
+		t.Errorf("resetting a missing account = %d, want 202", code)
+	}
+
+	// a broken mailer mustn't give away which emails have accounts either
+	outbox.err = errors.New("mail server is down")
+	if code := requestReset(t, cfg, "walt@example.com"); code != 202 {
+		t.Errorf("resetting an account with the mailer failing = %d, want 202", code)
+	}
+}
+
+func TestChangingPasswordLogsOut(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "walt@example.com")
+	token := logIn(t, cfg, user.Email)
+
+	w := serve(cfg.updateUser, newRequest(t, http.MethodPut, "/api/users", token, database.EditingUser{Email: user.Email}))
+	decodeBody[editedUserResponse](t, w, 200)
+
+	// only a password change logs out
+	w = serve(cfg.updateUser, newRequest(t, http.MethodPut, "/api/users", token, database.EditingUser{Password: "new password"}))
+	decodeBody[editedUserResponse](t, w, 200)
+
+	w = serve(cfg.updateUser, newRequest(t, http.MethodPut, "/api/users", token, database.EditingUser{Email: user.Email}))
+	if w.Code != 401 {
+		t.Errorf("using a token from before the password changed = %d, want 401", w.Code)
+	}
+}
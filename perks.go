@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// perks are the limits and extras that depend on a user's tier
+type perks struct {
+	MaxChirpLength int
+	// EditWindow is how long after posting a chirp can still be edited, zero means never
+	EditWindow    time.Duration
+	ChirpsPerHour int
+	// Badge is shown on the user's profile, empty means no badge
+	Badge string
+}
+
+const tierFree = "free"
+const tierChirpyRed = "chirpy_red"
+
+// perkMatrix is the one place that decides what each tier gets
+var perkMatrix = map[string]perks{
+	tierFree: {
+		MaxChirpLength: 140,
+		EditWindow:     0,
+		ChirpsPerHour:  30,
+		Badge:          "",
+	},
+	tierChirpyRed: {
+		MaxChirpLength: 500,
+		EditWindow:     15 * time.Minute,
+		ChirpsPerHour:  300,
+		Badge:          "chirpy_red",
+	},
+}
+
+func tierFor(user database.AuthenticatedUser) string {
+	if user.IsChirpyRed() {
+		return tierChirpyRed
+	}
+
+	return tierFree
+}
+
+func perksFor(user database.AuthenticatedUser) perks {
+	return perkMatrix[tierFor(user)]
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// signUpRed creates a user with Chirpy Red and returns them with an access token
+func signUpRed(t *testing.T, cfg *apiConfig, email string) (database.User, string) {
+	t.Helper()
+
+	user := signUp(t, cfg, email)
+
+	err := cfg.db.UpgradeUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, logIn(t, cfg, email)
+}
+
+func editChirp(t *testing.T, cfg *apiConfig, token string, chirpId int, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	id := strconv.Itoa(chirpId)
+	r := newRequest(t, http.MethodPut, "/api/chirps/"+id, token, map[string]string{"body": body})
+	r = withURLParams(r, map[string]string{"chirpId": id})
+
+	return serve(cfg.editChirp, r)
+}
+
+func TestChirpLengthDependsOnTier(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	free := signUp(t, cfg, "free@example.com")
+	freeToken := logIn(t, cfg, free.Email)
+	_, redToken := signUpRed(t, cfg, "red@example.com")
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"free at the limit", freeToken, strings.Repeat("a", 140), 201},
+		{"free over the limit", freeToken, strings.Repeat("a", 141), 400},
+		{"free counts characters, not bytes", freeToken, strings.Repeat("é", 140), 201},
+		{"red over the free limit", redToken, strings.Repeat("a", 500), 201},
+		{"red over the red limit", redToken, strings.Repeat("a", 501), 400},
+	}
+
+	for _, tt := range tests {
+		if w := postChirp(t, cfg, tt.token, tt.body); w.Code != tt.want {
+			t.Errorf("%s: chirping = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestChirpsPerHour(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "free@example.com")
+	token := logIn(t, cfg, user.Email)
+
+	limit := perkMatrix[tierFree].ChirpsPerHour
+	for i := 0; i < limit; i++ {
+		if w := postChirp(t, cfg, token, "chirp "+strconv.Itoa(i)); w.Code != 201 {
+			t.Fatalf("chirp %d = %d, want 201", i+1, w.Code)
+		}
+	}
+
+	w := postChirp(t, cfg, token, "one too many")
+	if w.Code != 429 {
+		t.Fatalf("chirp %d = %d, want 429", limit+1, w.Code)
+	}
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 3600 {
+		t.Errorf("Retry-After = %q, want up to an hour", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestEditingChirpsIsARedPerk(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	free := signUp(t, cfg, "free@example.com")
+	freeToken := logIn(t, cfg, free.Email)
+	_, redToken := signUpRed(t, cfg, "red@example.com")
+
+	freeChirp := decodeBody[database.Chirp](t, postChirp(t, cfg, freeToken, "free chirp"), 201)
+	redChirp := decodeBody[database.Chirp](t, postChirp(t, cfg, redToken, "red chirp"), 201)
+
+	if w := editChirp(t, cfg, freeToken, freeChirp.Id, "edited"); w.Code != 403 {
+		t.Errorf("a free user editing = %d, want 403", w.Code)
+	}
+	if w := editChirp(t, cfg, redToken, freeChirp.Id, "edited"); w.Code != 403 {
+		t.Errorf("editing someone else's chirp = %d, want 403", w.Code)
+	}
+	if w := editChirp(t, cfg, redToken, 999, "edited"); w.Code != 404 {
+		t.Errorf("editing a missing chirp = %d, want 404", w.Code)
+	}
+
+	edited := decodeBody[database.Chirp](t, editChirp(t, cfg, redToken, redChirp.Id, "edited"), 200)
+	if edited.Body != "edited" || edited.EditedAt == nil {
+		t.Errorf("edited chirp = %+v, want the new body and an edit time", edited)
+	}
+}
+
+func TestProfileBadge(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	free := signUp(t, cfg, "free@example.com")
+	red, _ := signUpRed(t, cfg, "red@example.com")
+
+	profile := func(userId int) *httptest.ResponseRecorder {
+		id := strconv.Itoa(userId)
+		r := withURLParams(newRequest(t, http.MethodGet, "/api/users/"+id, "", nil), map[string]string{"userId": id})
+		return serve(cfg.getUserProfile, r)
+	}
+
+	tests := []struct {
+		user  database.User
+		red   bool
+		badge string
+	}{
+		{free, false, ""},
+		{red, true, "chirpy_red"},
+	}
+
+	for _, tt := range tests {
+		got := decodeBody[profileResponse](t, profile(tt.user.Id), 200)
+		if got.IsChirpyRed != tt.red || got.Badge != tt.badge {
+			t.Errorf("profile of %s = %+v, want red %v and badge %q", tt.user.Email, got, tt.red, tt.badge)
+		}
+	}
+
+	if w := profile(999); w.Code != 404 {
+		t.Errorf("profile of a missing user = %d, want 404", w.Code)
+	}
+}
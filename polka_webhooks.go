@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/polka"
+)
+
+const maxWebhookBytes = 1 << 20
+
+const polkaSource = "polka"
+
+type polkaEvent struct {
+	Id    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserId int `json:"user_id"`
+	} `json:"data"`
+}
+
+// errUnknownUser means a webhook was about a user we don't have
+var errUnknownUser = errors.New("could not find user for subscription event")
+
+// polkaSubscriptionEvents maps each Polka event we handle to the change it makes to a subscription
+var polkaSubscriptionEvents = map[string]func(db *database.DB, userId int) error{
+	"user.upgraded":       (*database.DB).UpgradeUser,
+	"user.renewed":        (*database.DB).RenewSubscription,
+	"user.downgraded":     (*database.DB).DowngradeUser,
+	"user.refunded":       (*database.DB).RefundSubscription,
+	"user.payment_failed": (*database.DB).SubscriptionPaymentFailed,
+}
+
+func (cfg *apiConfig) handlePayment(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		respondWithError(w, r, 400, "Something went wrong reading request body")
+		return
+	}
+
+	err = cfg.polka.Verify(r.Header, body)
+	if err != nil {
+		cfg.metrics.webhooksReceived.Inc(polkaSource, "rejected")
+		respondWithCode(w, r, 401, "invalid_signature", err.Error())
+		return
+	}
+
+	params := polkaEvent{}
+
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	// events without an id can legitimately repeat (upgraded, cancelled, upgraded again), so only a replay
+	// of the same signed delivery is caught, keyed on the timestamp that went into its signature
+	eventId := params.Id
+	if eventId == "" {
+		sum := sha256.Sum256([]byte(strings.TrimSpace(r.Header.Get(polka.TimestampHeader)) + "." + string(body)))
+		eventId = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	event, needsProcessing, err := cfg.db.RecordWebhookEvent(polkaSource, eventId, params.Event, body)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if !needsProcessing {
+		cfg.metrics.webhooksReceived.Inc(polkaSource, "duplicate")
+		respondWithJson(w, 200, nil)
+		return
+	}
+
+	event, err = cfg.processWebhookEvent(event)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if event.Status == database.WEBHOOK_FAILED {
+		respondWithError(w, r, 404, event.Error)
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
+
+// processWebhookEvent acts on a stored event and records how it went
+func (cfg *apiConfig) processWebhookEvent(event database.WebhookEvent) (database.WebhookEvent, error) {
+	status, processingErr := cfg.applyPolkaEvent(event.Payload)
+	cfg.metrics.webhooksReceived.Inc(event.Source, status)
+
+	return cfg.db.FinishWebhookEvent(event.Id, status, processingErr)
+}
+
+// applyPolkaEvent makes the changes a Polka event asks for, returning the status to record
+func (cfg *apiConfig) applyPolkaEvent(payload []byte) (string, error) {
+	params := polkaEvent{}
+
+	err := json.Unmarshal(payload, &params)
+	if err != nil {
+		return database.WEBHOOK_FAILED, err
+	}
+
+	apply, handled := polkaSubscriptionEvents[params.Event]
+	if !handled {
+		return database.WEBHOOK_IGNORED, nil
+	}
+
+	_, exists, err := cfg.db.GetUserById(params.Data.UserId)
+	if err != nil {
+		return database.WEBHOOK_FAILED, err
+	}
+
+	if !exists {
+		return database.WEBHOOK_FAILED, errUnknownUser
+	}
+
+	err = apply(cfg.db, params.Data.UserId)
+	if err != nil {
+		return database.WEBHOOK_FAILED, err
+	}
+
+	return database.WEBHOOK_PROCESSED, nil
+}
+
+func (cfg *apiConfig) getWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := cfg.db.GetWebhookEvents(r.URL.Query().Get("status"))
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, events)
+}
+
+// replayWebhookEvent processes a stored event again, whatever happened to it the first time
+func (cfg *apiConfig) replayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	eventId := chi.URLParam(r, "eventId")
+
+	event, exists, err := cfg.db.GetWebhookEvent(eventId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if !exists {
+		respondWithError(w, r, 404, fmt.Sprintf("Unable to find webhook event with ID: %s", eventId))
+		return
+	}
+
+	event, err = cfg.processWebhookEvent(event)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, event)
+}
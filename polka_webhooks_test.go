@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thegouge/go-chirpy/internal/polka"
+)
+
+const testPolkaKey = "test-polka-key"
+
+// newPolkaAPI returns an apiConfig that accepts webhooks signed with testPolkaKey
+func newPolkaAPI(t *testing.T) *apiConfig {
+	t.Helper()
+
+	cfg, _ := newTestAPI(t)
+	cfg.polka = polka.NewVerifier(testPolkaKey)
+	cfg.metrics = newServerMetrics(cfg.db, &cfg.fileserverHits)
+
+	return cfg
+}
+
+// sendPolkaEvent delivers body signed as if Polka sent it at sentAt
+func sendPolkaEvent(t *testing.T, cfg *apiConfig, body string, sentAt time.Time) int {
+	t.Helper()
+
+	timestamp := sentAt.Unix()
+	r := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+	r.Header.Set(polka.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(polka.SignatureHeader, polka.Sign(testPolkaKey, timestamp, []byte(body)))
+
+	return serve(cfg.handlePayment, r).Code
+}
+
+func isRed(t *testing.T, cfg *apiConfig, userId int) bool {
+	t.Helper()
+
+	user, _, err := cfg.db.GetUserById(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user.IsChirpyRed()
+}
+
+func TestPolkaEventsWithIdsAreOnlyAppliedOnce(t *testing.T) {
+	cfg := newPolkaAPI(t)
+	user := signUp(t, cfg, "walt@example.com")
+	now := time.Now()
+
+	upgraded := fmt.Sprintf(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":%d}}`, user.Id)
+	if code := sendPolkaEvent(t, cfg, upgraded, now); code != 200 {
+		t.Fatalf("upgrading = %d, want 200", code)
+	}
+
+	downgraded := fmt.Sprintf(`{"id":"evt_2","event":"user.downgraded","data":{"user_id":%d}}`, user.Id)
+	if code := sendPolkaEvent(t, cfg, downgraded, now); code != 200 {
+		t.Fatalf("downgrading = %d, want 200", code)
+	}
+
+	// Polka retrying the upgrade, re-signed, mustn't undo the downgrade
+	if code := sendPolkaEvent(t, cfg, upgraded, now.Add(time.Second)); code != 200 {
+		t.Fatalf("retrying the upgrade = %d, want 200", code)
+	}
+	if isRed(t, cfg, user.Id) {
+		t.Error("a retried upgrade was applied again after the downgrade")
+	}
+}
+
+func TestPolkaEventsWithoutIdsCanRepeat(t *testing.T) {
+	cfg := newPolkaAPI(t)
+	user := signUp(t, cfg, "walt@example.com")
+	sentAt := time.Now().Add(-time.Minute)
+
+	upgraded := fmt.Sprintf(`{"event":"user.upgraded","data":{"user_id":%d}}`, user.Id)
+	downgraded := fmt.Sprintf(`{"event":"user.downgraded","data":{"user_id":%d}}`, user.Id)
+
+	// upgrade, cancel, then upgrade again, twice over with the same bodies each time
+	for round := 1; round <= 2; round++ {
+		for _, step := range []struct {
+			body string
+			red  bool
+		}{
+			{upgraded, true},
+			{downgraded, false},
+			{upgraded, true},
+		} {
+			sentAt = sentAt.Add(time.Second)
+			if code := sendPolkaEvent(t, cfg, step.body, sentAt); code != 200 {
+				t.Fatalf("round %d: sending %s = %d, want 200", round, step.body, code)
+			}
+			if got := isRed(t, cfg, user.Id); got != step.red {
+				t.Errorf("round %d: after %s Chirpy Red = %v, want %v", round, step.body, got, step.red)
+			}
+		}
+
+		sentAt = sentAt.Add(time.Second)
+		if code := sendPolkaEvent(t, cfg, downgraded, sentAt); code != 200 {
+			t.Fatalf("round %d: cancelling = %d, want 200", round, code)
+		}
+	}
+
+	// replaying a delivery exactly as it was signed is still caught
+	events, err := cfg.db.GetWebhookEvents("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(events)
+
+	if code := sendPolkaEvent(t, cfg, upgraded, sentAt.Add(-time.Second)); code != 200 {
+		t.Fatalf("replaying a delivery = %d, want 200", code)
+	}
+	if isRed(t, cfg, user.Id) {
+		t.Error("a replayed delivery was applied again")
+	}
+
+	events, err = cfg.db.GetWebhookEvents("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != before {
+		t.Errorf("got %d events after a replay, want %d", len(events), before)
+	}
+}
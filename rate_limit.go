@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thegouge/go-chirpy/internal/ratelimit"
+)
+
+// each policy is its own set of buckets, so hitting one limit doesn't use up another
+var (
+	// apiRateLimit applies to the whole API
+	apiRateLimit = ratelimit.Policy{Name: "api", Limit: 600, Window: time.Minute}
+	// chirpRateLimit stops bursts of chirps, on top of the hourly limit in perkMatrix
+	chirpRateLimit = ratelimit.Policy{Name: "chirps", Limit: 20, Window: time.Minute, Burst: 10}
+	// signupRateLimit is per IP since nobody signing up is logged in yet
+	signupRateLimit = ratelimit.Policy{Name: "signup", Limit: 10, Window: time.Hour}
+	// authRateLimit covers logging in and anything that sends an email or checks a secret
+	authRateLimit = ratelimit.Policy{Name: "auth", Limit: 20, Window: time.Minute, Burst: 10}
+)
+
+// rateLimit limits requests by the logged in user, as found by middlewareAuthenticate, or by IP
+// for anyone else. With limiting turned off it does nothing, so routes can use it either way
+func (cfg *apiConfig) rateLimit(policy ratelimit.Policy) func(http.Handler) http.Handler {
+	limiter := ratelimit.NewLimiter(policy)
+
+	return func(next http.Handler) http.Handler {
+		if !cfg.rateLimitEnabled {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + clientIP(r)
+			if caller, err := cfg.authenticate(r); err == nil {
+				key = fmt.Sprintf("user:%d", caller.UserId)
+			}
+
+			decision := limiter.Allow(key)
+			setRateLimitHeaders(w, policy, decision)
+
+			if !decision.Allowed {
+				retryAfter := ceilSeconds(decision.RetryAfter)
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				respondWithError(w, r, 429, fmt.Sprintf("Too many requests, try again in %d seconds", retryAfter))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setRateLimitHeaders describes whichever limit the client is closest to,
+// since a route can be covered by more than one
+func setRateLimitHeaders(w http.ResponseWriter, policy ratelimit.Policy, decision ratelimit.Decision) {
+	if current, err := strconv.Atoi(w.Header().Get("RateLimit-Remaining")); err == nil && current <= decision.Remaining {
+		return
+	}
+
+	w.Header().Set("RateLimit-Policy", policy.String())
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
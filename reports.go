@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+type reportParams struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+func (cfg *apiConfig) reportChirp(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_CHIRPS_WRITE)
+	if !ok {
+		return
+	}
+
+	param := chi.URLParam(r, "chirpId")
+	chirpID, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := reportParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	if !database.ValidReportReason(params.Reason) {
+		respondWithFieldErrors(w, r, unknownValue("reason", "reason", params.Reason, database.AllReportReasons))
+		return
+	}
+
+	chirp, exists, err := cfg.db.GetChirp(chirpID)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	visibility, err := cfg.chirpVisibilityFor(&caller)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if !exists || !visibility.canSee(chirp) {
+		respondWithError(w, r, 404, fmt.Sprintf("Unable to find chirp with ID: %s", param))
+		return
+	}
+
+	report, err := cfg.db.ReportChirp(caller.UserId, chirpID, params.Reason, params.Details)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 201, report)
+}
+
+// reportFlaggedChirp puts a chirp moderation flagged into the moderation queue
+func (cfg *apiConfig) reportFlaggedChirp(ctx context.Context, chirp database.Chirp) {
+	if len(chirp.Flags) == 0 {
+		return
+	}
+
+	_, err := cfg.db.ReportChirp(0, chirp.Id, database.REPORT_AUTOMOD, strings.Join(chirp.Flags, ", "))
+	if err != nil && !errors.Is(err, database.ErrAlreadyReported) {
+		loggerFrom(ctx).Error("Error reporting flagged chirp", "chirp_id", chirp.Id, "error", err)
+	}
+}
+
+func (cfg *apiConfig) getModerationQueue(w http.ResponseWriter, r *http.Request) {
+	queue, err := cfg.db.GetModerationQueue()
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, queue)
+}
+
+type reviewParams struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+}
+
+// reviewChirp lets a moderator hide, unhide, delete or dismiss the reports on a chirp
+func (cfg *apiConfig) reviewChirp(w http.ResponseWriter, r *http.Request) {
+	param := chi.URLParam(r, "chirpId")
+	chirpID, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := reviewParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	if !database.ValidModerationAction(params.Action) {
+		respondWithFieldErrors(w, r, unknownValue("action", "action", params.Action, database.AllModerationActions))
+		return
+	}
+
+	moderator, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := cfg.db.ModerateChirp(moderator.UserId, chirpID, params.Action, params.Note)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	if params.Action == database.MODERATION_DELETE {
+		cfg.publishEvent(r.Context(), database.EVENT_CHIRP_DELETED, chirpDeletedEvent{Id: chirpID, AuthorId: entry.TargetUserId}, entry.TargetUserId)
+	}
+
+	respondWithJson(w, 200, entry)
+}
+
+func (cfg *apiConfig) getModerationLog(w http.ResponseWriter, r *http.Request) {
+	filters := map[string]int{"moderator_id": 0, "user_id": 0}
+	for name := range filters {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Invalid %s: %q", name, raw))
+			return
+		}
+		filters[name] = id
+	}
+
+	entries, err := cfg.db.GetModerationLog(filters["moderator_id"], filters["user_id"])
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, entries)
+}
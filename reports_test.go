@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+func reportChirp(t *testing.T, cfg *apiConfig, token string, chirpId int, reason string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	id := strconv.Itoa(chirpId)
+	r := newRequest(t, http.MethodPost, "/api/chirps/"+id+"/report", token, reportParams{Reason: reason})
+
+	return serve(cfg.reportChirp, withURLParams(r, map[string]string{"chirpId": id}))
+}
+
+func reviewChirp(t *testing.T, cfg *apiConfig, token string, chirpId int, action string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	id := strconv.Itoa(chirpId)
+	r := newRequest(t, http.MethodPost, "/api/moderation/chirps/"+id, token, reviewParams{Action: action, Note: "reviewed"})
+
+	return serveWithRole(cfg, cfg.reviewChirp, withURLParams(r, map[string]string{"chirpId": id}), database.ROLE_MODERATOR, database.ROLE_ADMIN)
+}
+
+func getChirp(t *testing.T, cfg *apiConfig, token string, chirpId int) *httptest.ResponseRecorder {
+	t.Helper()
+
+	id := strconv.Itoa(chirpId)
+	r := newRequest(t, http.MethodGet, "/api/chirps/"+id, token, nil)
+
+	return serve(cfg.getChirpByID, withURLParams(r, map[string]string{"chirpId": id}))
+}
+
+func listChirps(t *testing.T, cfg *apiConfig, token string, query string) []database.Chirp {
+	t.Helper()
+
+	w := serve(cfg.getAllChirps, newRequest(t, http.MethodGet, "/api/chirps"+query, token, nil))
+	return decodeBody[[]database.Chirp](t, w, 200)
+}
+
+func TestReportChirp(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	author := signUp(t, cfg, "author@example.com")
+	authorToken := logIn(t, cfg, author.Email)
+	reporterToken := logIn(t, cfg, signUp(t, cfg, "reporter@example.com").Email)
+
+	chirp := decodeBody[database.Chirp](t, postChirp(t, cfg, authorToken, "hello"), 201)
+
+	tests := []struct {
+		name    string
+		token   string
+		chirpId int
+		reason  string
+		want    int
+	}{
+		{"logged out", "", chirp.Id, database.REPORT_SPAM, 401},
+		{"unknown reason", reporterToken, chirp.Id, "boring", 400},
+		{"automod reason", reporterToken, chirp.Id, database.REPORT_AUTOMOD, 400},
+		{"missing chirp", reporterToken, 999, database.REPORT_SPAM, 404},
+		{"own chirp", authorToken, chirp.Id, database.REPORT_SPAM, 400},
+		{"first report", reporterToken, chirp.Id, database.REPORT_SPAM, 201},
+		{"second report", reporterToken, chirp.Id, database.REPORT_HATE, 409},
+	}
+
+	for _, tt := range tests {
+		if w := reportChirp(t, cfg, tt.token, tt.chirpId, tt.reason); w.Code != tt.want {
+			t.Errorf("%s: reporting = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestModerationQueueAndReview(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	author := signUp(t, cfg, "author@example.com")
+	authorToken := logIn(t, cfg, author.Email)
+	reporterToken := logIn(t, cfg, signUp(t, cfg, "reporter@example.com").Email)
+	moderator, moderatorToken := signUpWithRole(t, cfg, "moderator@example.com", database.ROLE_MODERATOR)
+	_, adminToken := signUpAdmin(t, cfg, "admin@example.com")
+
+	chirp := decodeBody[database.Chirp](t, postChirp(t, cfg, authorToken, "reported"), 201)
+	if w := reportChirp(t, cfg, reporterToken, chirp.Id, database.REPORT_SPAM); w.Code != 201 {
+		t.Fatalf("reporting = %d, want 201", w.Code)
+	}
+
+	queueRequest := func(token string) *httptest.ResponseRecorder {
+		r := newRequest(t, http.MethodGet, "/api/moderation/queue", token, nil)
+		return serveWithRole(cfg, cfg.getModerationQueue, r, database.ROLE_MODERATOR, database.ROLE_ADMIN)
+	}
+
+	if w := queueRequest(reporterToken); w.Code != 403 {
+		t.Errorf("a user reading the queue = %d, want 403", w.Code)
+	}
+	queue := decodeBody[[]database.QueuedChirp](t, queueRequest(moderatorToken), 200)
+	if len(queue) != 1 || queue[0].Chirp.Id != chirp.Id || len(queue[0].Reports) != 1 {
+		t.Fatalf("queue = %+v, want the reported chirp", queue)
+	}
+
+	if w := reviewChirp(t, cfg, reporterToken, chirp.Id, database.MODERATION_HIDE); w.Code != 403 {
+		t.Errorf("a user reviewing = %d, want 403", w.Code)
+	}
+	if w := reviewChirp(t, cfg, moderatorToken, chirp.Id, "ban"); w.Code != 400 {
+		t.Errorf("an unknown action = %d, want 400", w.Code)
+	}
+	if w := reviewChirp(t, cfg, moderatorToken, 999, database.MODERATION_HIDE); w.Code != 404 {
+		t.Errorf("reviewing a missing chirp = %d, want 404", w.Code)
+	}
+
+	entry := decodeBody[database.ModerationAction](t, reviewChirp(t, cfg, moderatorToken, chirp.Id, database.MODERATION_HIDE), 200)
+	if entry.ModeratorId != moderator.Id || entry.TargetUserId != author.Id || len(entry.ReportIds) != 1 {
+		t.Errorf("audit entry = %+v", entry)
+	}
+
+	if queue := decodeBody[[]database.QueuedChirp](t, queueRequest(moderatorToken), 200); len(queue) != 0 {
+		t.Errorf("queue after review = %+v, want it empty", queue)
+	}
+
+	// the audit trail is admin only
+	logRequest := func(token string, query string) *httptest.ResponseRecorder {
+		r := newRequest(t, http.MethodGet, "/admin/moderation/log"+query, token, nil)
+		return serveWithRole(cfg, cfg.getModerationLog, r, database.ROLE_ADMIN)
+	}
+
+	if w := logRequest(moderatorToken, ""); w.Code != 403 {
+		t.Errorf("a moderator reading the audit trail = %d, want 403", w.Code)
+	}
+	if w := logRequest(adminToken, "?user_id=abc"); w.Code != 400 {
+		t.Errorf("an invalid user_id = %d, want 400", w.Code)
+	}
+
+	entries := decodeBody[[]database.ModerationAction](t, logRequest(adminToken, "?moderator_id="+strconv.Itoa(moderator.Id)), 200)
+	if len(entries) != 1 || entries[0].Id != entry.Id {
+		t.Errorf("audit trail = %+v, want the hide", entries)
+	}
+	entries = decodeBody[[]database.ModerationAction](t, logRequest(adminToken, "?user_id=999"), 200)
+	if len(entries) != 0 {
+		t.Errorf("audit trail for another user = %+v, want nothing", entries)
+	}
+}
+
+func TestHiddenChirpsOnlyVisibleToAuthor(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	author := signUp(t, cfg, "author@example.com")
+	authorToken := logIn(t, cfg, author.Email)
+	otherToken := logIn(t, cfg, signUp(t, cfg, "other@example.com").Email)
+	_, moderatorToken := signUpWithRole(t, cfg, "moderator@example.com", database.ROLE_MODERATOR)
+
+	chirp := decodeBody[database.Chirp](t, postChirp(t, cfg, authorToken, "hidden soon"), 201)
+	decodeBody[database.ModerationAction](t, reviewChirp(t, cfg, moderatorToken, chirp.Id, database.MODERATION_HIDE), 200)
+
+	if w := getChirp(t, cfg, "", chirp.Id); w.Code != 404 {
+		t.Errorf("reading a hidden chirp anonymously = %d, want 404", w.Code)
+	}
+	if w := getChirp(t, cfg, otherToken, chirp.Id); w.Code != 404 {
+		t.Errorf("reading someone else's hidden chirp = %d, want 404", w.Code)
+	}
+	if w := getChirp(t, cfg, authorToken, chirp.Id); w.Code != 200 {
+		t.Errorf("the author reading their hidden chirp = %d, want 200", w.Code)
+	}
+	if w := reportChirp(t, cfg, otherToken, chirp.Id, database.REPORT_SPAM); w.Code != 404 {
+		t.Errorf("reporting a hidden chirp = %d, want 404", w.Code)
+	}
+
+	if chirps := listChirps(t, cfg, "", ""); len(chirps) != 0 {
+		t.Errorf("chirps listed anonymously = %+v, want the hidden one left out", chirps)
+	}
+	if chirps := listChirps(t, cfg, authorToken, ""); len(chirps) != 1 {
+		t.Errorf("chirps listed for the author = %+v, want their hidden one", chirps)
+	}
+
+	decodeBody[database.ModerationAction](t, reviewChirp(t, cfg, moderatorToken, chirp.Id, database.MODERATION_UNHIDE), 200)
+	if w := getChirp(t, cfg, otherToken, chirp.Id); w.Code != 200 {
+		t.Errorf("reading an unhidden chirp = %d, want 200", w.Code)
+	}
+}
+
+func TestAuthorDeletingAReportedChirp(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	author := signUp(t, cfg, "author@example.com")
+	authorToken := logIn(t, cfg, author.Email)
+	reporterToken := logIn(t, cfg, signUp(t, cfg, "reporter@example.com").Email)
+	_, moderatorToken := signUpWithRole(t, cfg, "moderator@example.com", database.ROLE_MODERATOR)
+
+	chirp := decodeBody[database.Chirp](t, postChirp(t, cfg, authorToken, "regrettable"), 201)
+	reportChirp(t, cfg, reporterToken, chirp.Id, database.REPORT_SPAM)
+
+	id := strconv.Itoa(chirp.Id)
+	r := withURLParams(newRequest(t, http.MethodDelete, "/api/chirps/"+id, authorToken, nil), map[string]string{"chirpId": id})
+	if w := serve(cfg.deleteChirp, r); w.Code != 200 {
+		t.Fatalf("deleting = %d, want 200", w.Code)
+	}
+
+	next := decodeBody[database.Chirp](t, postChirp(t, cfg, authorToken, "innocent"), 201)
+	if next.Id == chirp.Id {
+		t.Fatalf("a new chirp reused the deleted chirp's id %d", chirp.Id)
+	}
+
+	r = newRequest(t, http.MethodGet, "/api/moderation/queue", moderatorToken, nil)
+	queue := decodeBody[[]database.QueuedChirp](t, serveWithRole(cfg, cfg.getModerationQueue, r, database.ROLE_MODERATOR), 200)
+	if len(queue) != 0 {
+		t.Errorf("queue after the author deleted the chirp = %+v, want it empty", queue)
+	}
+}
@@ -2,36 +2,201 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+	"github.com/thegouge/go-chirpy/internal/moderation"
 )
 
-func respondWithError(w http.ResponseWriter, code int, msg string) {
-	type errResponse struct {
-		Error string `json:"error"`
+const problemContentType = "application/problem+json"
+
+// problem is an RFC 7807 error body. Code is a stable, machine readable name for what went wrong,
+// and Error repeats Detail for clients written before errors were problems
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance"`
+	Code      string       `json:"code"`
+	RequestId string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+	Error     string       `json:"error"`
+
+	// Moderation lists everything moderation found in a rejected chirp
+	Moderation []moderation.Finding `json:"moderation,omitempty"`
+}
+
+// fieldError says what's wrong with one field of a request. It's also an error,
+// so validation helpers can hand it back for respondWithErrorFrom
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (f fieldError) Error() string {
+	return f.Message
+}
+
+// statusCodes are the codes used when nothing more specific is known about an error
+var statusCodes = map[int]string{
+	400: "bad_request",
+	401: "unauthorized",
+	403: "forbidden",
+	404: "not_found",
+	409: "conflict",
+	422: "unprocessable",
+	429: "rate_limited",
+	500: "internal_error",
+	503: "unavailable",
+}
+
+// kindStatuses maps the database's kinds of error to a status and code
+var kindStatuses = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{database.ErrNotFound, 404, "not_found"},
+	{database.ErrConflict, 409, "conflict"},
+	{database.ErrForbidden, 403, "forbidden"},
+	{database.ErrUnauthorized, 401, "unauthorized"},
+	{database.ErrInvalid, 400, "invalid_request"},
+}
+
+// errorCodes are more specific codes for errors clients are likely to want to handle
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{database.ErrEmailTaken, "email_taken"},
+	{database.ErrBlocked, "blocked"},
+	{database.ErrAlreadyReported, "already_reported"},
+	{database.ErrWebhookInFlight, "in_flight"},
+}
+
+// respondWithProblem sends p back as problem+json, logging it against the request:
+// server errors at error level, and anything the client did wrong at info
+func respondWithProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Code == "" {
+		p.Code = statusCode(p.Status)
 	}
+	p.Instance = r.URL.Path
+	p.RequestId = w.Header().Get(requestIdHeader)
+	p.Error = p.Detail
 
-	responseStruct := errResponse{
-		Error: msg,
+	level := slog.LevelInfo
+	if p.Status >= 500 {
+		level = slog.LevelError
 	}
+	loggerFrom(r.Context()).Log(r.Context(), level, p.Detail, "status", p.Status, "code", p.Code)
 
-	fmt.Println(msg)
-	respondWithJson(w, code, responseStruct)
+	writeJson(w, p.Status, problemContentType, p)
+}
+
+// respondWithError sends back a problem with the default code for its status
+func respondWithError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	respondWithProblem(w, r, problem{Status: status, Detail: msg})
+}
+
+// respondWithCode sends back a problem with a specific code
+func respondWithCode(w http.ResponseWriter, r *http.Request, status int, code string, msg string) {
+	respondWithProblem(w, r, problem{Status: status, Code: code, Detail: msg})
+}
+
+// respondWithErrorFrom answers with the status matching the kind of err. Anything that isn't
+// the caller's fault is logged but not sent back, since it can include internal details
+func respondWithErrorFrom(w http.ResponseWriter, r *http.Request, err error) {
+	var invalidField fieldError
+	if errors.As(err, &invalidField) {
+		respondWithFieldErrors(w, r, invalidField)
+		return
+	}
+
+	if errors.Is(err, database.ErrClosed) {
+		respondWithCode(w, r, 503, "unavailable", "The server is shutting down, try again shortly")
+		return
+	}
+
+	if respondIfRestricted(w, r, err) || respondIfLocked(w, r, err) {
+		return
+	}
+
+	for _, kind := range kindStatuses {
+		if !errors.Is(err, kind.kind) {
+			continue
+		}
+
+		code := kind.code
+		for _, specific := range errorCodes {
+			if errors.Is(err, specific.err) {
+				code = specific.code
+			}
+		}
+
+		respondWithCode(w, r, kind.status, code, err.Error())
+		return
+	}
+
+	loggerFrom(r.Context()).Error("Unexpected error", "error", err)
+	respondWithError(w, r, 500, "Something went wrong")
+}
+
+// respondWithInvalidJSON answers a request body that couldn't be decoded
+func respondWithInvalidJSON(w http.ResponseWriter, r *http.Request, err error) {
+	respondWithCode(w, r, 400, "invalid_json", fmt.Sprintf("The request body isn't valid JSON: %v", err))
+}
+
+// respondWithFieldErrors answers a request with fields that failed validation
+func respondWithFieldErrors(w http.ResponseWriter, r *http.Request, fields ...fieldError) {
+	messages := []string{}
+	for _, field := range fields {
+		messages = append(messages, field.Message)
+	}
+
+	respondWithProblem(w, r, problem{
+		Status: 400,
+		Code:   "validation_failed",
+		Detail: strings.Join(messages, ", "),
+		Errors: fields,
+	})
+}
+
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "bad_request"
 }
 
 func respondWithJson(w http.ResponseWriter, code int, payload interface{}) {
+	writeJson(w, code, "application/json", payload)
+}
+
+// writeJson sets the content type before the status, since headers set afterwards aren't sent
+func writeJson(w http.ResponseWriter, code int, contentType string, payload interface{}) {
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("Error marshalling JSON", "error", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	if code != 200 {
-		w.WriteHeader(code)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
 	w.Write(dat)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// respondIfRestricted answers with a 403 explaining why if err is a RestrictedError
+func respondIfRestricted(w http.ResponseWriter, r *http.Request, err error) bool {
+	var restricted *database.RestrictedError
+	if !errors.As(err, &restricted) {
+		return false
+	}
+
+	respondWithCode(w, r, 403, "account_restricted", restricted.Error())
+
+	return true
+}
+
+type restrictionParams struct {
+	Status          string `json:"status"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Reason          string `json:"reason"`
+}
+
+type userRestrictionResponse struct {
+	Id          int                   `json:"id"`
+	Email       string                `json:"email"`
+	Restriction *database.Restriction `json:"restriction"`
+}
+
+func (cfg *apiConfig) restrictUser(w http.ResponseWriter, r *http.Request) {
+	param := chi.URLParam(r, "userId")
+	userId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := restrictionParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	if !database.ValidRestriction(params.Status) {
+		respondWithFieldErrors(w, r, unknownValue("status", "status", params.Status, database.AllRestrictions))
+		return
+	}
+
+	var until *time.Time
+	if params.Status == database.RESTRICTION_SUSPENDED {
+		if params.DurationSeconds <= 0 {
+			respondWithFieldErrors(w, r, fieldError{Field: "duration_seconds", Code: "out_of_range", Message: "Suspensions need a positive duration_seconds"})
+			return
+		}
+
+		end := time.Now().UTC().Add(time.Duration(params.DurationSeconds) * time.Second)
+		until = &end
+	}
+
+	admin, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	if admin.UserId == userId {
+		respondWithError(w, r, 400, "You can't restrict yourself")
+		return
+	}
+
+	user, err := cfg.db.RestrictUser(admin.UserId, userId, params.Status, until, params.Reason)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, userRestrictionResponse{Id: user.Id, Email: user.Email, Restriction: user.Restriction})
+}
+
+func (cfg *apiConfig) liftRestriction(w http.ResponseWriter, r *http.Request) {
+	param := chi.URLParam(r, "userId")
+	userId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	admin, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	err = cfg.db.LiftRestriction(admin.UserId, userId, r.URL.Query().Get("note"))
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, nil)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+func restrictUser(t *testing.T, cfg *apiConfig, token string, userId int, params restrictionParams) *httptest.ResponseRecorder {
+	t.Helper()
+
+	id := strconv.Itoa(userId)
+	r := newRequest(t, http.MethodPut, "/admin/users/"+id+"/restriction", token, params)
+
+	return serveWithRole(cfg, cfg.restrictUser, withURLParams(r, map[string]string{"userId": id}), database.ROLE_ADMIN)
+}
+
+func liftRestriction(t *testing.T, cfg *apiConfig, token string, userId int) *httptest.ResponseRecorder {
+	t.Helper()
+
+	id := strconv.Itoa(userId)
+	r := newRequest(t, http.MethodDelete, "/admin/users/"+id+"/restriction?note=appealed", token, nil)
+
+	return serveWithRole(cfg, cfg.liftRestriction, withURLParams(r, map[string]string{"userId": id}), database.ROLE_ADMIN)
+}
+
+func TestRestrictUserValidation(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	userToken := logIn(t, cfg, user.Email)
+	admin, adminToken := signUpAdmin(t, cfg, "admin@example.com")
+
+	tests := []struct {
+		name   string
+		token  string
+		userId int
+		params restrictionParams
+		want   int
+	}{
+		{"not an admin", userToken, user.Id, restrictionParams{Status: database.RESTRICTION_BANNED}, 403},
+		{"unknown status", adminToken, user.Id, restrictionParams{Status: "exiled"}, 400},
+		{"suspension without a duration", adminToken, user.Id, restrictionParams{Status: database.RESTRICTION_SUSPENDED}, 400},
+		{"restricting yourself", adminToken, admin.Id, restrictionParams{Status: database.RESTRICTION_BANNED}, 400},
+		{"missing user", adminToken, 999, restrictionParams{Status: database.RESTRICTION_BANNED}, 404},
+	}
+
+	for _, tt := range tests {
+		if w := restrictUser(t, cfg, tt.token, tt.userId, tt.params); w.Code != tt.want {
+			t.Errorf("%s: restricting = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestSuspensionLocksUserOut(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	userToken := logIn(t, cfg, user.Email)
+	_, adminToken := signUpAdmin(t, cfg, "admin@example.com")
+
+	w := restrictUser(t, cfg, adminToken, user.Id, restrictionParams{Status: database.RESTRICTION_SUSPENDED, DurationSeconds: 3600, Reason: "cool off"})
+	restricted := decodeBody[userRestrictionResponse](t, w, 200)
+	if restricted.Restriction == nil || restricted.Restriction.Until == nil {
+		t.Fatalf("restriction = %+v, want a suspension with an end", restricted.Restriction)
+	}
+
+	resp := logInAs(t, cfg, "192.0.2.1", user.Email, "password")
+	if resp.StatusCode != 403 {
+		t.Errorf("logging in while suspended = %d, want 403", resp.StatusCode)
+	}
+
+	// tokens from before the suspension stop working straight away
+	w = postChirp(t, cfg, userToken, "still here?")
+	if w.Code != 403 || !strings.Contains(w.Body.String(), "suspended until") {
+		t.Errorf("chirping while suspended = %d %s, want 403 saying until when", w.Code, w.Body.String())
+	}
+
+	if w := liftRestriction(t, cfg, adminToken, user.Id); w.Code != 200 {
+		t.Fatalf("lifting = %d, want 200", w.Code)
+	}
+
+	if resp := logInAs(t, cfg, "192.0.2.1", user.Email, "password"); resp.StatusCode != 200 {
+		t.Errorf("logging in once lifted = %d, want 200", resp.StatusCode)
+	}
+	if w := postChirp(t, cfg, userToken, "back"); w.Code != 201 {
+		t.Errorf("chirping once lifted = %d, want 201", w.Code)
+	}
+
+	entries, err := cfg.db.GetModerationLog(0, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != database.MODERATION_LIFT_RESTRICTION || entries[0].Note != "appealed" {
+		t.Errorf("audit trail = %+v, want the suspension and its lifting", entries)
+	}
+}
+
+func TestBanRejectsExistingTokens(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	userToken := logIn(t, cfg, user.Email)
+	_, adminToken := signUpAdmin(t, cfg, "admin@example.com")
+
+	decodeBody[userRestrictionResponse](t, restrictUser(t, cfg, adminToken, user.Id, restrictionParams{Status: database.RESTRICTION_BANNED}), 200)
+
+	w := postChirp(t, cfg, userToken, "hello")
+	if w.Code != 403 || !strings.Contains(w.Body.String(), "banned") {
+		t.Errorf("chirping while banned = %d %s, want 403", w.Code, w.Body.String())
+	}
+
+	r := newRequest(t, http.MethodPost, "/api/verify-email/resend", userToken, nil)
+	if w := serve(cfg.resendVerificationEmail, r); w.Code != 401 {
+		t.Errorf("managing the account while banned = %d, want 401", w.Code)
+	}
+}
+
+func TestShadowBanHidesChirpsFromEveryoneElse(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	userToken := logIn(t, cfg, user.Email)
+	otherToken := logIn(t, cfg, signUp(t, cfg, "other@example.com").Email)
+	_, adminToken := signUpAdmin(t, cfg, "admin@example.com")
+
+	before := decodeBody[database.Chirp](t, postChirp(t, cfg, userToken, "before"), 201)
+	decodeBody[userRestrictionResponse](t, restrictUser(t, cfg, adminToken, user.Id, restrictionParams{Status: database.RESTRICTION_SHADOW_BANNED}), 200)
+
+	// nothing changes for the shadow banned user
+	if resp := logInAs(t, cfg, "192.0.2.1", user.Email, "password"); resp.StatusCode != 200 {
+		t.Errorf("logging in while shadow banned = %d, want 200", resp.StatusCode)
+	}
+	after := decodeBody[database.Chirp](t, postChirp(t, cfg, userToken, "after"), 201)
+	if chirps := listChirps(t, cfg, userToken, ""); len(chirps) != 2 {
+		t.Errorf("the shadow banned user sees %d of their chirps, want 2", len(chirps))
+	}
+	if w := getChirp(t, cfg, userToken, after.Id); w.Code != 200 {
+		t.Errorf("the shadow banned user reading their chirp = %d, want 200", w.Code)
+	}
+
+	// but nobody else sees any of their chirps
+	for _, token := range []string{"", otherToken} {
+		if chirps := listChirps(t, cfg, token, ""); len(chirps) != 0 {
+			t.Errorf("chirps listed for someone else = %+v, want none", chirps)
+		}
+		for _, chirp := range []database.Chirp{before, after} {
+			if w := getChirp(t, cfg, token, chirp.Id); w.Code != 404 {
+				t.Errorf("someone else reading chirp %d = %d, want 404", chirp.Id, w.Code)
+			}
+		}
+	}
+	if w := reportChirp(t, cfg, otherToken, after.Id, database.REPORT_SPAM); w.Code != 404 {
+		t.Errorf("reporting a shadow banned chirp = %d, want 404", w.Code)
+	}
+
+	decodeBody[struct{}](t, liftRestriction(t, cfg, adminToken, user.Id), 200)
+	if chirps := listChirps(t, cfg, otherToken, ""); len(chirps) != 2 {
+		t.Errorf("chirps listed once lifted = %d, want 2", len(chirps))
+	}
+}
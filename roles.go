@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+type roleParams struct {
+	Role string `json:"role"`
+}
+
+type userRoleResponse struct {
+	Id    int    `json:"id"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (cfg *apiConfig) setUserRole(w http.ResponseWriter, r *http.Request) {
+	param := chi.URLParam(r, "userId")
+	userId, err := strconv.Atoi(param)
+	if err != nil {
+		respondWithCode(w, r, 400, "invalid_parameter", fmt.Sprintf("Error parsing parameter: %v", err))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := roleParams{}
+
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithInvalidJSON(w, r, err)
+		return
+	}
+
+	if !database.ValidRole(params.Role) {
+		respondWithFieldErrors(w, r, unknownValue("role", "role", params.Role, database.AllRoles))
+		return
+	}
+
+	admin, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	if admin.UserId == userId && params.Role != database.ROLE_ADMIN {
+		respondWithError(w, r, 400, "You can't remove your own admin role")
+		return
+	}
+
+	user, err := cfg.db.SetUserRole(userId, params.Role)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	respondWithJson(w, 200, userRoleResponse{
+		Id:    user.Id,
+		Email: user.Email,
+		Role:  user.RoleOrDefault(),
+	})
+}
+
+// createAdmin is the create-admin subcommand, which makes the first admin since nobody can do it over the API yet
+// It promotes an existing user, or creates a new one with a password read from stdin,
+// so the password never ends up in shell history or the process list
+func createAdmin(db *database.DB, args []string, stdin io.Reader) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := flags.String("email", "", "Email of the user to make an admin")
+	flags.Parse(args)
+
+	if !validEmail(*email) {
+		return errors.New("create-admin needs a valid -email")
+	}
+
+	user, exists, err := db.GetUserByEmail(*email)
+	if err != nil {
+		return err
+	}
+
+	userId := user.Id
+	if !exists {
+		fmt.Fprintf(os.Stderr, "No user with email %s exists yet, enter a password to create one: ", *email)
+
+		password, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		password = strings.TrimRight(password, "\r\n")
+
+		if passwordErr, ok := passwordProblem(password); !ok {
+			return errors.New(passwordErr.Message)
+		}
+
+		created, err := db.CreateUser(*email, password)
+		if err != nil {
+			return err
+		}
+
+		userId = created.Id
+	}
+
+	_, err = db.SetUserRole(userId, database.ROLE_ADMIN)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (user %d) is now an admin\n", *email, userId)
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+func setRole(t *testing.T, cfg *apiConfig, token string, userId int, role string) *http.Response {
+	t.Helper()
+
+	id := strconv.Itoa(userId)
+	r := newRequest(t, http.MethodPut, "/admin/users/"+id+"/role", token, roleParams{Role: role})
+	r = withURLParams(r, map[string]string{"userId": id})
+
+	return serveWithRole(cfg, cfg.setUserRole, r, database.ROLE_ADMIN).Result()
+}
+
+func TestAdminRoutesNeedAdminRole(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	user := signUp(t, cfg, "user@example.com")
+	userToken := logIn(t, cfg, user.Email)
+
+	if resp := setRole(t, cfg, "", user.Id, database.ROLE_ADMIN); resp.StatusCode != 401 {
+		t.Errorf("setting a role while logged out = %d, want 401", resp.StatusCode)
+	}
+	if resp := setRole(t, cfg, userToken, user.Id, database.ROLE_ADMIN); resp.StatusCode != 403 {
+		t.Errorf("a user making themselves admin = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	admin, adminToken := signUpAdmin(t, cfg, "admin@example.com")
+	user := signUp(t, cfg, "user@example.com")
+
+	if resp := setRole(t, cfg, adminToken, user.Id, "superuser"); resp.StatusCode != 400 {
+		t.Errorf("setting an unknown role = %d, want 400", resp.StatusCode)
+	}
+	if resp := setRole(t, cfg, adminToken, 999, database.ROLE_MODERATOR); resp.StatusCode != 404 {
+		t.Errorf("setting a missing user's role = %d, want 404", resp.StatusCode)
+	}
+	if resp := setRole(t, cfg, adminToken, admin.Id, database.ROLE_USER); resp.StatusCode != 400 {
+		t.Errorf("an admin demoting themselves = %d, want 400", resp.StatusCode)
+	}
+
+	if resp := setRole(t, cfg, adminToken, user.Id, database.ROLE_MODERATOR); resp.StatusCode != 200 {
+		t.Fatalf("promoting a user = %d, want 200", resp.StatusCode)
+	}
+
+	// the new role is in the user's tokens from their next login
+	ok, authUser, err := cfg.db.AuthenticateUser(user.Email, "password", cfg.secret, 0, "192.0.2.1")
+	if err != nil || !ok || authUser.Role != database.ROLE_MODERATOR {
+		t.Errorf("logging in after a promotion = %v, %+v, %v, want the moderator role", ok, authUser, err)
+	}
+}
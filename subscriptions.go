@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+const subscriptionExpiryInterval = time.Minute
+
+// runSubscriptionExpiry periodically marks lapsed Chirpy Red subscriptions as expired until ctx is done
+func runSubscriptionExpiry(ctx context.Context, db *database.DB) {
+	ticker := time.NewTicker(subscriptionExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := db.ExpireSubscriptions()
+		if err != nil {
+			slog.Error("Error expiring subscriptions", "error", err)
+		} else if expired > 0 {
+			slog.Info("Expired Chirpy Red subscriptions", "count", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
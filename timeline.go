@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/thegouge/go-chirpy/internal/database"
+)
+
+// getTimeline returns chirps from the caller and everyone they follow, newest first
+func (cfg *apiConfig) getTimeline(w http.ResponseWriter, r *http.Request) {
+	caller, ok := cfg.requireScope(w, r, database.SCOPE_CHIRPS_READ)
+	if !ok {
+		return
+	}
+
+	following, err := cfg.db.GetFollowing(caller.UserId)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	allChirps, err := cfg.db.GetChirps()
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	visibility, err := cfg.chirpVisibilityFor(&caller)
+	if err != nil {
+		respondWithErrorFrom(w, r, err)
+		return
+	}
+
+	timeline := []database.Chirp{}
+	for _, chirp := range allChirps {
+		if (chirp.AuthorId == caller.UserId || following[chirp.AuthorId]) && visibility.inFeed(chirp) {
+			timeline = append(timeline, chirp)
+		}
+	}
+
+	sort.Slice(timeline, func(i, j int) bool {
+		return timeline[i].Id > timeline[j].Id
+	})
+
+	respondWithJson(w, 200, timeline)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsPort int
+		target    string
+		host      string
+		want      string
+	}{
+		{"default port", 443, "/api/chirps?author_id=1", "example.com", "https://example.com/api/chirps?author_id=1"},
+		{"drops the plain HTTP port", 443, "/app", "example.com:80", "https://example.com/app"},
+		{"other HTTPS port", 8443, "/app", "example.com:8080", "https://example.com:8443/app"},
+		{"IPv6 with a port", 8443, "/", "[::1]:8080", "https://[::1]:8443/"},
+		{"IPv6 default port", 443, "/", "[::1]:80", "https://[::1]/"},
+		{"IPv6 without a port", 443, "/", "[::1]", "https://[::1]/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+
+			httpsRedirectHandler(tt.httpsPort).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusPermanentRedirect)
+			}
+			if got := rec.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	tests := []struct {
+		name              string
+		maxAge            time.Duration
+		includeSubdomains bool
+		tls               bool
+		want              string
+	}{
+		{"over HTTPS", time.Hour, false, true, "max-age=3600"},
+		{"with subdomains", time.Hour, true, true, "max-age=3600; includeSubDomains"},
+		{"over plain HTTP", time.Hour, true, false, ""},
+		{"turned off", 0, false, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			rec := httptest.NewRecorder()
+
+			handler := middlewareHSTS(tt.maxAge, tt.includeSubdomains)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Strict-Transport-Security"); got != tt.want {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
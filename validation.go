@@ -0,0 +1,34 @@
+package main
+
+import "fmt"
+
+// maxPasswordBytes is as much of a password as bcrypt will hash
+const maxPasswordBytes = 72
+
+var invalidEmail = fieldError{Field: "email", Code: "invalid_email", Message: "That isn't a valid Email"}
+
+func requiredField(field string, message string) fieldError {
+	return fieldError{Field: field, Code: "required", Message: message}
+}
+
+// unknownValue is for a field that has to be one of a fixed set, where noun is what the values are
+func unknownValue(field string, noun string, value string, allowed []string) fieldError {
+	return fieldError{Field: field, Code: "unknown_value", Message: fmt.Sprintf("Unknown %s %q, expected one of %v", noun, value, allowed)}
+}
+
+// passwordProblem checks a new password, returning what's wrong with it if it won't do
+func passwordProblem(password string) (fieldError, bool) {
+	if password == "" {
+		return requiredField("password", "A password is required"), false
+	}
+
+	if len(password) > maxPasswordBytes {
+		return fieldError{Field: "password", Code: "too_long", Message: fmt.Sprintf("Passwords can't be longer than %d bytes", maxPasswordBytes)}, false
+	}
+
+	return fieldError{}, true
+}
+
+func chirpTooLong(authorPerks perks) fieldError {
+	return fieldError{Field: "body", Code: "too_long", Message: fmt.Sprintf("Chirp is too long, the limit is %d characters", authorPerks.MaxChirpLength)}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "github.com/thegouge/go-chirpy/internal/database"
+
+// chirpVisibility decides which chirps a viewer (nil when anonymous) gets to see
+type chirpVisibility struct {
+	viewer       *principal
+	shadowBanned map[int]bool
+	blockedBy    map[int]bool
+	muted        map[int]bool
+}
+
+func (cfg *apiConfig) chirpVisibilityFor(viewer *principal) (chirpVisibility, error) {
+	visibility := chirpVisibility{viewer: viewer, blockedBy: map[int]bool{}, muted: map[int]bool{}}
+
+	shadowBanned, err := cfg.db.GetShadowBannedUsers()
+	if err != nil {
+		return chirpVisibility{}, err
+	}
+	visibility.shadowBanned = shadowBanned
+
+	if viewer == nil {
+		return visibility, nil
+	}
+
+	visibility.blockedBy, err = cfg.db.GetBlockers(viewer.UserId)
+	if err != nil {
+		return chirpVisibility{}, err
+	}
+
+	mutes, err := cfg.db.GetMutes(viewer.UserId)
+	if err != nil {
+		return chirpVisibility{}, err
+	}
+	for _, mute := range mutes {
+		visibility.muted[mute.MutedId] = true
+	}
+
+	return visibility, nil
+}
+
+// canSee hides chirps taken down by moderators and chirps by shadow banned users from everyone but their author,
+// and chirps from anyone who has blocked the viewer
+func (v chirpVisibility) canSee(chirp database.Chirp) bool {
+	if v.viewer != nil && v.viewer.UserId == chirp.AuthorId {
+		return true
+	}
+
+	return !chirp.Hidden && !v.shadowBanned[chirp.AuthorId] && !v.blockedBy[chirp.AuthorId]
+}
+
+// inFeed is canSee, also leaving out authors the viewer has muted
+func (v chirpVisibility) inFeed(chirp database.Chirp) bool {
+	return v.canSee(chirp) && !v.muted[chirp.AuthorId]
+}